@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"google.golang.org/grpc"
+)
+
+// apiPrincipal é quem chama a API. Cada principal tem o seu certificado inscrito no Fabric CA
+// e as transações são assinadas com ele, por isso o chaincode vê quem chama e não o gateway.
+// Role, PatientID e HealthcareProfessionalID vêm dos atributos do certificado (os mesmos
+// que o chaincode lê), para a API e o chaincode nunca discordarem sobre quem é o principal.
+type apiPrincipal struct {
+	Name     string `json:"name"`
+	CertPath string `json:"certPath"`
+	KeyPath  string `json:"keyPath"`
+	MSPID    string `json:"mspID"` // mspID por omissão
+
+	Role                     string `json:"-"`
+	PatientID                string `json:"-"`
+	HealthcareProfessionalID string `json:"-"`
+
+	id       identity.Identity
+	sign     identity.Sign
+	contract *client.Contract
+}
+
+func (p apiPrincipal) isAdmin() bool {
+	return p.Role == "admin"
+}
+
+// Como no chaincode, os administradores também são auditores.
+func (p apiPrincipal) isAuditor() bool {
+	return p.Role == "auditor" || p.Role == "admin"
+}
+
+func (p apiPrincipal) isPatient(patientID string) bool {
+	return patientID != "" && p.PatientID == patientID
+}
+
+func (p apiPrincipal) isHealthcareProfessional(healthcareProfessionalID string) bool {
+	return healthcareProfessionalID != "" && p.HealthcareProfessionalID == healthcareProfessionalID
+}
+
+// apiAccess diz se o principal pode fazer o pedido, normalmente comparando-o com os IDs do caminho.
+type apiAccess func(principal apiPrincipal, r *http.Request) bool
+
+var (
+	anyPrincipal apiAccess = func(principal apiPrincipal, r *http.Request) bool {
+		return true
+	}
+	patientAccess apiAccess = func(principal apiPrincipal, r *http.Request) bool {
+		return principal.isPatient(r.PathValue("patientID"))
+	}
+	// Para as transações em que o chaincode também aceita um administrador.
+	patientOrAdminAccess apiAccess = func(principal apiPrincipal, r *http.Request) bool {
+		return principal.isPatient(r.PathValue("patientID")) || principal.isAdmin()
+	}
+	patientOrAuditorAccess apiAccess = func(principal apiPrincipal, r *http.Request) bool {
+		return principal.isPatient(r.PathValue("patientID")) || principal.isAuditor()
+	}
+	healthcareProfessionalAccess apiAccess = func(principal apiPrincipal, r *http.Request) bool {
+		return principal.isHealthcareProfessional(r.PathValue("healthcareProfessionalID"))
+	}
+	adminAccess apiAccess = func(principal apiPrincipal, r *http.Request) bool {
+		return principal.isAdmin()
+	}
+	auditorAccess apiAccess = func(principal apiPrincipal, r *http.Request) bool {
+		return principal.isAuditor()
+	}
+)
+
+// apiPrincipals guarda os principais pelo hash SHA-256 (hex) do token, para o ficheiro não ter
+// os tokens: {"<sha256 do token>": {"name": "...", "certPath": "...", "keyPath": "..."}}.
+// O hash de um token novo obtém-se com: printf %s "$TOKEN" | sha256sum
+type apiPrincipals map[string]apiPrincipal
+
+func loadAPIPrincipals(path string) (apiPrincipals, error) {
+	principals := apiPrincipals{}
+	if err := readJSONFile(path, &principals); err != nil {
+		return nil, fmt.Errorf("failed to read API principals: %w", err)
+	}
+
+	// Sem principais ninguém conseguia entrar; é quase sempre um caminho errado.
+	if len(principals) == 0 {
+		return nil, fmt.Errorf("no API principals in %s", path)
+	}
+
+	normalized := apiPrincipals{}
+	for tokenHash, principal := range principals {
+		if _, err := hex.DecodeString(tokenHash); err != nil || len(tokenHash) != sha256.Size*2 {
+			return nil, fmt.Errorf("invalid token hash for API principal %s", principal.Name)
+		}
+
+		if err := principal.loadIdentity(); err != nil {
+			return nil, fmt.Errorf("failed to load identity for API principal %s: %w", principal.Name, err)
+		}
+
+		normalized[strings.ToLower(tokenHash)] = principal
+	}
+
+	return normalized, nil
+}
+
+// loadIdentity lê o certificado e a chave do principal e os atributos do certificado.
+func (p *apiPrincipal) loadIdentity() error {
+	if p.MSPID == "" {
+		p.MSPID = mspID
+	}
+
+	certificate, err := loadCertificate(p.CertPath)
+	if err != nil {
+		return err
+	}
+
+	attributes, err := certificateAttributes(certificate)
+	if err != nil {
+		return err
+	}
+	p.Role = attributes["role"]
+	p.PatientID = attributes["patientID"]
+	p.HealthcareProfessionalID = attributes["healthcareProfessionalID"]
+
+	p.id, err = identity.NewX509Identity(p.MSPID, certificate)
+	if err != nil {
+		return err
+	}
+
+	p.sign, err = loadSign(p.KeyPath)
+	return err
+}
+
+// O Fabric CA guarda os atributos do certificado nesta extensão, em JSON: {"attrs": {...}}.
+var fabricAttributesOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+func certificateAttributes(certificate *x509.Certificate) (map[string]string, error) {
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(fabricAttributesOID) {
+			continue
+		}
+
+		var attributes struct {
+			Attrs map[string]string `json:"attrs"`
+		}
+		if err := json.Unmarshal(extension.Value, &attributes); err != nil {
+			return nil, fmt.Errorf("invalid certificate attributes: %w", err)
+		}
+		return attributes.Attrs, nil
+	}
+
+	return map[string]string{}, nil
+}
+
+// connect liga cada principal ao gateway com a sua identidade, sobre a ligação gRPC partilhada.
+// A função devolvida fecha as ligações.
+func (p apiPrincipals) connect(clientConnection *grpc.ClientConn, channelName, chaincodeName string) (func(), error) {
+	var gateways []*client.Gateway
+	closeAll := func() {
+		for _, gw := range gateways {
+			gw.Close()
+		}
+	}
+
+	for tokenHash, principal := range p {
+		gw, err := newGatewayConnection(principal.id, principal.sign, clientConnection)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("failed to connect API principal %s: %w", principal.Name, err)
+		}
+		gateways = append(gateways, gw)
+
+		principal.contract = gw.GetNetwork(channelName).GetContract(chaincodeName)
+		p[tokenHash] = principal
+	}
+
+	return closeAll, nil
+}
+
+// authenticate devolve o principal do token "Authorization: Bearer <token>" do pedido.
+func (p apiPrincipals) authenticate(r *http.Request) (apiPrincipal, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return apiPrincipal{}, false
+	}
+
+	tokenHash := sha256.Sum256([]byte(token))
+	principal, ok := p[hex.EncodeToString(tokenHash[:])]
+
+	return principal, ok
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Códigos HTTP a partir das flags das respostas do chaincode.
+var (
+	professionalReadRules = []flagRule{
+		{"healthcareProfessionalHasAccess", false, http.StatusForbidden},
+	}
+	requestPatientMedicalDataRules = []flagRule{
+		// Neste pedido a flag quer dizer que o profissional já tem acesso.
+		{"healthcareProfessionalHasAccess", true, http.StatusConflict},
+		{"alreadyHavePendingRequest", true, http.StatusConflict},
+		{"requestSent", true, http.StatusCreated},
+	}
+	addPatientMedicalRecordRules = []flagRule{
+		{"healthcareProfessionalHasAccess", false, http.StatusForbidden},
+		{"healthRecordAlreadyExist", true, http.StatusConflict},
+		{"invalidRecordType", true, http.StatusUnprocessableEntity},
+		{"invalidSpeciality", true, http.StatusUnprocessableEntity},
+		{"invalidSignature", true, http.StatusUnprocessableEntity},
+		{"invalidSensitivityLabel", true, http.StatusUnprocessableEntity},
+		{"invalidObservations", true, http.StatusUnprocessableEntity},
+		{"invalidEncounter", true, http.StatusUnprocessableEntity},
+		{"invalidLinks", true, http.StatusUnprocessableEntity},
+		{"healthRecordAdded", true, http.StatusCreated},
+	}
+	addPatientReportedRecordRules = []flagRule{
+		{"healthRecordAlreadyExist", true, http.StatusConflict},
+		{"invalidRecordType", true, http.StatusUnprocessableEntity},
+		{"invalidObservations", true, http.StatusUnprocessableEntity},
+		{"healthRecordAdded", true, http.StatusCreated},
+	}
+	openEncounterRules = []flagRule{
+		{"healthcareProfessionalHasAccess", false, http.StatusForbidden},
+		{"encounterAlreadyExist", true, http.StatusConflict},
+		{"encounterOpened", true, http.StatusCreated},
+	}
+	// O chaincode devolve um registo vazio quando não existe.
+	healthRecordRules = []flagRule{
+		{"recordID", "", http.StatusNotFound},
+	}
+)
+
+func (s *apiServer) registerRoutes() {
+
+	// Paciente
+
+	s.handle("GET /patients/{patientID}/records", patientAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		options := p.medicalHistoryOptions()
+		pageSize, bookmark := p.page()
+		return p.call(apiCall{transaction: "GetMedicalHistory", args: []string{p.path("patientID"), options, pageSize, bookmark}})
+	})
+
+	s.handle("POST /patients/{patientID}/records", patientAccess, func(r *http.Request) (*apiCall, error) {
+		var body struct {
+			RecordID     string        `json:"recordID"`
+			Description  string        `json:"description"`
+			RecordType   string        `json:"recordType"`
+			EventDate    int64         `json:"eventDate"`
+			Observations []Observation `json:"observations"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+
+		p := newRequestParams(r)
+		p.require(map[string]string{"recordID": body.RecordID, "description": body.Description, "recordType": body.RecordType})
+		if body.Observations == nil {
+			body.Observations = []Observation{}
+		}
+
+		return p.call(apiCall{transaction: "AddPatientReportedRecord", submit: true, patientKey: true, rules: addPatientReportedRecordRules,
+			args: []string{body.RecordID, p.path("patientID"), body.Description, body.RecordType,
+				int64ToString(body.EventDate), p.json(body.Observations)}})
+	})
+
+	s.handle("GET /patients/{patientID}/records/by-code", patientAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "GetMedicalHistoryByCode",
+			args: []string{p.path("patientID"), p.requiredString("kind"), p.requiredString("code")}})
+	})
+
+	s.handle("GET /patients/{patientID}/records/{recordID}", patientAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "GetHealthRecordWithPatientByID", rules: healthRecordRules,
+			args: []string{p.path("patientID"), p.path("recordID")}})
+	})
+
+	s.handle("GET /patients/{patientID}/records/{recordID}/graph", patientAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "GetHealthRecordGraph", args: []string{p.path("patientID"), p.path("recordID")}})
+	})
+
+	s.handle("GET /patients/{patientID}/records/{recordID}/history", patientOrAuditorAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "GetHealthRecordHistory", args: []string{p.path("patientID"), p.path("recordID")}})
+	})
+
+	s.handle("POST /patients/{patientID}/records/{recordID}/disputes", patientAccess, func(r *http.Request) (*apiCall, error) {
+		var body struct {
+			Note string `json:"note"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+
+		p := newRequestParams(r)
+		p.require(map[string]string{"note": body.Note})
+		return p.call(apiCall{transaction: "DisputeHealthRecord", submit: true, patientKey: true,
+			args: []string{p.path("patientID"), p.path("recordID"), body.Note}})
+	})
+
+	s.handle("GET /patients/{patientID}/observations/{code}", patientAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "GetObservationSeries", args: []string{p.path("patientID"), p.path("code")}})
+	})
+
+	s.handle("GET /patients/{patientID}/encounters", patientAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "GetEncounters", args: []string{p.path("patientID")}})
+	})
+
+	s.handle("GET /patients/{patientID}/encounters/{encounterID}/records", patientAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "GetEncounterRecords", args: []string{p.path("patientID"), p.path("encounterID")}})
+	})
+
+	s.handle("GET /patients/{patientID}/requests", patientAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		pageSize, bookmark := p.page()
+		return p.call(apiCall{transaction: "GetRequestsWithPatient", args: []string{p.path("patientID"), pageSize, bookmark}})
+	})
+
+	s.handle("POST /patients/{patientID}/requests/{requestID}/answer", patientAccess, func(r *http.Request) (*apiCall, error) {
+		var body struct {
+			Accept            *bool    `json:"accept"`
+			SensitivityLabels []string `json:"sensitivityLabels"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+
+		p := newRequestParams(r)
+		if body.Accept == nil {
+			p.fail("missing field: accept")
+		}
+
+		// 1 aceita, 2 recusa.
+		response := 2
+		if body.Accept != nil && *body.Accept {
+			response = 1
+		}
+
+		if body.SensitivityLabels == nil {
+			body.SensitivityLabels = []string{}
+		}
+
+		return p.call(apiCall{transaction: "AnswerRequest", submit: true,
+			args: []string{intToString(response), p.path("requestID"), p.path("patientID"), p.json(body.SensitivityLabels)}})
+	})
+
+	s.handle("GET /patients/{patientID}/requests/{requestID}/history", patientOrAuditorAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "GetRequestHistory", args: []string{p.path("patientID"), p.path("requestID")}})
+	})
+
+	s.handle("GET /patients/{patientID}/accesses", patientAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		pageSize, bookmark := p.page()
+		return p.call(apiCall{transaction: "GetAccessesByPatientID", args: []string{p.path("patientID"), pageSize, bookmark}})
+	})
+
+	s.handle("DELETE /patients/{patientID}/accesses/{requestID}", patientAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "RemoveAccess", submit: true, args: []string{p.path("patientID"), p.path("requestID")}})
+	})
+
+	s.handle("GET /patients/{patientID}/accesses/{requestID}/history", patientOrAuditorAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "GetAccessHistory", args: []string{p.path("patientID"), p.path("requestID")}})
+	})
+
+	s.handle("GET /patients/{patientID}/access-log", patientOrAuditorAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		dateFrom, dateTo := p.int64("dateFrom"), p.int64("dateTo")
+		pageSize, bookmark := p.page()
+		return p.call(apiCall{transaction: "GetAccessLogByPatientID",
+			args: []string{p.path("patientID"), p.string("healthcareProfessionalID"), int64ToString(dateFrom), int64ToString(dateTo), pageSize, bookmark}})
+	})
+
+	s.handle("POST /patients/{patientID}/erasure", patientOrAdminAccess, func(r *http.Request) (*apiCall, error) {
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+
+		p := newRequestParams(r)
+		p.require(map[string]string{"reason": body.Reason})
+		return p.call(apiCall{transaction: "ErasePatientData", submit: true, status: http.StatusCreated,
+			args: []string{p.path("patientID"), body.Reason}})
+	})
+
+	s.handle("GET /patients/{patientID}/notification-preferences", patientOrAdminAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "GetNotificationPreferences", args: []string{p.path("patientID")}})
+	})
+
+	s.handle("PUT /patients/{patientID}/notification-preferences", patientOrAdminAccess, func(r *http.Request) (*apiCall, error) {
+		var body NotificationPreferences
+		if err := decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+
+		p := newRequestParams(r)
+		p.require(map[string]string{"language": body.Language})
+		if body.Channels == nil {
+			body.Channels = []string{}
+		}
+		if body.EventTypes == nil {
+			body.EventTypes = []string{}
+		}
+
+		return p.call(apiCall{transaction: "SetNotificationPreferences", submit: true,
+			args: []string{p.path("patientID"), p.json(body.Channels), body.QuietHoursStart, body.QuietHoursEnd,
+				p.json(body.EventTypes), body.Language}})
+	})
+
+	// Profissional de saúde
+
+	s.handle("POST /professionals/{healthcareProfessionalID}/requests", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		var body struct {
+			RequestID              string `json:"requestID"`
+			PatientID              string `json:"patientID"`
+			PatientName            string `json:"patientName"`
+			Description            string `json:"description"`
+			HealthcareProfessional string `json:"healthcareProfessional"`
+			ExpirationDate         int64  `json:"expirationDate"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+
+		p := newRequestParams(r)
+		p.require(map[string]string{"requestID": body.RequestID, "patientID": body.PatientID,
+			"description": body.Description, "healthcareProfessional": body.HealthcareProfessional})
+		if body.ExpirationDate <= time.Now().Unix() {
+			p.fail("expirationDate must be in the future")
+		}
+
+		return p.call(apiCall{transaction: "RequestPatientMedicalData", submit: true, rules: requestPatientMedicalDataRules,
+			args: []string{body.PatientID, body.PatientName, body.Description, p.path("healthcareProfessionalID"),
+				body.HealthcareProfessional, body.RequestID, int64ToString(body.ExpirationDate)}})
+	})
+
+	// Acesso de emergência: dá acesso imediato, sem resposta do paciente, e fica na auditoria com o motivo.
+	s.handle("POST /professionals/{healthcareProfessionalID}/patients/{patientID}/emergency-access", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		var body struct {
+			HealthcareProfessional string `json:"healthcareProfessional"`
+			Reason                 string `json:"reason"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+
+		p := newRequestParams(r)
+		p.require(map[string]string{"healthcareProfessional": body.HealthcareProfessional, "reason": body.Reason})
+		return p.call(apiCall{transaction: "GrantEmergencyAccess", submit: true,
+			args: []string{p.path("patientID"), p.path("healthcareProfessionalID"), body.HealthcareProfessional, body.Reason}})
+	})
+
+	s.handle("GET /professionals/{healthcareProfessionalID}/requests", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		pageSize, bookmark := p.page()
+		return p.call(apiCall{transaction: "GetRequestsWithHealthcareProfessional",
+			args: []string{p.path("healthcareProfessionalID"), pageSize, bookmark}})
+	})
+
+	s.handle("GET /professionals/{healthcareProfessionalID}/accesses", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		pageSize, bookmark := p.page()
+		return p.call(apiCall{transaction: "GetAccessesByHealthcareProfessionalID",
+			args: []string{p.path("healthcareProfessionalID"), pageSize, bookmark}})
+	})
+
+	s.handle("GET /professionals/{healthcareProfessionalID}/access-log", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		dateFrom, dateTo := p.int64("dateFrom"), p.int64("dateTo")
+		pageSize, bookmark := p.page()
+		return p.call(apiCall{transaction: "GetMyAccessLog",
+			args: []string{p.path("healthcareProfessionalID"), int64ToString(dateFrom), int64ToString(dateTo), pageSize, bookmark}})
+	})
+
+	s.handle("GET /professionals/{healthcareProfessionalID}/patients/{patientID}/records", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		options := p.medicalHistoryOptions()
+		pageSize, bookmark := p.page()
+		return p.professionalRead("GetPatientMedicalHistory", options, pageSize, bookmark)
+	})
+
+	s.handle("POST /professionals/{healthcareProfessionalID}/patients/{patientID}/records", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		var body struct {
+			RecordID               string             `json:"recordID"`
+			Description            string             `json:"description"`
+			HealthcareProfessional string             `json:"healthcareProfessional"`
+			Organization           string             `json:"organization"`
+			RecordType             string             `json:"recordType"`
+			Speciality             string             `json:"speciality"`
+			EventDate              int64              `json:"eventDate"`
+			Signature              string             `json:"signature"`
+			SensitivityLabel       string             `json:"sensitivityLabel"`
+			Observations           []Observation      `json:"observations"`
+			EncounterID            string             `json:"encounterID"`
+			Links                  []HealthRecordLink `json:"links"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+
+		p := newRequestParams(r)
+		p.require(map[string]string{"recordID": body.RecordID, "description": body.Description,
+			"healthcareProfessional": body.HealthcareProfessional, "organization": body.Organization,
+			"recordType": body.RecordType, "speciality": body.Speciality})
+		if body.Observations == nil {
+			body.Observations = []Observation{}
+		}
+		if body.Links == nil {
+			body.Links = []HealthRecordLink{}
+		}
+
+		return p.call(apiCall{transaction: "AddPatientMedicalRecord", submit: true, patientKey: true, rules: addPatientMedicalRecordRules,
+			args: []string{body.RecordID, body.Description, p.path("healthcareProfessionalID"), body.HealthcareProfessional,
+				p.path("patientID"), body.Organization, body.RecordType, body.Speciality, int64ToString(body.EventDate),
+				body.Signature, body.SensitivityLabel, p.json(body.Observations), body.EncounterID, p.json(body.Links)}})
+	})
+
+	s.handle("GET /professionals/{healthcareProfessionalID}/patients/{patientID}/records/by-code", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.professionalRead("GetPatientMedicalHistoryByCode", p.requiredString("kind"), p.requiredString("code"))
+	})
+
+	s.handle("GET /professionals/{healthcareProfessionalID}/patients/{patientID}/records/{recordID}", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.professionalRead("GetHealthRecordWithHealthcareProfessionalByID", p.path("recordID"))
+	})
+
+	s.handle("GET /professionals/{healthcareProfessionalID}/patients/{patientID}/records/{recordID}/graph", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.professionalRead("GetPatientHealthRecordGraph", p.path("recordID"))
+	})
+
+	s.handle("POST /professionals/{healthcareProfessionalID}/patients/{patientID}/records/{recordID}/retraction", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+
+		p := newRequestParams(r)
+		p.require(map[string]string{"reason": body.Reason})
+		return p.call(apiCall{transaction: "RetractHealthRecord", submit: true, patientKey: true,
+			args: []string{p.path("patientID"), p.path("recordID"), p.path("healthcareProfessionalID"), body.Reason}})
+	})
+
+	s.handle("GET /professionals/{healthcareProfessionalID}/patients/{patientID}/observations/{code}", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.professionalRead("GetPatientObservationSeries", p.path("code"))
+	})
+
+	s.handle("GET /professionals/{healthcareProfessionalID}/patients/{patientID}/encounters", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.professionalRead("GetPatientEncounters")
+	})
+
+	s.handle("POST /professionals/{healthcareProfessionalID}/patients/{patientID}/encounters", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		var body struct {
+			EncounterID            string `json:"encounterID"`
+			EncounterType          string `json:"encounterType"`
+			Organization           string `json:"organization"`
+			HealthcareProfessional string `json:"healthcareProfessional"`
+			StartDate              int64  `json:"startDate"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+
+		p := newRequestParams(r)
+		p.require(map[string]string{"encounterID": body.EncounterID, "encounterType": body.EncounterType,
+			"organization": body.Organization, "healthcareProfessional": body.HealthcareProfessional})
+
+		return p.call(apiCall{transaction: "OpenEncounter", submit: true, rules: openEncounterRules,
+			args: []string{body.EncounterID, p.path("patientID"), body.EncounterType, body.Organization,
+				p.path("healthcareProfessionalID"), body.HealthcareProfessional, int64ToString(body.StartDate)}})
+	})
+
+	s.handle("POST /professionals/{healthcareProfessionalID}/patients/{patientID}/encounters/{encounterID}/close", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		var body struct {
+			EndDate int64 `json:"endDate"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "CloseEncounter", submit: true,
+			args: []string{p.path("encounterID"), p.path("patientID"), p.path("healthcareProfessionalID"), int64ToString(body.EndDate)}})
+	})
+
+	s.handle("GET /professionals/{healthcareProfessionalID}/patients/{patientID}/encounters/{encounterID}/records", healthcareProfessionalAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.professionalRead("GetPatientEncounterRecords", p.path("encounterID"))
+	})
+
+	s.handle("GET /professionals/{healthcareProfessionalID}/certificate", anyPrincipal, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "GetHealthcareProfessionalCertificate", args: []string{p.path("healthcareProfessionalID")}})
+	})
+
+	// Administração e auditoria
+
+	s.handle("PUT /professionals/{healthcareProfessionalID}/certificate", adminAccess, func(r *http.Request) (*apiCall, error) {
+		var body struct {
+			CertificatePEM string `json:"certificatePEM"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+
+		p := newRequestParams(r)
+		p.require(map[string]string{"certificatePEM": body.CertificatePEM})
+		return p.call(apiCall{transaction: "RegisterHealthcareProfessionalCertificate", submit: true,
+			args: []string{p.path("healthcareProfessionalID"), body.CertificatePEM}})
+	})
+
+	s.handle("GET /codes", anyPrincipal, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "GetCodes", args: []string{p.requiredString("kind")}})
+	})
+
+	s.handle("POST /codes", adminAccess, func(r *http.Request) (*apiCall, error) {
+		var body struct {
+			Kind       string          `json:"kind"`
+			System     string          `json:"system"`
+			Code       string          `json:"code"`
+			Display    string          `json:"display"`
+			Unit       string          `json:"unit"`
+			References []CodeReference `json:"references"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+
+		p := newRequestParams(r)
+		p.require(map[string]string{"kind": body.Kind, "system": body.System, "code": body.Code, "display": body.Display})
+		if body.References == nil {
+			body.References = []CodeReference{}
+		}
+
+		return p.call(apiCall{transaction: "AddCode", submit: true,
+			args: []string{body.Kind, body.System, body.Code, body.Display, body.Unit, p.json(body.References)}})
+	})
+
+	s.handle("PUT /codes/{kind}/{code}", adminAccess, func(r *http.Request) (*apiCall, error) {
+		var body struct {
+			Display    string          `json:"display"`
+			References []CodeReference `json:"references"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+
+		p := newRequestParams(r)
+		p.require(map[string]string{"display": body.Display})
+		if body.References == nil {
+			body.References = []CodeReference{}
+		}
+
+		return p.call(apiCall{transaction: "UpdateCode", submit: true,
+			args: []string{p.path("kind"), p.path("code"), body.Display, p.json(body.References)}})
+	})
+
+	s.handle("POST /codes/{kind}/{code}/reactivation", adminAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "ReactivateCode", submit: true, args: []string{p.path("kind"), p.path("code")}})
+	})
+
+	s.handle("DELETE /codes/{kind}/{code}", adminAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		return p.call(apiCall{transaction: "DeactivateCode", submit: true, args: []string{p.path("kind"), p.path("code")}})
+	})
+
+	// Reindexa uma página de objetos; repete-se com o bookmark devolvido até vir vazio.
+	s.handle("POST /admin/rebuild-indexes", adminAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		pageSize, bookmark := p.page()
+		return p.call(apiCall{transaction: "RebuildIndexes", submit: true, args: []string{pageSize, bookmark}})
+	})
+
+	s.handle("GET /audit/patients/{patientID}/records", auditorAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		pageSize, bookmark := p.page()
+		return p.call(apiCall{transaction: "GetMedicalHistoryForAudit", args: []string{p.path("patientID"), pageSize, bookmark}})
+	})
+
+	s.handle("GET /audit/patients/{patientID}/as-of", auditorAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		asOf := p.int64("asOf")
+		if asOf == 0 {
+			p.fail("missing query parameter: asOf")
+		}
+		return p.call(apiCall{transaction: "GetMedicalHistoryAsOf",
+			args: []string{p.path("patientID"), p.string("healthcareProfessionalID"), int64ToString(asOf)}})
+	})
+
+	// Sem patientID devolve os acontecimentos de todos os pacientes no período, por páginas.
+	s.handle("GET /audit/trail", auditorAccess, func(r *http.Request) (*apiCall, error) {
+		p := newRequestParams(r)
+		patientID, organization := p.string("patientID"), p.string("organization")
+		dateFrom, dateTo := p.int64("dateFrom"), p.int64("dateTo")
+		pageSize, bookmark := p.page()
+		return p.call(apiCall{transaction: "GetAuditTrail",
+			args: []string{patientID, organization, int64ToString(dateFrom), int64ToString(dateTo), pageSize, bookmark}})
+	})
+}
+
+// professionalRead submete a variante Audited de uma leitura do profissional sobre os dados do
+// paciente (patientID e healthcareProfessionalID vêm do caminho), que fica no registo de acessos
+// do paciente com a finalidade de ?purpose=, obrigatória em cada leitura.
+func (p *requestParams) professionalRead(transaction string, args ...string) (*apiCall, error) {
+	purpose := p.requiredString("purpose")
+
+	return p.call(apiCall{
+		transaction: transaction + "Audited",
+		args:        append(append([]string{p.path("patientID"), p.path("healthcareProfessionalID")}, args...), purpose),
+		rules:       professionalReadRules,
+		submit:      true,
+	})
+}
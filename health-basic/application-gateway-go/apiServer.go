@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Tamanho máximo do corpo dos pedidos à API.
+const maxRequestBodySize = 1 << 20
+
+// apiCall é a transação que um pedido HTTP vai chamar.
+// Sem status a resposta é 200, ou 204 se a transação não devolver nada.
+type apiCall struct {
+	transaction string
+	args        []string
+	submit      bool
+	patientKey  bool // a transação guarda registos, leva a chave do paciente (ver submitWithPatientKey)
+	rules       []flagRule
+	status      int
+}
+
+// flagRule dá o código HTTP quando um campo da resposta do chaincode tem o valor indicado
+// (ex.: healthcareProfessionalHasAccess a false -> 403). Ganha a primeira regra que bater.
+type flagRule struct {
+	field  string
+	value  any
+	status int
+}
+
+// Um erro de validação do pedido, devolvido como 400 sem chamar o chaincode.
+type validationError struct {
+	message string
+}
+
+func (e *validationError) Error() string {
+	return e.message
+}
+
+func invalidRequest(format string, args ...any) error {
+	return &validationError{message: fmt.Sprintf(format, args...)}
+}
+
+type apiRoute func(r *http.Request) (*apiCall, error)
+
+type apiServer struct {
+	principals apiPrincipals
+	mux        *http.ServeMux
+}
+
+func newAPIServer(principals apiPrincipals) *apiServer {
+	server := &apiServer{principals: principals, mux: http.NewServeMux()}
+	server.registerRoutes()
+	return server
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handle regista a rota. Os pedidos sem um token conhecido são recusados com 401 e os de
+// um principal a que access não dá acesso (ex.: outro patientID no caminho) com 403.
+func (s *apiServer) handle(pattern string, access apiAccess, route apiRoute) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := s.principals.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+
+		if !access(principal, r) {
+			writeError(w, http.StatusForbidden, "not allowed for this principal")
+			return
+		}
+
+		call, err := route(r)
+		if err != nil {
+			var invalid *validationError
+			if errors.As(err, &invalid) {
+				writeError(w, http.StatusBadRequest, invalid.message)
+				return
+			}
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// A transação é assinada pela identidade do principal.
+		var result []byte
+		if call.patientKey {
+			result, err = submitWithPatientKey(principal.contract, call.transaction, call.args...)
+		} else if call.submit {
+			result, err = principal.contract.SubmitTransaction(call.transaction, call.args...)
+		} else {
+			result, err = principal.contract.EvaluateTransaction(call.transaction, call.args...)
+		}
+		if err != nil {
+			statusCode, message := statusFromGatewayError(err)
+			writeError(w, statusCode, message)
+			return
+		}
+
+		if len(result) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(call.statusFor(result))
+		w.Write(result)
+	})
+}
+
+func (c *apiCall) statusFor(result []byte) int {
+	var fields map[string]any
+	if len(c.rules) > 0 && json.Unmarshal(result, &fields) == nil {
+		for _, rule := range c.rules {
+			if value, ok := fields[rule.field]; ok && reflect.DeepEqual(value, rule.value) {
+				return rule.status
+			}
+		}
+	}
+
+	if c.status != 0 {
+		return c.status
+	}
+
+	return http.StatusOK
+}
+
+// Os erros que o chaincode quer que o cliente trate começam por um destes prefixos (ver
+// errors.go no chaincode), sozinhos ou depois do contexto com que são embrulhados
+// ("failed to ...: not found: ..."). Os outros são falhas internas.
+var chaincodeErrorStatuses = []struct {
+	prefix string
+	status int
+}{
+	{"access denied: ", http.StatusForbidden},
+	{"not found: ", http.StatusNotFound},
+	{"conflict: ", http.StatusConflict},
+	{"gone: ", http.StatusGone},
+	{"invalid argument: ", http.StatusBadRequest},
+}
+
+// statusFromGatewayError traduz os erros do gateway e do chaincode em códigos HTTP.
+func statusFromGatewayError(err error) (int, string) {
+	message := chaincodeErrorMessage(err)
+
+	var commitErr *client.CommitError
+	if errors.As(err, &commitErr) {
+		return http.StatusConflict, message
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable, message
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout, message
+	}
+
+	for _, errorStatus := range chaincodeErrorStatuses {
+		if strings.HasPrefix(message, errorStatus.prefix) || strings.Contains(message, ": "+errorStatus.prefix) {
+			return errorStatus.status, message
+		}
+	}
+
+	return http.StatusInternalServerError, message
+}
+
+// chaincodeErrorMessage devolve a mensagem do chaincode, que vem nos detalhes do erro gRPC.
+func chaincodeErrorMessage(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if errorDetail, ok := detail.(*gateway.ErrorDetail); ok && errorDetail.GetMessage() != "" {
+			return errorDetail.GetMessage()
+		}
+	}
+
+	return err.Error()
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// decodeBody lê o corpo JSON do pedido, recusando campos desconhecidos.
+func decodeBody(r *http.Request, value any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxRequestBodySize))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(value); err != nil {
+		return invalidRequest("invalid request body: %v", err)
+	}
+
+	return nil
+}
+
+// requestParams lê os parâmetros do pedido e guarda o primeiro erro de validação.
+type requestParams struct {
+	r     *http.Request
+	query url.Values
+	err   error
+}
+
+func newRequestParams(r *http.Request) *requestParams {
+	return &requestParams{r: r, query: r.URL.Query()}
+}
+
+func (p *requestParams) fail(format string, args ...any) {
+	if p.err == nil {
+		p.err = invalidRequest(format, args...)
+	}
+}
+
+func (p *requestParams) path(name string) string {
+	return p.r.PathValue(name)
+}
+
+func (p *requestParams) string(name string) string {
+	return p.query.Get(name)
+}
+
+func (p *requestParams) requiredString(name string) string {
+	value := p.query.Get(name)
+	if value == "" {
+		p.fail("missing query parameter: %s", name)
+	}
+	return value
+}
+
+func (p *requestParams) int64(name string) int64 {
+	value := p.query.Get(name)
+	if value == "" {
+		return 0
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		p.fail("invalid %s: %s", name, value)
+	}
+	return parsed
+}
+
+func (p *requestParams) bool(name string) bool {
+	value := p.query.Get(name)
+	if value == "" {
+		return false
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		p.fail("invalid %s: %s", name, value)
+	}
+	return parsed
+}
+
+// page devolve o pageSize (20 por omissão, até 200) e o bookmark.
+func (p *requestParams) page() (string, string) {
+	pageSize := int64(20)
+	if p.query.Get("pageSize") != "" {
+		pageSize = p.int64("pageSize")
+	}
+
+	if pageSize < 1 || pageSize > 200 {
+		p.fail("pageSize must be between 1 and 200")
+	}
+
+	return int64ToString(pageSize), p.query.Get("bookmark")
+}
+
+// medicalHistoryOptions lê os filtros do histórico médico da query string.
+func (p *requestParams) medicalHistoryOptions() string {
+	return p.json(MedicalHistoryQueryOptions{
+		EventDateFrom:       p.int64("eventDateFrom"),
+		HasEventDateFrom:    p.query.Get("eventDateFrom") != "",
+		EventDateTo:         p.int64("eventDateTo"),
+		HasEventDateTo:      p.query.Get("eventDateTo") != "",
+		SpecialityCode:      p.string("specialityCode"),
+		RecordTypeCode:      p.string("recordTypeCode"),
+		Organization:        p.string("organization"),
+		AuthorID:            p.string("authorID"),
+		DescriptionContains: p.string("descriptionContains"),
+		SortBy:              p.string("sortBy"),
+		SortOrder:           p.string("sortOrder"),
+		IncludeRetracted:    p.bool("includeRetracted"),
+	})
+}
+
+// require valida os campos obrigatórios do corpo (nome -> valor).
+func (p *requestParams) require(fields map[string]string) {
+	for name, value := range fields {
+		if strings.TrimSpace(value) == "" {
+			p.fail("missing field: %s", name)
+		}
+	}
+}
+
+// call devolve a chamada, ou o primeiro erro de validação.
+func (p *requestParams) call(call apiCall) (*apiCall, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &call, nil
+}
+
+// json serializa um argumento da transação. Uma falha não é de validação, por isso dá 500.
+func (p *requestParams) json(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		if p.err == nil {
+			p.err = fmt.Errorf("failed to serialize argument: %w", err)
+		}
+		return ""
+	}
+	return string(data)
+}
+
+// runServeCommand trata de "serve [-addr :8080] [-principals api-principals.json]": arranca a
+// API e pára com SIGINT/SIGTERM, esperando pelos pedidos em curso. Sem principais não arranca.
+func runServeCommand(clientConnection *grpc.ClientConn, channelName, chaincodeName string, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", ":8080", "listen address")
+	principalsFile := flags.String("principals", "api-principals.json", "API principals by token hash (JSON)")
+	shutdownTimeout := flags.Duration("shutdown-timeout", 30*time.Second, "how long to wait for requests in progress")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	principals, err := loadAPIPrincipals(*principalsFile)
+	if err != nil {
+		return err
+	}
+
+	closePrincipals, err := principals.connect(clientConnection, channelName, chaincodeName)
+	if err != nil {
+		return err
+	}
+	defer closePrincipals()
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           newAPIServer(principals),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		fmt.Printf("\n--> API à escuta em %s\n", *addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	fmt.Println("*** A parar a API")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	return server.Shutdown(shutdownCtx)
+}
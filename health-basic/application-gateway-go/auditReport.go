@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+// Nomes dos ficheiros escritos na pasta do relatório.
+const (
+	auditReportJSONFile      = "audit-report.json"
+	auditReportCSVFile       = "audit-report.csv"
+	auditReportSignatureFile = "audit-report.sig.json"
+)
+
+// Tamanho das páginas pedidas ao GetAuditTrail ao gerar ou validar um relatório.
+const auditTrailPageSize = 100
+
+// Tem de ser igual ao AuditEvent do chaincode.
+type AuditEvent struct {
+	Type                     string   `json:"type"`
+	TxID                     string   `json:"txID"`
+	Timestamp                int64    `json:"timestamp"`
+	PatientID                string   `json:"patientID"`
+	HealthcareProfessionalID string   `json:"healthcareProfessionalID"`
+	RequestID                string   `json:"requestID"`
+	RecordIDs                []string `json:"recordIDs"`
+	Details                  string   `json:"details"`
+	WriterID                 string   `json:"writerID"`
+	WriterMSPID              string   `json:"writerMSPID"`
+}
+
+// AuditReport guarda os parâmetros com que foi gerado, para poder ser comparado mais tarde
+// com o que a ledger devolve. Sem PatientID é o relatório de todos os pacientes no período
+// (normalmente de uma organização).
+type AuditReport struct {
+	PatientID    string       `json:"patientID"`
+	Organization string       `json:"organization"`
+	DateFrom     int64        `json:"dateFrom"`
+	DateTo       int64        `json:"dateTo"`
+	GeneratedAt  int64        `json:"generatedAt"`
+	GeneratedBy  string       `json:"generatedBy"`
+	Events       []AuditEvent `json:"events"`
+}
+
+// O conteúdo assinado: os SHA-256 (hex) dos dois ficheiros do relatório.
+type auditReportDigests struct {
+	JSONSHA256 string `json:"jsonSHA256"`
+	CSVSHA256  string `json:"csvSHA256"`
+}
+
+type AuditReportSignature struct {
+	JSONSHA256  string `json:"jsonSHA256"`
+	CSVSHA256   string `json:"csvSHA256"`
+	MSPID       string `json:"mspID"`
+	Certificate string `json:"certificate"`
+	Signature   string `json:"signature"`
+}
+
+// getAuditTrail junta todas as páginas do GetAuditTrail, por ordem de data.
+func getAuditTrail(contract *client.Contract, patientID, organization string, dateFrom, dateTo int64) ([]AuditEvent, error) {
+	it := newPageIterator[AuditEvent](contract.EvaluateTransaction, auditTrailPageSize, "events", "GetAuditTrail",
+		patientID, organization, int64ToString(dateFrom), int64ToString(dateTo))
+
+	var events []AuditEvent
+	for it.HasNext() {
+		pageEvents, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, pageEvents...)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Timestamp != events[j].Timestamp {
+			return events[i].Timestamp < events[j].Timestamp
+		}
+		return events[i].TxID < events[j].TxID
+	})
+
+	return events, nil
+}
+
+// ExportAuditReport escreve o relatório em JSON e CSV na pasta indicada e assina-o com a
+// identidade do gateway. Sem dateTo o relatório vai até ao momento em que é gerado, para
+// continuar a bater certo com a ledger depois de novas transações.
+func ExportAuditReport(contract *client.Contract, id identity.Identity, sign identity.Sign,
+	patientID, organization string, dateFrom, dateTo int64, outputDir string) error {
+
+	generatedAt := time.Now().Unix()
+	if dateTo == 0 {
+		dateTo = generatedAt
+	}
+
+	events, err := getAuditTrail(contract, patientID, organization, dateFrom, dateTo)
+	if err != nil {
+		return err
+	}
+
+	report := AuditReport{
+		PatientID:    patientID,
+		Organization: organization,
+		DateFrom:     dateFrom,
+		DateTo:       dateTo,
+		GeneratedAt:  generatedAt,
+		GeneratedBy:  id.MspID(),
+		Events:       events,
+	}
+
+	reportJSON, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize audit report: %w", err)
+	}
+
+	reportCSV, err := auditEventsToCSV(events)
+	if err != nil {
+		return err
+	}
+
+	digests := auditReportDigests{
+		JSONSHA256: sha256Hex(reportJSON),
+		CSVSHA256:  sha256Hex(reportCSV),
+	}
+
+	content, err := json.Marshal(digests)
+	if err != nil {
+		return fmt.Errorf("failed to serialize audit report digests: %w", err)
+	}
+
+	digest := sha256.Sum256(content)
+
+	signature, err := sign(digest[:])
+	if err != nil {
+		return fmt.Errorf("failed to sign audit report: %w", err)
+	}
+
+	signatureJSON, err := json.MarshalIndent(AuditReportSignature{
+		JSONSHA256:  digests.JSONSHA256,
+		CSVSHA256:   digests.CSVSHA256,
+		MSPID:       id.MspID(),
+		Certificate: string(id.Credentials()),
+		Signature:   base64.StdEncoding.EncodeToString(signature),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize audit report signature: %w", err)
+	}
+
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+
+	files := map[string][]byte{
+		auditReportJSONFile:      reportJSON,
+		auditReportCSVFile:       reportCSV,
+		auditReportSignatureFile: signatureJSON,
+	}
+
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(outputDir, name), data, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// VerifyAuditReport confirma a assinatura dos ficheiros do relatório e volta a pedir os
+// acontecimentos à ledger, com os mesmos parâmetros, para ver se ainda são os mesmos.
+// O certificado que vem no audit-report.sig.json só é aceite se tiver sido emitido pela CA
+// indicada e for da organização esperada; senão qualquer um podia assinar um relatório
+// alterado com um certificado seu.
+func VerifyAuditReport(contract *client.Contract, reportDir string, caCertificate *x509.Certificate, expectedMSPID string) error {
+	reportJSON, err := os.ReadFile(filepath.Join(reportDir, auditReportJSONFile))
+	if err != nil {
+		return fmt.Errorf("failed to read audit report: %w", err)
+	}
+
+	reportCSV, err := os.ReadFile(filepath.Join(reportDir, auditReportCSVFile))
+	if err != nil {
+		return fmt.Errorf("failed to read audit report: %w", err)
+	}
+
+	signatureJSON, err := os.ReadFile(filepath.Join(reportDir, auditReportSignatureFile))
+	if err != nil {
+		return fmt.Errorf("failed to read audit report signature: %w", err)
+	}
+
+	var reportSignature AuditReportSignature
+	if err := json.Unmarshal(signatureJSON, &reportSignature); err != nil {
+		return fmt.Errorf("failed to parse audit report signature: %w", err)
+	}
+
+	digests := auditReportDigests{
+		JSONSHA256: sha256Hex(reportJSON),
+		CSVSHA256:  sha256Hex(reportCSV),
+	}
+
+	if digests.JSONSHA256 != reportSignature.JSONSHA256 || digests.CSVSHA256 != reportSignature.CSVSHA256 {
+		return fmt.Errorf("audit report files were changed after signing")
+	}
+
+	var report AuditReport
+	if err := json.Unmarshal(reportJSON, &report); err != nil {
+		return fmt.Errorf("failed to parse audit report: %w", err)
+	}
+
+	certificate, err := identity.CertificateFromPEM([]byte(reportSignature.Certificate))
+	if err != nil {
+		return fmt.Errorf("invalid signer certificate: %w", err)
+	}
+
+	if reportSignature.MSPID != expectedMSPID {
+		return fmt.Errorf("audit report was signed by %s, expected %s", reportSignature.MSPID, expectedMSPID)
+	}
+
+	if err := verifyAuditReportSigner(certificate, caCertificate, time.Unix(report.GeneratedAt, 0)); err != nil {
+		return err
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(reportSignature.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	content, err := json.Marshal(digests)
+	if err != nil {
+		return fmt.Errorf("failed to serialize audit report digests: %w", err)
+	}
+
+	if err := verifySignature(certificate, content, signature); err != nil {
+		return fmt.Errorf("audit report: %w", err)
+	}
+
+	csvFromReport, err := auditEventsToCSV(report.Events)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(csvFromReport, reportCSV) {
+		return fmt.Errorf("audit report CSV does not match the JSON report")
+	}
+
+	events, err := getAuditTrail(contract, report.PatientID, report.Organization, report.DateFrom, report.DateTo)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(events, report.Events) {
+		return fmt.Errorf("audit report does not match the ledger")
+	}
+
+	return nil
+}
+
+// verifyAuditReportSigner confirma que o certificado foi emitido pela CA, com a validade
+// à data em que o relatório foi gerado.
+func verifyAuditReportSigner(certificate, caCertificate *x509.Certificate, signedAt time.Time) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(caCertificate)
+
+	_, err := certificate.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: signedAt,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("audit report signer is not trusted: %w", err)
+	}
+
+	return nil
+}
+
+func auditEventsToCSV(events []AuditEvent) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+
+	rows := [][]string{{"type", "timestamp", "date", "txID", "patientID", "healthcareProfessionalID",
+		"requestID", "recordIDs", "details", "writerID", "writerMSPID"}}
+
+	for _, event := range events {
+		rows = append(rows, []string{
+			event.Type,
+			int64ToString(event.Timestamp),
+			time.Unix(event.Timestamp, 0).UTC().Format(time.RFC3339),
+			event.TxID,
+			event.PatientID,
+			event.HealthcareProfessionalID,
+			event.RequestID,
+			strings.Join(event.RecordIDs, ";"),
+			event.Details,
+			event.WriterID,
+			event.WriterMSPID,
+		})
+	}
+
+	if err := writer.WriteAll(rows); err != nil {
+		return nil, fmt.Errorf("failed to write audit report CSV: %w", err)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func sha256Hex(data []byte) string {
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
+
+// runAuditReportCommand trata de "audit-report export|verify [opções]".
+// As datas são em segundos Unix, como no chaincode.
+func runAuditReportCommand(contract *client.Contract, id identity.Identity, sign identity.Sign, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: audit-report export|verify [options]")
+	}
+
+	flags := flag.NewFlagSet("audit-report "+args[0], flag.ContinueOnError)
+	patientID := flags.String("patient", "", "patient ID (all patients if empty)")
+	organization := flags.String("org", "", "only events submitted by this MSP ID")
+	dateFrom := flags.Int64("from", 0, "start date (Unix seconds)")
+	dateTo := flags.Int64("to", 0, "end date (Unix seconds, defaults to now)")
+	dir := flags.String("dir", "audit-report", "report directory")
+	caPath := flags.String("ca", caCertPath, "CA certificate that must have issued the report signer (verify)")
+	signerMSPID := flags.String("msp", mspID, "MSP ID that must have signed the report (verify)")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "export":
+		fmt.Println("\n--> Evaluate Transaction: Vamos exportar o relatório de auditoria")
+
+		if err := ExportAuditReport(contract, id, sign, *patientID, *organization, *dateFrom, *dateTo, *dir); err != nil {
+			return err
+		}
+		fmt.Printf("*** Relatório escrito em %s\n", *dir)
+	case "verify":
+		fmt.Println("\n--> Evaluate Transaction: Vamos validar o relatório de auditoria com a ledger")
+
+		caCertificate, err := loadCertificate(*caPath)
+		if err != nil {
+			return err
+		}
+
+		if err := VerifyAuditReport(contract, *dir, caCertificate, *signerMSPID); err != nil {
+			return err
+		}
+		fmt.Printf("*** Relatório válido\n")
+	default:
+		return fmt.Errorf("unknown audit-report command: %s", args[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+package main
+
+import "github.com/hyperledger/fabric-gateway/pkg/client"
+
+// As leituras dos dados do paciente pelos profissionais usam sempre as variantes Audited do
+// chaincode: são submetidas e ficam no registo de acessos da ledger, com a finalidade que o
+// profissional indica em cada leitura.
+
+// submitProfessionalRead submete a variante Audited da leitura, com a finalidade como último argumento.
+func submitProfessionalRead(contract *client.Contract, transaction, purpose string, args ...string) ([]byte, error) {
+	return contract.SubmitTransaction(transaction+"Audited", append(args, purpose)...)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// Tem de ser igual ao do chaincode (HealthEvent.go).
+const (
+	healthEventName          = "HealthEvents"
+	healthEventSchemaVersion = 1
+)
+
+type HealthEvent struct {
+	Type                     string   `json:"type"`
+	PatientID                string   `json:"patientID"`
+	HealthcareProfessionalID string   `json:"healthcareProfessionalID"`
+	RequestID                string   `json:"requestID"`
+	RecordID                 string   `json:"recordID"`
+	RecordIDs                []string `json:"recordIDs"`
+	EncounterID              string   `json:"encounterID"`
+	Status                   int      `json:"status"`
+	ExpirationDate           int64    `json:"expirationDate"`
+}
+
+type HealthEventBatch struct {
+	Version        int           `json:"version"`
+	TxID           string        `json:"txID"`
+	Timestamp      int64         `json:"timestamp"`
+	SubmitterMSPID string        `json:"submitterMSPID"`
+	Events         []HealthEvent `json:"events"`
+}
+
+// ContractEvent é um acontecimento do lote com os dados da transação e do bloco. EventIndex é
+// a posição do acontecimento no lote da transação.
+type ContractEvent struct {
+	BlockNumber    uint64 `json:"blockNumber"`
+	TransactionID  string `json:"transactionID"`
+	EventIndex     int    `json:"eventIndex"`
+	Timestamp      int64  `json:"timestamp"`
+	SubmitterMSPID string `json:"submitterMSPID"`
+	HealthEvent
+}
+
+type ContractEventHandler func(event ContractEvent) error
+
+// EventListener recebe os eventos do chaincode e chama os handlers registados para cada
+// tipo. Depois de tratar todos os acontecimentos de uma transação guarda o checkpoint,
+// por isso ao reiniciar continua na transação seguinte. Se um handler falhar o listener
+// pára sem guardar o checkpoint e a transação volta a ser entregue no próximo arranque.
+type EventListener struct {
+	network       *client.Network
+	chaincodeName string
+	checkpointer  *client.FileCheckpointer
+	handlers      map[string][]ContractEventHandler
+	retryDelay    time.Duration
+}
+
+func NewEventListener(network *client.Network, chaincodeName, checkpointFile string) (*EventListener, error) {
+	checkpointer, err := client.NewFileCheckpointer(checkpointFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint file: %w", err)
+	}
+
+	return &EventListener{
+		network:       network,
+		chaincodeName: chaincodeName,
+		checkpointer:  checkpointer,
+		handlers:      map[string][]ContractEventHandler{},
+		retryDelay:    5 * time.Second,
+	}, nil
+}
+
+// Handle regista um handler para um tipo de acontecimento ("RequestCreated", ...).
+// Com "*" o handler recebe todos os tipos.
+func (l *EventListener) Handle(eventType string, handler ContractEventHandler) {
+	l.handlers[eventType] = append(l.handlers[eventType], handler)
+}
+
+func (l *EventListener) Close() error {
+	return l.checkpointer.Close()
+}
+
+// Run fica a ouvir até o ctx ser cancelado, voltando a ligar (depois de retryDelay) se a
+// ligação cair ou não se conseguir ligar. Só devolve erro se um handler ou o checkpoint falhar.
+// Sem replayFromBlock continua a partir do checkpoint (ou dos blocos novos, se ainda
+// não houver checkpoint). Com replayFromBlock volta a entregar tudo a partir desse bloco,
+// para reconstruir o estado dos sistemas que recebem os eventos.
+func (l *EventListener) Run(ctx context.Context, replayFromBlock *uint64) error {
+	for {
+		options := []client.ChaincodeEventsOption{client.WithCheckpoint(l.checkpointer)}
+		if replayFromBlock != nil {
+			options = []client.ChaincodeEventsOption{client.WithStartBlock(*replayFromBlock)}
+		}
+
+		events, err := l.network.ChaincodeEvents(ctx, l.chaincodeName, options...)
+		if err != nil {
+			fmt.Printf("*** Failed to start chaincode event listening: %v\n", err)
+		} else {
+			for event := range events {
+				if err := l.dispatch(event); err != nil {
+					return err
+				}
+
+				if err := l.checkpointer.CheckpointChaincodeEvent(event); err != nil {
+					return fmt.Errorf("failed to store checkpoint: %w", err)
+				}
+
+				// Depois do primeiro evento o checkpoint já está à frente do bloco pedido.
+				replayFromBlock = nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(l.retryDelay):
+			fmt.Println("*** Ligação aos eventos perdida, a voltar a ligar")
+		}
+	}
+}
+
+func (l *EventListener) dispatch(event *client.ChaincodeEvent) error {
+	if event.EventName != healthEventName {
+		return nil
+	}
+
+	var batch HealthEventBatch
+	if err := json.Unmarshal(event.Payload, &batch); err != nil {
+		return fmt.Errorf("invalid event payload in transaction %s: %w", event.TransactionID, err)
+	}
+
+	if batch.Version > healthEventSchemaVersion {
+		return fmt.Errorf("unsupported event version %d in transaction %s", batch.Version, event.TransactionID)
+	}
+
+	for index, healthEvent := range batch.Events {
+		contractEvent := ContractEvent{
+			BlockNumber:    event.BlockNumber,
+			TransactionID:  event.TransactionID,
+			EventIndex:     index,
+			Timestamp:      batch.Timestamp,
+			SubmitterMSPID: batch.SubmitterMSPID,
+			HealthEvent:    healthEvent,
+		}
+
+		handlers := append(append([]ContractEventHandler{}, l.handlers[healthEvent.Type]...), l.handlers["*"]...)
+		for _, handler := range handlers {
+			if err := handler(contractEvent); err != nil {
+				return fmt.Errorf("failed to handle %s in transaction %s: %w", healthEvent.Type, event.TransactionID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// runListenCommand trata de "listen [-checkpoint ficheiro] [-from-block n] [-sinks ficheiro]".
+// Sem ficheiro de sinks os eventos são só mostrados no ecrã.
+func runListenCommand(network *client.Network, chaincodeName string, args []string) error {
+	flags := flag.NewFlagSet("listen", flag.ContinueOnError)
+	checkpointFile := flags.String("checkpoint", "events.checkpoint", "checkpoint file")
+	fromBlock := flags.Int64("from-block", -1, "replay events from this block")
+	sinksFile := flags.String("sinks", "", "sink configuration file (JSON)")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	listener, err := NewEventListener(network, chaincodeName, *checkpointFile)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	if *sinksFile == "" {
+		listener.Handle("*", func(event ContractEvent) error {
+			fmt.Printf("*** Bloco %d, transação %s: %s (paciente %s)\n", event.BlockNumber, event.TransactionID, event.Type, event.PatientID)
+			return nil
+		})
+	} else {
+		sinks, filters, err := loadEventSinks(*sinksFile)
+		if err != nil {
+			return err
+		}
+
+		for i, sink := range sinks {
+			defer sink.Close()
+			listener.AddSink(sink, filters[i])
+		}
+	}
+
+	var replayFromBlock *uint64
+	if *fromBlock >= 0 {
+		block := uint64(*fromBlock)
+		replayFromBlock = &block
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Println("\n--> A ouvir os eventos do chaincode")
+
+	return listener.Run(ctx, replayFromBlock)
+}
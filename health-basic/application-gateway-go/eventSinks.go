@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventSink entrega os eventos a um sistema externo (SI do hospital, app do paciente...).
+// Se Send devolver erro o listener pára sem guardar o checkpoint.
+type EventSink interface {
+	Send(event ContractEvent) error
+	Close() error
+}
+
+// EventFilter escolhe os eventos de um sink. Listas vazias não filtram.
+// A organização é o MSP ID de quem submeteu a transação.
+type EventFilter struct {
+	EventTypes    []string `json:"eventTypes"`
+	Organizations []string `json:"organizations"`
+}
+
+func (f EventFilter) Matches(event ContractEvent) bool {
+	return matchesAny(f.EventTypes, event.Type) && matchesAny(f.Organizations, event.SubmitterMSPID)
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddSink entrega ao sink os eventos aceites pelo filtro.
+func (l *EventListener) AddSink(sink EventSink, filter EventFilter) {
+	l.Handle("*", func(event ContractEvent) error {
+		if !filter.Matches(event) {
+			return nil
+		}
+		return sink.Send(event)
+	})
+}
+
+// JSONLinesSink escreve um evento JSON por linha (num ficheiro ou no stdout).
+type JSONLinesSink struct {
+	mu     sync.Mutex
+	writer io.Writer
+	file   *os.File
+}
+
+func NewStdoutSink() *JSONLinesSink {
+	return &JSONLinesSink{writer: os.Stdout}
+}
+
+// NewFileSink acrescenta ao ficheiro, que é criado se não existir.
+func NewFileSink(path string) (*JSONLinesSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event file: %w", err)
+	}
+
+	return &JSONLinesSink{writer: file, file: file}, nil
+}
+
+func (s *JSONLinesSink) Send(event ContractEvent) error {
+	return s.writeLine(event)
+}
+
+func (s *JSONLinesSink) writeLine(value any) error {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to serialize event: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.writer.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+
+	// O checkpoint é guardado a seguir, por isso o evento tem de estar no disco antes.
+	if s.file != nil {
+		if err := s.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync event file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *JSONLinesSink) Close() error {
+	if s.file != nil {
+		return s.file.Close()
+	}
+	return nil
+}
+
+// WebhookSink faz POST do evento em JSON para o URL indicado. O corpo é assinado com
+// HMAC-SHA256 no cabeçalho X-Signature-SHA256 e X-Event-ID (transação e posição no lote)
+// identifica o evento, para o recetor poder ignorar repetições. Os erros de rede, 429 e 5xx são repetidos com
+// espera crescente; quando as tentativas acabam (ou noutros 4xx) o evento vai para o
+// ficheiro de dead letters e o listener continua.
+type WebhookSink struct {
+	url         string
+	secret      []byte
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	deadLetters *JSONLinesSink
+}
+
+type deadLetter struct {
+	Event  ContractEvent `json:"event"`
+	URL    string        `json:"url"`
+	Error  string        `json:"error"`
+	Failed int64         `json:"failed"`
+}
+
+func NewWebhookSink(url, secret, deadLetterFile string) (*WebhookSink, error) {
+	if url == "" || secret == "" {
+		return nil, fmt.Errorf("webhook sink needs a URL and a secret")
+	}
+
+	deadLetters, err := NewFileSink(deadLetterFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebhookSink{
+		url:         url,
+		secret:      []byte(secret),
+		client:      &http.Client{Timeout: 10 * time.Second},
+		maxAttempts: 5,
+		backoff:     time.Second,
+		maxBackoff:  30 * time.Second,
+		deadLetters: deadLetters,
+	}, nil
+}
+
+func (s *WebhookSink) Send(event ContractEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to serialize event: %w", err)
+	}
+
+	backoff := s.backoff
+	var lastErr error
+
+	for attempt := 1; attempt <= s.maxAttempts; attempt++ {
+		retry, err := s.post(event, body)
+		if err == nil {
+			return nil
+		}
+
+		lastErr = err
+		if !retry || attempt == s.maxAttempts {
+			break
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+	}
+
+	return s.deadLetters.writeLine(deadLetter{
+		Event:  event,
+		URL:    s.url,
+		Error:  lastErr.Error(),
+		Failed: time.Now().Unix(),
+	})
+}
+
+// post devolve se vale a pena tentar outra vez.
+func (s *WebhookSink) post(event ContractEvent, body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(body)
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Signature-SHA256", hex.EncodeToString(mac.Sum(nil)))
+	request.Header.Set("X-Event-ID", fmt.Sprintf("%s/%d", event.TransactionID, event.EventIndex))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return true, fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+	return retry, fmt.Errorf("webhook returned status %d", response.StatusCode)
+}
+
+func (s *WebhookSink) Close() error {
+	return s.deadLetters.Close()
+}
+
+// EventSinkConfig é uma entrada do ficheiro de configuração dos sinks (lista em JSON).
+// O segredo do webhook vem da variável de ambiente indicada em secretEnv, para não
+// ficar no ficheiro.
+type EventSinkConfig struct {
+	Type           string `json:"type"` // "webhook", "file" ou "stdout"
+	URL            string `json:"url"`
+	SecretEnv      string `json:"secretEnv"`
+	DeadLetterFile string `json:"deadLetterFile"`
+	Path           string `json:"path"`
+	EventFilter
+}
+
+func loadEventSinks(configFile string) ([]EventSink, []EventFilter, error) {
+	configJSON, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read sink configuration: %w", err)
+	}
+
+	var configs []EventSinkConfig
+	if err := json.Unmarshal(configJSON, &configs); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse sink configuration: %w", err)
+	}
+
+	var sinks []EventSink
+	var filters []EventFilter
+
+	for _, config := range configs {
+		var sink EventSink
+
+		switch config.Type {
+		case "webhook":
+			deadLetterFile := config.DeadLetterFile
+			if deadLetterFile == "" {
+				deadLetterFile = "webhook-dead-letters.jsonl"
+			}
+			sink, err = NewWebhookSink(config.URL, os.Getenv(config.SecretEnv), deadLetterFile)
+		case "file":
+			sink, err = NewFileSink(config.Path)
+		case "stdout":
+			sink = NewStdoutSink()
+		default:
+			err = fmt.Errorf("unknown sink type: %s", config.Type)
+		}
+
+		if err != nil {
+			for _, opened := range sinks {
+				opened.Close()
+			}
+			return nil, nil, err
+		}
+
+		sinks = append(sinks, sink)
+		filters = append(filters, config.EventFilter)
+	}
+
+	return sinks, filters, nil
+}
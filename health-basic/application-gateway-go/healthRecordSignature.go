@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+type HealthRecord struct {
+	RecordID                 string                `json:"recordID"`
+	PatientID                string                `json:"patientID"`
+	Description              string                `json:"description"`
+	HealthCareProfessionalID string                `json:"healthCareProfessionalID"`
+	HealthCareProfessional   string                `json:"healthCareProfessional"`
+	CreatedDate              int64                 `json:"createdDate"`
+	EventDate                int64                 `json:"eventDate"`
+	Speciality               string                `json:"speciality"`
+	SpecialityCode           string                `json:"specialityCode"`
+	RecordType               string                `json:"recordType"`
+	RecordTypeCode           string                `json:"recordTypeCode"`
+	Organization             string                `json:"organization"`
+	EncounterID              string                `json:"encounterID"`
+	Links                    []HealthRecordLink    `json:"links"`
+	SensitivityLabel         string                `json:"sensitivityLabel"`
+	Observations             []Observation         `json:"observations"`
+	PatientAuthored          bool                  `json:"patientAuthored"`
+	Disputes                 []HealthRecordDispute `json:"disputes"`
+	Signature                string                `json:"signature"`
+	SignerCertificate        string                `json:"signerCertificate"`
+	EnteredInError           bool                  `json:"enteredInError"`
+	RetractedReason          string                `json:"retractedReason"`
+	RetractedDate            int64                 `json:"retractedDate"`
+	RetractedBy              string                `json:"retractedBy"`
+	Erased                   bool                  `json:"erased"`
+	ErasedDate               int64                 `json:"erasedDate"`
+}
+
+type Observation struct {
+	Code          string  `json:"code"`
+	Display       string  `json:"display"`
+	Value         float64 `json:"value"`
+	Unit          string  `json:"unit"`
+	ReferenceLow  float64 `json:"referenceLow"`
+	ReferenceHigh float64 `json:"referenceHigh"`
+	AbnormalFlag  string  `json:"abnormalFlag"`
+}
+
+type HealthRecordLink struct {
+	RecordID string `json:"recordID"`
+	Relation string `json:"relation"`
+}
+
+type HealthRecordDispute struct {
+	DisputeID   string `json:"disputeID"`
+	Note        string `json:"note"`
+	CreatedDate int64  `json:"createdDate"`
+}
+
+// Tem de ser igual ao signedHealthRecordContent do chaincode, campo a campo e pela mesma ordem.
+type signedHealthRecordContent struct {
+	RecordID                 string                     `json:"recordID"`
+	PatientID                string                     `json:"patientID"`
+	Description              string                     `json:"description"`
+	HealthCareProfessionalID string                     `json:"healthCareProfessionalID"`
+	EventDate                int64                      `json:"eventDate"`
+	SpecialityCode           string                     `json:"specialityCode"`
+	RecordTypeCode           string                     `json:"recordTypeCode"`
+	Organization             string                     `json:"organization"`
+	SensitivityLabel         string                     `json:"sensitivityLabel"`
+	EncounterID              string                     `json:"encounterID"`
+	Links                    []HealthRecordLink         `json:"links"`
+	Observations             []signedObservationContent `json:"observations"`
+}
+
+type signedObservationContent struct {
+	Code          string  `json:"code"`
+	Value         float64 `json:"value"`
+	Unit          string  `json:"unit"`
+	ReferenceLow  float64 `json:"referenceLow"`
+	ReferenceHigh float64 `json:"referenceHigh"`
+	AbnormalFlag  string  `json:"abnormalFlag"`
+}
+
+func canonicalHealthRecordJSON(record HealthRecord) ([]byte, error) {
+	links := record.Links
+	if links == nil {
+		links = []HealthRecordLink{}
+	}
+
+	observations := []signedObservationContent{}
+	for _, observation := range record.Observations {
+		observations = append(observations, signedObservationContent{
+			Code:          observation.Code,
+			Value:         observation.Value,
+			Unit:          observation.Unit,
+			ReferenceLow:  observation.ReferenceLow,
+			ReferenceHigh: observation.ReferenceHigh,
+			AbnormalFlag:  observation.AbnormalFlag,
+		})
+	}
+
+	return json.Marshal(signedHealthRecordContent{
+		RecordID:                 record.RecordID,
+		PatientID:                record.PatientID,
+		Description:              record.Description,
+		HealthCareProfessionalID: record.HealthCareProfessionalID,
+		EventDate:                record.EventDate,
+		SpecialityCode:           record.SpecialityCode,
+		RecordTypeCode:           record.RecordTypeCode,
+		Organization:             record.Organization,
+		SensitivityLabel:         record.SensitivityLabel,
+		EncounterID:              record.EncounterID,
+		Links:                    links,
+		Observations:             observations,
+	})
+}
+
+// SignHealthRecord gera a assinatura destacada (base64) que o profissional envia no AddPatientMedicalRecord.
+// O sign tem de ser criado com a chave privada do certificado registado do profissional.
+// As observações são assinadas como ficam na ledger, por isso têm de vir na unidade UCUM do código;
+// a flag de anormal em falta é calculada aqui como o chaincode a calcula.
+func SignHealthRecord(sign identity.Sign, record HealthRecord) (string, error) {
+	observations := make([]Observation, len(record.Observations))
+	for i, observation := range record.Observations {
+		observation.AbnormalFlag = storedAbnormalFlag(observation)
+		observations[i] = observation
+	}
+	record.Observations = observations
+
+	content, err := canonicalHealthRecordJSON(record)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize health record: %w", err)
+	}
+
+	digest := sha256.Sum256(content)
+
+	signature, err := sign(digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign health record: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// storedAbnormalFlag devolve a flag que o chaincode guarda: a indicada ou, com intervalo de
+// referência, L/H/N conforme o valor.
+func storedAbnormalFlag(observation Observation) string {
+	if observation.AbnormalFlag != "" || (observation.ReferenceLow == 0 && observation.ReferenceHigh == 0) {
+		return observation.AbnormalFlag
+	}
+
+	switch {
+	case observation.Value < observation.ReferenceLow:
+		return "L"
+	case observation.Value > observation.ReferenceHigh:
+		return "H"
+	default:
+		return "N"
+	}
+}
+
+// VerifyHealthRecord volta a validar a assinatura de um registo devolvido pelo chaincode, com o
+// certificado registado do profissional. O SignerCertificate que vem no registo não serve para
+// isto: quem alterasse o registo também podia trocar o certificado.
+func VerifyHealthRecord(contract *client.Contract, record HealthRecord) error {
+	if err := checkHealthRecordIsSigned(record); err != nil {
+		return err
+	}
+
+	certificate, err := getRegisteredCertificate(contract, record.HealthCareProfessionalID)
+	if err != nil {
+		return err
+	}
+
+	return verifyHealthRecordSignature(certificate, record)
+}
+
+// checkHealthRecordIsSigned diz porque é que o registo não tem assinatura para validar.
+func checkHealthRecordIsSigned(record HealthRecord) error {
+	if record.Erased {
+		return fmt.Errorf("health record %s was erased", record.RecordID)
+	}
+
+	if record.PatientAuthored {
+		return fmt.Errorf("health record %s was reported by the patient", record.RecordID)
+	}
+
+	if record.Signature == "" {
+		return fmt.Errorf("health record %s is not signed", record.RecordID)
+	}
+
+	return nil
+}
+
+func verifyHealthRecordSignature(certificate *x509.Certificate, record HealthRecord) error {
+	signature, err := base64.StdEncoding.DecodeString(record.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	content, err := canonicalHealthRecordJSON(record)
+	if err != nil {
+		return fmt.Errorf("failed to serialize health record: %w", err)
+	}
+
+	if err := verifySignature(certificate, content, signature); err != nil {
+		return fmt.Errorf("health record %s: %w", record.RecordID, err)
+	}
+
+	return nil
+}
+
+// verifySignature valida uma assinatura feita com identity.Sign sobre o SHA-256 do conteúdo.
+// Com Ed25519 o identity.Sign assina o digest como mensagem, por isso verifica-se o digest.
+func verifySignature(certificate *x509.Certificate, content, signature []byte) error {
+	digest := sha256.Sum256(content)
+
+	switch publicKey := certificate.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(publicKey, digest[:], signature) {
+			return fmt.Errorf("invalid signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(publicKey, digest[:], signature) {
+			return fmt.Errorf("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type: %T", publicKey)
+	}
+
+	return nil
+}
+
+// getRegisteredCertificate obtém da ledger o certificado registado do profissional.
+func getRegisteredCertificate(contract *client.Contract, healthcareProfessionalID string) (*x509.Certificate, error) {
+	evaluateResult, err := contract.EvaluateTransaction("GetHealthcareProfessionalCertificate", healthcareProfessionalID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the registered certificate of %s: %w", healthcareProfessionalID, err)
+	}
+
+	var registered struct {
+		CertificatePEM string `json:"certificatePEM"`
+	}
+	if err := json.Unmarshal(evaluateResult, &registered); err != nil {
+		return nil, fmt.Errorf("failed to parse the registered certificate: %w", err)
+	}
+
+	certificate, err := identity.CertificateFromPEM([]byte(registered.CertificatePEM))
+	if err != nil {
+		return nil, fmt.Errorf("invalid registered certificate: %w", err)
+	}
+
+	return certificate, nil
+}
+
+// Obtém o histórico médico do paciente e valida a assinatura de cada registo. Os registos com
+// assinatura inválida são mostrados; só uma falha a ler o histórico devolve erro.
+func VerifyMedicalHistory(contract *client.Contract, patientID string) error {
+	fmt.Println("\n--> Evaluate Transaction: Vamos validar as assinaturas do histórico médico")
+
+	certificates := map[string]*x509.Certificate{}
+
+	it, err := NewMedicalHistoryIterator(contract, patientID, MedicalHistoryQueryOptions{}, 50)
+	if err != nil {
+		return err
+	}
+
+	for it.HasNext() {
+		records, err := it.Next()
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			if err := checkHealthRecordIsSigned(record); err != nil {
+				fmt.Printf("*** %s: %v\n", record.RecordID, err)
+				continue
+			}
+
+			certificate, ok := certificates[record.HealthCareProfessionalID]
+			if !ok {
+				certificate, err = getRegisteredCertificate(contract, record.HealthCareProfessionalID)
+				if err != nil {
+					fmt.Printf("*** %s: %v\n", record.RecordID, err)
+					continue
+				}
+				certificates[record.HealthCareProfessionalID] = certificate
+			}
+
+			if err := verifyHealthRecordSignature(certificate, record); err != nil {
+				fmt.Printf("*** %s: %v\n", record.RecordID, err)
+				continue
+			}
+			fmt.Printf("*** %s: assinatura válida\n", record.RecordID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+)
+
+// Contact são os contactos do paciente. Ficam fora da ledger, num ficheiro do gateway.
+type Contact struct {
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+}
+
+type NotificationMessage struct {
+	Subject string
+	Body    string
+}
+
+// errNoContactAddress indica que o paciente não tem contacto para o canal; a notificação
+// não é repetida por esse canal.
+var errNoContactAddress = errors.New("patient has no contact address for this channel")
+
+// NotificationChannel entrega uma mensagem ao paciente (email, SMS, push...).
+// O Name é o nome usado nas preferências do paciente.
+type NotificationChannel interface {
+	Name() string
+	Send(contact Contact, message NotificationMessage) error
+}
+
+// SMTPChannel envia por email. Sem auth envia sem autenticação, o que serve para um relay
+// interno ou para um servidor SMTP falso local nos testes.
+type SMTPChannel struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPChannel(addr, from string, auth smtp.Auth) *SMTPChannel {
+	return &SMTPChannel{addr: addr, from: from, auth: auth}
+}
+
+func (c *SMTPChannel) Name() string {
+	return "email"
+}
+
+func (c *SMTPChannel) Send(contact Contact, message NotificationMessage) error {
+	if contact.Email == "" {
+		return errNoContactAddress
+	}
+
+	headers := []string{
+		"From: " + c.from,
+		"To: " + contact.Email,
+		"Subject: " + mime.QEncoding.Encode("utf-8", message.Subject),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+
+	body := strings.ReplaceAll(message.Body, "\n", "\r\n")
+	data := strings.Join(headers, "\r\n") + "\r\n\r\n" + body + "\r\n"
+
+	if err := smtp.SendMail(c.addr, c.auth, c.from, []string{contact.Email}, []byte(data)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	return nil
+}
+
+// ConsoleChannel só mostra a mensagem no ecrã, no lugar do canal indicado, para desenvolvimento.
+type ConsoleChannel struct {
+	ChannelName string
+}
+
+func (c ConsoleChannel) Name() string {
+	return c.ChannelName
+}
+
+func (c ConsoleChannel) Send(contact Contact, message NotificationMessage) error {
+	fmt.Printf("*** Notificação para %s: %s\n%s\n", contact.Email, message.Subject, message.Body)
+	return nil
+}
@@ -0,0 +1,619 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"google.golang.org/grpc"
+)
+
+// Tipos de notificação. As preferências do paciente usam estes nomes.
+const (
+	NotificationRequestCreated = "RequestCreated"
+	NotificationAccessExpiring = "AccessExpiring"
+)
+
+// NotificationPreferences são as escolhas do paciente (ver SetNotificationPreferences). Listas vazias aceitam tudo.
+// As horas de silêncio ("22:00" a "08:00") são na hora local do gateway; durante elas as
+// notificações ficam à espera.
+type NotificationPreferences struct {
+	Channels        []string `json:"channels"`
+	QuietHoursStart string   `json:"quietHoursStart"`
+	QuietHoursEnd   string   `json:"quietHoursEnd"`
+	EventTypes      []string `json:"eventTypes"`
+	Language        string   `json:"language"`
+}
+
+func defaultNotificationPreferences() NotificationPreferences {
+	return NotificationPreferences{Channels: []string{"email"}, Language: "pt"}
+}
+
+func (p NotificationPreferences) wants(notificationType string) bool {
+	return matchesAny(p.EventTypes, notificationType)
+}
+
+func (p NotificationPreferences) inQuietHours(now time.Time) bool {
+	if p.QuietHoursStart == "" || p.QuietHoursEnd == "" {
+		return false
+	}
+
+	start, err := time.Parse("15:04", p.QuietHoursStart)
+	if err != nil {
+		return false
+	}
+
+	end, err := time.Parse("15:04", p.QuietHoursEnd)
+	if err != nil {
+		return false
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	if startMinute <= endMinute {
+		return minute >= startMinute && minute < endMinute
+	}
+
+	// Atravessa a meia-noite.
+	return minute >= startMinute || minute < endMinute
+}
+
+// PreferencesStore devolve as preferências do paciente, ou nil se não tiver nenhumas.
+type PreferencesStore interface {
+	GetNotificationPreferences(patientID string) (*NotificationPreferences, error)
+}
+
+// ledgerPreferencesStore lê as preferências guardadas na ledger pelo SetNotificationPreferences,
+// por isso os gateways de todas as organizações usam as mesmas.
+type ledgerPreferencesStore struct {
+	contract *client.Contract
+}
+
+func (s ledgerPreferencesStore) GetNotificationPreferences(patientID string) (*NotificationPreferences, error) {
+	evaluateResult, err := s.contract.EvaluateTransaction("GetNotificationPreferences", patientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate transaction: %w", err)
+	}
+
+	var preferences struct {
+		PatientID string `json:"patientID"`
+		NotificationPreferences
+	}
+	if err := json.Unmarshal(evaluateResult, &preferences); err != nil {
+		return nil, fmt.Errorf("failed to parse notification preferences: %w", err)
+	}
+
+	if preferences.PatientID == "" {
+		return nil, nil
+	}
+
+	return &preferences.NotificationPreferences, nil
+}
+
+type notificationTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+func newNotificationTemplate(subject, body string) notificationTemplate {
+	return notificationTemplate{
+		subject: template.Must(template.New("subject").Parse(subject)),
+		body:    template.Must(template.New("body").Parse(body)),
+	}
+}
+
+// Mensagens por tipo e língua. Levam só identificadores, como os eventos.
+var notificationTemplates = map[string]map[string]notificationTemplate{
+	NotificationRequestCreated: {
+		"pt": newNotificationTemplate(
+			"Novo pedido de acesso aos seus dados de saúde",
+			"O profissional de saúde {{.HealthcareProfessionalID}} pediu acesso aos seus dados de saúde (pedido {{.RequestID}}).\n"+
+				"O pedido é válido até {{.ExpirationDate}}.\n"+
+				"Pode aceitar ou recusar o pedido na aplicação."),
+		"en": newNotificationTemplate(
+			"New request to access your health data",
+			"Healthcare professional {{.HealthcareProfessionalID}} requested access to your health data (request {{.RequestID}}).\n"+
+				"The request is valid until {{.ExpirationDate}}.\n"+
+				"You can accept or deny the request in the app."),
+	},
+	NotificationAccessExpiring: {
+		"pt": newNotificationTemplate(
+			"Um acesso aos seus dados de saúde está a terminar",
+			"O acesso do profissional de saúde {{.HealthcareProfessionalID}} aos seus dados de saúde (pedido {{.RequestID}}) termina em {{.ExpirationDate}}."),
+		"en": newNotificationTemplate(
+			"An access to your health data is ending",
+			"Healthcare professional {{.HealthcareProfessionalID}}'s access to your health data (request {{.RequestID}}) ends on {{.ExpirationDate}}."),
+	},
+}
+
+var notificationDateFormats = map[string]string{
+	"pt": "02/01/2006 15:04",
+	"en": "2006-01-02 15:04",
+}
+
+type notificationData struct {
+	HealthcareProfessionalID string `json:"healthcareProfessionalID"`
+	RequestID                string `json:"requestID"`
+	ExpirationDate           int64  `json:"expirationDate"`
+}
+
+// renderNotification escreve a mensagem na língua pedida, ou em português se não existir.
+func renderNotification(notificationType, language string, data notificationData) (NotificationMessage, error) {
+	templates, ok := notificationTemplates[notificationType]
+	if !ok {
+		return NotificationMessage{}, fmt.Errorf("unknown notification type: %s", notificationType)
+	}
+
+	if _, ok := templates[language]; !ok {
+		language = "pt"
+	}
+
+	values := struct {
+		HealthcareProfessionalID string
+		RequestID                string
+		ExpirationDate           string
+	}{data.HealthcareProfessionalID, data.RequestID, time.Unix(data.ExpirationDate, 0).Format(notificationDateFormats[language])}
+
+	var subject, body bytes.Buffer
+	if err := templates[language].subject.Execute(&subject, values); err != nil {
+		return NotificationMessage{}, fmt.Errorf("failed to render notification: %w", err)
+	}
+	if err := templates[language].body.Execute(&body, values); err != nil {
+		return NotificationMessage{}, fmt.Errorf("failed to render notification: %w", err)
+	}
+
+	return NotificationMessage{Subject: subject.String(), Body: body.String()}, nil
+}
+
+type trackedAccess struct {
+	PatientID                string `json:"patientID"`
+	HealthcareProfessionalID string `json:"healthcareProfessionalID"`
+	RequestID                string `json:"requestID"`
+	ExpirationDate           int64  `json:"expirationDate"`
+	Notified                 bool   `json:"notified"`
+}
+
+// Channels vazio quer dizer que ainda não foi decidido por que canais enviar.
+type pendingNotification struct {
+	Type      string           `json:"type"`
+	PatientID string           `json:"patientID"`
+	Data      notificationData `json:"data"`
+	Channels  []string         `json:"channels"`
+}
+
+// O estado é guardado antes de os handlers voltarem, por isso avança com o checkpoint
+// do listener e não se perde nada ao reiniciar. NotifiedRequests (pedido -> data de
+// expiração) evita avisar outra vez do mesmo pedido quando os eventos são repetidos.
+type notificationState struct {
+	Accesses         map[string]trackedAccess `json:"accesses"`
+	NotifiedRequests map[string]int64         `json:"notifiedRequests"`
+	Pending          []pendingNotification    `json:"pending"`
+}
+
+// NotificationService avisa o paciente dos pedidos novos e dos acessos que estão a terminar.
+// Os acessos são conhecidos pelos eventos AccessGranted/AccessRevoked; com um listener novo
+// usar -from-block 0 para os conhecer todos.
+type NotificationService struct {
+	mu          sync.Mutex
+	preferences PreferencesStore
+	contacts    map[string]Contact
+	channels    map[string]NotificationChannel
+	stateFile   string
+	state       notificationState
+	warnBefore  time.Duration
+	now         func() time.Time
+}
+
+func NewNotificationService(preferences PreferencesStore, contacts map[string]Contact, channels []NotificationChannel,
+	stateFile string, warnBefore time.Duration) (*NotificationService, error) {
+
+	service := &NotificationService{
+		preferences: preferences,
+		contacts:    contacts,
+		channels:    map[string]NotificationChannel{},
+		stateFile:   stateFile,
+		state:       notificationState{Accesses: map[string]trackedAccess{}, NotifiedRequests: map[string]int64{}},
+		warnBefore:  warnBefore,
+		now:         time.Now,
+	}
+
+	for _, channel := range channels {
+		service.channels[channel.Name()] = channel
+	}
+
+	if err := readJSONFile(stateFile, &service.state); err != nil {
+		return nil, fmt.Errorf("failed to read notification state: %w", err)
+	}
+
+	if service.state.Accesses == nil {
+		service.state.Accesses = map[string]trackedAccess{}
+	}
+
+	if service.state.NotifiedRequests == nil {
+		service.state.NotifiedRequests = map[string]int64{}
+	}
+
+	return service, nil
+}
+
+// Register liga o serviço aos eventos do listener.
+func (s *NotificationService) Register(listener *EventListener) {
+	listener.Handle("RequestCreated", s.handleRequestCreated)
+	listener.Handle("AccessGranted", s.handleAccessGranted)
+	listener.Handle("AccessRevoked", s.handleAccessRevoked)
+	listener.Handle("PatientDataErased", s.handlePatientDataErased)
+}
+
+func (s *NotificationService) handleRequestCreated(event ContractEvent) error {
+	if err := s.queueRequestCreated(event); err != nil {
+		return err
+	}
+
+	return s.deliverPending()
+}
+
+func (s *NotificationService) queueRequestCreated(event ContractEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, notified := s.state.NotifiedRequests[event.RequestID]; notified || event.ExpirationDate <= s.now().Unix() {
+		return nil
+	}
+
+	s.state.NotifiedRequests[event.RequestID] = event.ExpirationDate
+
+	s.state.Pending = append(s.state.Pending, pendingNotification{
+		Type:      NotificationRequestCreated,
+		PatientID: event.PatientID,
+		Data: notificationData{
+			HealthcareProfessionalID: event.HealthcareProfessionalID,
+			RequestID:                event.RequestID,
+			ExpirationDate:           event.ExpirationDate,
+		},
+	})
+
+	return s.saveState()
+}
+
+func (s *NotificationService) handleAccessGranted(event ContractEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Numa repetição dos eventos o acesso já pode ter sido avisado.
+	if tracked, ok := s.state.Accesses[event.RequestID]; ok && tracked.ExpirationDate == event.ExpirationDate {
+		return nil
+	}
+
+	s.state.Accesses[event.RequestID] = trackedAccess{
+		PatientID:                event.PatientID,
+		HealthcareProfessionalID: event.HealthcareProfessionalID,
+		RequestID:                event.RequestID,
+		ExpirationDate:           event.ExpirationDate,
+	}
+
+	return s.saveState()
+}
+
+func (s *NotificationService) handleAccessRevoked(event ContractEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.state.Accesses, event.RequestID)
+
+	return s.saveState()
+}
+
+func (s *NotificationService) handlePatientDataErased(event ContractEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for requestID, access := range s.state.Accesses {
+		if access.PatientID == event.PatientID {
+			delete(s.state.Accesses, requestID)
+		}
+	}
+
+	var pending []pendingNotification
+	for _, notification := range s.state.Pending {
+		if notification.PatientID != event.PatientID {
+			pending = append(pending, notification)
+		}
+	}
+	s.state.Pending = pending
+
+	return s.saveState()
+}
+
+// Tick avisa dos acessos que terminam dentro de warnBefore e volta a tentar as
+// notificações que ficaram à espera (horas de silêncio ou falhas de envio).
+func (s *NotificationService) Tick() error {
+	if err := s.queueAccessesExpiring(); err != nil {
+		return err
+	}
+
+	return s.deliverPending()
+}
+
+func (s *NotificationService) queueAccessesExpiring() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now().Unix()
+
+	for requestID, expirationDate := range s.state.NotifiedRequests {
+		if expirationDate <= now {
+			delete(s.state.NotifiedRequests, requestID)
+		}
+	}
+
+	for requestID, access := range s.state.Accesses {
+		if access.ExpirationDate <= now {
+			delete(s.state.Accesses, requestID)
+			continue
+		}
+
+		if access.Notified || access.ExpirationDate-now > int64(s.warnBefore.Seconds()) {
+			continue
+		}
+
+		s.state.Pending = append(s.state.Pending, pendingNotification{
+			Type:      NotificationAccessExpiring,
+			PatientID: access.PatientID,
+			Data: notificationData{
+				HealthcareProfessionalID: access.HealthcareProfessionalID,
+				RequestID:                access.RequestID,
+				ExpirationDate:           access.ExpirationDate,
+			},
+		})
+
+		access.Notified = true
+		s.state.Accesses[requestID] = access
+	}
+
+	return s.saveState()
+}
+
+// Run chama o Tick a cada intervalo até o ctx ser cancelado.
+func (s *NotificationService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Tick(); err != nil {
+				fmt.Printf("*** Erro nas notificações: %v\n", err)
+			}
+		}
+	}
+}
+
+// deliverPending envia o que puder e deixa o resto à espera. As preferências são lidas da
+// ledger sem o mu, para os handlers dos eventos e o Tick não ficarem parados à espera do peer;
+// as notificações de pacientes cujas preferências não foi possível ler ficam para o próximo Tick.
+func (s *NotificationService) deliverPending() error {
+	s.mu.Lock()
+	patientIDs := map[string]bool{}
+	for _, notification := range s.state.Pending {
+		patientIDs[notification.PatientID] = true
+	}
+	s.mu.Unlock()
+
+	preferences := map[string]NotificationPreferences{}
+	for patientID := range patientIDs {
+		patientPreferences, err := s.preferences.GetNotificationPreferences(patientID)
+		if err != nil {
+			fmt.Printf("*** Erro ao obter as preferências do paciente %s: %v\n", patientID, err)
+			continue
+		}
+
+		if patientPreferences == nil {
+			preferences[patientID] = defaultNotificationPreferences()
+		} else {
+			preferences[patientID] = *patientPreferences
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stillPending []pendingNotification
+
+	for _, notification := range s.state.Pending {
+		patientPreferences, ok := preferences[notification.PatientID]
+		if !ok {
+			stillPending = append(stillPending, notification)
+			continue
+		}
+
+		remaining, err := s.deliver(notification, patientPreferences)
+		if err != nil {
+			fmt.Printf("*** Erro ao enviar notificação ao paciente %s: %v\n", notification.PatientID, err)
+		}
+
+		if remaining != nil {
+			stillPending = append(stillPending, *remaining)
+		}
+	}
+
+	s.state.Pending = stillPending
+
+	return s.saveState()
+}
+
+// deliver devolve a notificação com os canais que ainda faltam, ou nil se já não há nada a fazer.
+// Tem de ser chamado com o mu.
+func (s *NotificationService) deliver(notification pendingNotification, preferences NotificationPreferences) (*pendingNotification, error) {
+	if !preferences.wants(notification.Type) {
+		return nil, nil
+	}
+
+	if len(notification.Channels) == 0 {
+		notification.Channels = preferences.Channels
+	}
+
+	if preferences.inQuietHours(s.now()) {
+		return &notification, nil
+	}
+
+	contact, ok := s.contacts[notification.PatientID]
+	if !ok {
+		return nil, nil
+	}
+
+	message, err := renderNotification(notification.Type, preferences.Language, notification.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var remaining []string
+	var lastErr error
+
+	for _, name := range notification.Channels {
+		channel, ok := s.channels[name]
+		if !ok {
+			continue
+		}
+
+		err := channel.Send(contact, message)
+		if errors.Is(err, errNoContactAddress) {
+			continue
+		}
+		if err != nil {
+			remaining = append(remaining, name)
+			lastErr = err
+		}
+	}
+
+	if len(remaining) == 0 {
+		return nil, nil
+	}
+
+	notification.Channels = remaining
+	return &notification, lastErr
+}
+
+func (s *NotificationService) saveState() error {
+	stateJSON, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize notification state: %w", err)
+	}
+
+	// Escreve num ficheiro temporário e muda o nome, para não deixar o estado a meio.
+	tmpFile := s.stateFile + ".tmp"
+	if err := os.WriteFile(tmpFile, stateJSON, 0o600); err != nil {
+		return fmt.Errorf("failed to write notification state: %w", err)
+	}
+
+	if err := os.Rename(tmpFile, s.stateFile); err != nil {
+		return fmt.Errorf("failed to write notification state: %w", err)
+	}
+
+	return nil
+}
+
+// readJSONFile lê o ficheiro para value; um ficheiro que ainda não existe deixa value como está.
+func readJSONFile(path string, value any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
+
+// runNotifyCommand trata de "notify [opções]": ouve os eventos e envia as notificações. As
+// preferências dos pacientes são lidas com a identidade do serviço de notificações (-cert e
+// -key, com o atributo role=notifier), a única além do paciente e dos administradores que o
+// chaincode deixa lê-las.
+func runNotifyCommand(clientConnection *grpc.ClientConn, network *client.Network, channelName, chaincodeName string, args []string) error {
+	flags := flag.NewFlagSet("notify", flag.ContinueOnError)
+	checkpointFile := flags.String("checkpoint", "notifications.checkpoint", "checkpoint file")
+	fromBlock := flags.Int64("from-block", -1, "replay events from this block")
+	stateFile := flags.String("state", "notifications.state.json", "notification state file")
+	contactsFile := flags.String("contacts", "contacts.json", "patient contacts file (JSON)")
+	smtpAddr := flags.String("smtp-addr", "", "SMTP server (host:port)")
+	smtpFrom := flags.String("smtp-from", "no-reply@apollomedtech.pt", "email sender")
+	warnBefore := flags.Duration("warn-before", 48*time.Hour, "warn this long before an access ends")
+	interval := flags.Duration("interval", time.Minute, "how often to check for ending accesses")
+	notifierCert := flags.String("cert", "", "certificate of the notification service identity (role=notifier)")
+	notifierKey := flags.String("key", "", "private key of the notification service identity")
+	notifierMSPID := flags.String("msp", mspID, "MSP ID of the notification service identity")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	contacts := map[string]Contact{}
+	if err := readJSONFile(*contactsFile, &contacts); err != nil {
+		return fmt.Errorf("failed to read patient contacts: %w", err)
+	}
+
+	// Sem servidor SMTP os emails só são mostrados no ecrã.
+	channels := []NotificationChannel{ConsoleChannel{ChannelName: "email"}}
+	if *smtpAddr != "" {
+		channels = []NotificationChannel{NewSMTPChannel(*smtpAddr, *smtpFrom, nil)}
+	}
+
+	notifier := apiPrincipal{Name: "notifier", CertPath: *notifierCert, KeyPath: *notifierKey, MSPID: *notifierMSPID}
+	if err := notifier.loadIdentity(); err != nil {
+		return fmt.Errorf("failed to load the notification service identity: %w", err)
+	}
+
+	if notifier.Role != "notifier" {
+		return fmt.Errorf("the notification service certificate %s has no role=notifier attribute", *notifierCert)
+	}
+
+	notifierGateway, err := newGatewayConnection(notifier.id, notifier.sign, clientConnection)
+	if err != nil {
+		return err
+	}
+	defer notifierGateway.Close()
+
+	preferences := ledgerPreferencesStore{notifierGateway.GetNetwork(channelName).GetContract(chaincodeName)}
+
+	service, err := NewNotificationService(preferences, contacts, channels, *stateFile, *warnBefore)
+	if err != nil {
+		return err
+	}
+
+	listener, err := NewEventListener(network, chaincodeName, *checkpointFile)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	service.Register(listener)
+
+	var replayFromBlock *uint64
+	if *fromBlock >= 0 {
+		block := uint64(*fromBlock)
+		replayFromBlock = &block
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go service.Run(ctx, *interval)
+
+	fmt.Println("\n--> A enviar notificações aos pacientes")
+
+	return listener.Run(ctx, replayFromBlock)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer aceita uma ligação, responde como um servidor SMTP sem extensões e
+// devolve pelo canal o conteúdo do DATA.
+func fakeSMTPServer(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		reply("220 localhost fake SMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(command, "MAIL FROM"), strings.HasPrefix(command, "RCPT TO"):
+				reply("250 OK")
+			case command == "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+
+				var data strings.Builder
+				for {
+					dataLine, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if dataLine == ".\r\n" {
+						break
+					}
+					data.WriteString(dataLine)
+				}
+				received <- data.String()
+				reply("250 OK")
+			case command == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func TestSMTPChannelSend(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+
+	channel := NewSMTPChannel(addr, "no-reply@apollomedtech.pt", nil)
+	message := NotificationMessage{Subject: "Novo pedido de acesso", Body: "Linha 1\nLinha 2"}
+
+	if err := channel.Send(Contact{Email: "paciente@example.com"}, message); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case data := <-received:
+		for _, expected := range []string{
+			"From: no-reply@apollomedtech.pt\r\n",
+			"To: paciente@example.com\r\n",
+			"Subject: Novo pedido de acesso\r\n",
+			"Content-Type: text/plain; charset=utf-8\r\n",
+			"\r\n\r\nLinha 1\r\nLinha 2\r\n",
+		} {
+			if !strings.Contains(data, expected) {
+				t.Errorf("message does not contain %q:\n%s", expected, data)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the SMTP server did not receive the message")
+	}
+}
+
+func TestSMTPChannelSendWithoutEmail(t *testing.T) {
+	channel := NewSMTPChannel("127.0.0.1:1", "no-reply@apollomedtech.pt", nil)
+
+	err := channel.Send(Contact{Phone: "910000000"}, NotificationMessage{Subject: "s", Body: "b"})
+	if !errors.Is(err, errNoContactAddress) {
+		t.Fatalf("expected errNoContactAddress, got %v", err)
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 5, 10, hour, minute, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		name  string
+		start string
+		end   string
+		now   time.Time
+		quiet bool
+	}{
+		{"no quiet hours", "", "", at(23, 0), false},
+		{"invalid start", "25:00", "08:00", at(23, 0), false},
+		{"same day, inside", "12:00", "14:00", at(13, 0), true},
+		{"same day, at the end", "12:00", "14:00", at(14, 0), false},
+		{"same day, before", "12:00", "14:00", at(11, 59), false},
+		{"across midnight, at the start", "22:00", "08:00", at(22, 0), true},
+		{"across midnight, before midnight", "22:00", "08:00", at(23, 30), true},
+		{"across midnight, after midnight", "22:00", "08:00", at(3, 0), true},
+		{"across midnight, at the end", "22:00", "08:00", at(8, 0), false},
+		{"across midnight, during the day", "22:00", "08:00", at(15, 0), false},
+	}
+
+	for _, test := range tests {
+		preferences := NotificationPreferences{QuietHoursStart: test.start, QuietHoursEnd: test.end}
+		if quiet := preferences.inQuietHours(test.now); quiet != test.quiet {
+			t.Errorf("%s: inQuietHours = %v, expected %v", test.name, quiet, test.quiet)
+		}
+	}
+}
+
+func TestRenderNotification(t *testing.T) {
+	data := notificationData{HealthcareProfessionalID: "hp1", RequestID: "req1", ExpirationDate: 1700000000}
+
+	tests := []struct {
+		language string
+		subject  string
+		date     string
+	}{
+		{"pt", "Novo pedido de acesso aos seus dados de saúde", time.Unix(1700000000, 0).Format("02/01/2006 15:04")},
+		{"en", "New request to access your health data", time.Unix(1700000000, 0).Format("2006-01-02 15:04")},
+		// Sem mensagens na língua pedida usa o português.
+		{"fr", "Novo pedido de acesso aos seus dados de saúde", time.Unix(1700000000, 0).Format("02/01/2006 15:04")},
+		{"", "Novo pedido de acesso aos seus dados de saúde", time.Unix(1700000000, 0).Format("02/01/2006 15:04")},
+	}
+
+	for _, test := range tests {
+		message, err := renderNotification(NotificationRequestCreated, test.language, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if message.Subject != test.subject {
+			t.Errorf("%q: subject = %q, expected %q", test.language, message.Subject, test.subject)
+		}
+		for _, expected := range []string{"hp1", "req1", test.date} {
+			if !strings.Contains(message.Body, expected) {
+				t.Errorf("%q: body does not contain %q:\n%s", test.language, expected, message.Body)
+			}
+		}
+	}
+
+	if _, err := renderNotification("Unknown", "pt", data); err == nil {
+		t.Error("expected an error for an unknown notification type")
+	}
+}
+
+// lockCheckingStore falha o teste se as preferências forem pedidas com o mu do serviço fechado.
+type lockCheckingStore struct {
+	t       *testing.T
+	service *NotificationService
+	calls   int
+}
+
+func (s *lockCheckingStore) GetNotificationPreferences(patientID string) (*NotificationPreferences, error) {
+	s.calls++
+	if !s.service.mu.TryLock() {
+		s.t.Error("preferences were fetched while holding the service lock")
+		return nil, nil
+	}
+	s.service.mu.Unlock()
+
+	return nil, nil
+}
+
+type recordingChannel struct {
+	messages []NotificationMessage
+}
+
+func (c *recordingChannel) Name() string { return "email" }
+
+func (c *recordingChannel) Send(contact Contact, message NotificationMessage) error {
+	c.messages = append(c.messages, message)
+	return nil
+}
+
+func TestNotificationServiceFetchesPreferencesOutsideLock(t *testing.T) {
+	store := &lockCheckingStore{t: t}
+	channel := &recordingChannel{}
+
+	service, err := NewNotificationService(store, map[string]Contact{"p1": {Email: "p1@example.com"}},
+		[]NotificationChannel{channel}, filepath.Join(t.TempDir(), "state.json"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.service = service
+
+	err = service.handleRequestCreated(ContractEvent{HealthEvent: HealthEvent{
+		Type:                     NotificationRequestCreated,
+		PatientID:                "p1",
+		HealthcareProfessionalID: "hp1",
+		RequestID:                "req1",
+		ExpirationDate:           time.Now().Add(24 * time.Hour).Unix(),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if store.calls != 1 || len(channel.messages) != 1 || len(service.state.Pending) != 0 {
+		t.Fatalf("expected one delivered notification, got %d calls, %d messages, %d pending",
+			store.calls, len(channel.messages), len(service.state.Pending))
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+type Access struct {
+	RequestID                string   `json:"requestID"`
+	PatientID                string   `json:"patientID"`
+	PatientName              string   `json:"patientName"`
+	HealthcareProfessionalID string   `json:"healthcareProfessionalID"`
+	HealthcareProfessional   string   `json:"healthcareProfessional"`
+	CreatedDate              int64    `json:"createdDate"`
+	ExpirationDate           int64    `json:"expirationDate"`
+	SensitivityLabels        []string `json:"sensitivityLabels"`
+	RevokedDate              int64    `json:"revokedDate"`
+	Emergency                bool     `json:"emergency"`
+	EmergencyReason          string   `json:"emergencyReason"`
+	Erased                   bool     `json:"erased"`
+	ErasedDate               int64    `json:"erasedDate"`
+}
+
+type Request struct {
+	RequestID                string `json:"requestID"`
+	Description              string `json:"description"`
+	HealthcareProfessionalID string `json:"healthcareProfessionalID"`
+	HealthcareProfessional   string `json:"healthcareProfessional"`
+	PatientID                string `json:"patientID"`
+	PatientName              string `json:"patientName"`
+	CreatedDate              int64  `json:"createdDate"`
+	Status                   int    `json:"status"`
+	StatusChangedDate        int64  `json:"statusChangedDate"`
+	ExpirationDate           int64  `json:"expirationDate"`
+	Erased                   bool   `json:"erased"`
+	ErasedDate               int64  `json:"erasedDate"`
+}
+
+type AccessLogEntry struct {
+	TxID                     string   `json:"txID"`
+	PatientID                string   `json:"patientID"`
+	HealthcareProfessionalID string   `json:"healthcareProfessionalID"`
+	Transaction              string   `json:"transaction"`
+	Purpose                  string   `json:"purpose"`
+	RecordIDs                []string `json:"recordIDs"`
+	RequestIDs               []string `json:"requestIDs"`
+	CreatedDate              int64    `json:"createdDate"`
+}
+
+// Filtros e ordenação do histórico médico, os campos vazios não filtram. As datas só filtram
+// com HasEventDateFrom/HasEventDateTo.
+type MedicalHistoryQueryOptions struct {
+	EventDateFrom       int64  `json:"eventDateFrom"`
+	HasEventDateFrom    bool   `json:"hasEventDateFrom"`
+	EventDateTo         int64  `json:"eventDateTo"`
+	HasEventDateTo      bool   `json:"hasEventDateTo"`
+	SpecialityCode      string `json:"specialityCode"`
+	RecordTypeCode      string `json:"recordTypeCode"`
+	Organization        string `json:"organization"`
+	AuthorID            string `json:"authorID"`
+	DescriptionContains string `json:"descriptionContains"`
+	SortBy              string `json:"sortBy"`    // "eventDate" (por omissão) ou "createdDate"
+	SortOrder           string `json:"sortOrder"` // "desc" (por omissão) ou "asc"
+	IncludeRetracted    bool   `json:"includeRetracted"`
+}
+
+func queryOptionsToJSON(options MedicalHistoryQueryOptions) (string, error) {
+	optionsJSON, err := json.Marshal(options)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize query options: %w", err)
+	}
+
+	return string(optionsJSON), nil
+}
+
+// Uma página devolvida pelo chaincode, Items vem no campo próprio de cada listagem
+// (healthRecords, accesses, requests).
+type page[T any] struct {
+	Items               []T
+	FetchedRecordsCount int32
+	Bookmark            string
+}
+
+// PageIterator percorre uma listagem paginada do chaincode, uma página de cada vez,
+// usando o bookmark devolvido pela página anterior.
+//
+//	it, err := NewMedicalHistoryIterator(contract, "Teste", MedicalHistoryQueryOptions{}, 20)
+//	...
+//	for it.HasNext() {
+//		records, err := it.Next()
+//		...
+//	}
+type PageIterator[T any] struct {
+	fetch    func(bookmark string) (*page[T], error)
+	pageSize int32
+	bookmark string
+	done     bool
+}
+
+func (it *PageIterator[T]) HasNext() bool {
+	return !it.done
+}
+
+// Next obtém a página seguinte. Termina quando o chaincode devolve menos registos que o tamanho da página.
+func (it *PageIterator[T]) Next() ([]T, error) {
+	if it.done {
+		return nil, fmt.Errorf("no more pages")
+	}
+
+	result, err := it.fetch(it.bookmark)
+	if err != nil {
+		return nil, err
+	}
+
+	it.bookmark = result.Bookmark
+	it.done = result.FetchedRecordsCount < it.pageSize || result.Bookmark == ""
+
+	return result.Items, nil
+}
+
+// Bookmark devolve o bookmark atual, para retomar a iteração mais tarde.
+func (it *PageIterator[T]) Bookmark() string {
+	return it.bookmark
+}
+
+// evaluate é normalmente contract.EvaluateTransaction, as leituras dos profissionais usam
+// submitProfessionalRead para ficarem no registo de acessos.
+func newPageIterator[T any](evaluate func(transaction string, args ...string) ([]byte, error),
+	pageSize int32, itemsField, transaction string, args ...string) *PageIterator[T] {
+	return &PageIterator[T]{
+		pageSize: pageSize,
+		fetch: func(bookmark string) (*page[T], error) {
+			evaluateArgs := append(append([]string{}, args...), strconv.FormatInt(int64(pageSize), 10), bookmark)
+
+			evaluateResult, err := evaluate(transaction, evaluateArgs...)
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate transaction: %w", err)
+			}
+
+			return parsePage[T](evaluateResult, itemsField)
+		},
+	}
+}
+
+func parsePage[T any](data []byte, itemsField string) (*page[T], error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse page: %w", err)
+	}
+
+	result := page[T]{}
+
+	if items, ok := raw[itemsField]; ok {
+		if err := json.Unmarshal(items, &result.Items); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", itemsField, err)
+		}
+	}
+
+	if count, ok := raw["fetchedRecordsCount"]; ok {
+		if err := json.Unmarshal(count, &result.FetchedRecordsCount); err != nil {
+			return nil, fmt.Errorf("failed to parse fetchedRecordsCount: %w", err)
+		}
+	}
+
+	if bookmark, ok := raw["bookmark"]; ok {
+		if err := json.Unmarshal(bookmark, &result.Bookmark); err != nil {
+			return nil, fmt.Errorf("failed to parse bookmark: %w", err)
+		}
+	}
+
+	return &result, nil
+}
+
+func NewMedicalHistoryIterator(contract *client.Contract, patientID string, options MedicalHistoryQueryOptions, pageSize int32) (*PageIterator[HealthRecord], error) {
+	optionsJSON, err := queryOptionsToJSON(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPageIterator[HealthRecord](contract.EvaluateTransaction, pageSize, "healthRecords", "GetMedicalHistory", patientID, optionsJSON), nil
+}
+
+// Os registos retidos (etiquetas sensíveis) ou retirados não aparecem nem contam para o tamanho da página.
+func NewPatientMedicalHistoryIterator(contract *client.Contract, patientID, healthcareProfessionalID, purpose string, options MedicalHistoryQueryOptions, pageSize int32) (*PageIterator[HealthRecord], error) {
+	optionsJSON, err := queryOptionsToJSON(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPageIterator[HealthRecord](func(transaction string, args ...string) ([]byte, error) {
+		return submitProfessionalRead(contract, transaction, purpose, args...)
+	}, pageSize, "healthRecords", "GetPatientMedicalHistory", patientID, healthcareProfessionalID, optionsJSON), nil
+}
+
+func NewAccessesByPatientIDIterator(contract *client.Contract, patientID string, pageSize int32) *PageIterator[Access] {
+	return newPageIterator[Access](contract.EvaluateTransaction, pageSize, "accesses", "GetAccessesByPatientID", patientID)
+}
+
+func NewAccessesByHealthcareProfessionalIDIterator(contract *client.Contract, healthcareProfessionalID string, pageSize int32) *PageIterator[Access] {
+	return newPageIterator[Access](contract.EvaluateTransaction, pageSize, "accesses", "GetAccessesByHealthcareProfessionalID", healthcareProfessionalID)
+}
+
+func NewRequestsWithPatientIterator(contract *client.Contract, patientID string, pageSize int32) *PageIterator[Request] {
+	return newPageIterator[Request](contract.EvaluateTransaction, pageSize, "requests", "GetRequestsWithPatient", patientID)
+}
+
+func NewRequestsWithHealthcareProfessionalIterator(contract *client.Contract, healthcareProfessionalID string, pageSize int32) *PageIterator[Request] {
+	return newPageIterator[Request](contract.EvaluateTransaction, pageSize, "requests", "GetRequestsWithHealthcareProfessional", healthcareProfessionalID)
+}
+
+func NewAccessLogByPatientIDIterator(contract *client.Contract, patientID, healthcareProfessionalID string, dateFrom, dateTo int64, pageSize int32) *PageIterator[AccessLogEntry] {
+	return newPageIterator[AccessLogEntry](contract.EvaluateTransaction, pageSize, "accessLogEntries", "GetAccessLogByPatientID",
+		patientID, healthcareProfessionalID, strconv.FormatInt(dateFrom, 10), strconv.FormatInt(dateTo, 10))
+}
+
+func NewMyAccessLogIterator(contract *client.Contract, healthcareProfessionalID string, dateFrom, dateTo int64, pageSize int32) *PageIterator[AccessLogEntry] {
+	return newPageIterator[AccessLogEntry](contract.EvaluateTransaction, pageSize, "accessLogEntries", "GetMyAccessLog",
+		healthcareProfessionalID, strconv.FormatInt(dateFrom, 10), strconv.FormatInt(dateTo, 10))
+}
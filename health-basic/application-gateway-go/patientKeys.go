@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// O chaincode cifra o conteúdo dos registos com uma chave por paciente, que tem de vir do
+// cliente porque o chaincode não pode gerar números aleatórios. As transações que guardam
+// registos (novos, contestados ou retirados) levam sempre uma chave nova no campo transiente
+// "patientKey" e o chaincode só a usa se o paciente ainda não tiver chave. Os dados transientes
+// não ficam na ledger.
+const (
+	patientKeyTransientField = "patientKey"
+	patientKeySize           = 32
+)
+
+// submitWithPatientKey submete uma transação que guarda registos, com uma chave nova do paciente.
+func submitWithPatientKey(contract *client.Contract, transaction string, args ...string) ([]byte, error) {
+	patientKey := make([]byte, patientKeySize)
+	if _, err := rand.Read(patientKey); err != nil {
+		return nil, fmt.Errorf("failed to generate patient key: %w", err)
+	}
+
+	return contract.Submit(transaction, client.WithArguments(args...),
+		client.WithTransient(map[string][]byte{patientKeyTransientField: patientKey}))
+}
@@ -24,6 +24,7 @@ const (
 	certPath     = cryptoPath + "/users/User1@org1.example.com/msp/signcerts/User1@org1.example.com-cert.pem"
 	keyPath      = cryptoPath + "/users/User1@org1.example.com/msp/keystore/"
 	tlsCertPath  = cryptoPath + "/peers/peer0.org1.example.com/tls/ca.crt"
+	caCertPath   = cryptoPath + "/msp/cacerts/ca.org1.example.com-cert.pem"
 	peerEndpoint = "localhost:7051"
 	gatewayPeer  = "peer0.org1.example.com"
 )
@@ -31,6 +32,19 @@ const (
 type AddPatientMedicalRecordResponse struct {
 	HealthcareProfessionalHasAccess bool `json:"healthcareProfessionalHasAccess"`
 	HealthRecordAlreadyExist        bool `json:"healthRecordAlreadyExist"`
+	HealthRecordAdded               bool `json:"healthRecordAdded"`
+	InvalidRecordType               bool `json:"invalidRecordType"`
+	InvalidSpeciality               bool `json:"invalidSpeciality"`
+	InvalidSignature                bool `json:"invalidSignature"`
+	InvalidSensitivityLabel         bool `json:"invalidSensitivityLabel"`
+	InvalidObservations             bool `json:"invalidObservations"`
+	InvalidEncounter                bool `json:"invalidEncounter"`
+	InvalidLinks                    bool `json:"invalidLinks"`
+}
+
+type CodeReference struct {
+	System string `json:"system"`
+	Code   string `json:"code"`
 }
 
 type SmartContractError struct {
@@ -47,26 +61,34 @@ const (
 )
 
 func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
 	// The gRPC client connection should be shared by all Gateway connections to this endpoint
-	clientConnection := newGrpcConnection()
+	clientConnection, err := newGrpcConnection()
+	if err != nil {
+		return err
+	}
 	defer clientConnection.Close()
 
-	id := newIdentity()
-	sign := newSign()
+	id, err := newIdentity()
+	if err != nil {
+		return err
+	}
+
+	sign, err := newSign()
+	if err != nil {
+		return err
+	}
 
 	// Create a Gateway connection for a specific client identity
-	gw, err := client.Connect(
-		id,
-		client.WithSign(sign),
-		client.WithClientConnection(clientConnection),
-		// Default timeouts for different gRPC calls
-		client.WithEvaluateTimeout(5*time.Second),
-		client.WithEndorseTimeout(15*time.Second),
-		client.WithSubmitTimeout(5*time.Second),
-		client.WithCommitStatusTimeout(1*time.Minute),
-	)
+	gw, err := newGatewayConnection(id, sign, clientConnection)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer gw.Close()
 
@@ -84,74 +106,338 @@ func main() {
 	network := gw.GetNetwork(channelName)
 	contract := network.GetContract(chaincodeName)
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		// go run . audit-report export -patient Teste -dir relatorio
+		case "audit-report":
+			return runAuditReportCommand(contract, id, sign, os.Args[2:])
+		// go run . notify -cert notifier-cert.pem -key notifier-key.pem -smtp-addr localhost:25 -contacts contacts.json
+		case "notify":
+			return runNotifyCommand(clientConnection, network, channelName, chaincodeName, os.Args[2:])
+		// go run . listen -checkpoint events.checkpoint [-from-block 0]
+		case "listen":
+			return runListenCommand(network, chaincodeName, os.Args[2:])
+		// go run . serve -addr :8080 -principals api-principals.json
+		case "serve":
+			return runServeCommand(clientConnection, channelName, chaincodeName, os.Args[2:])
+		}
+	}
+
 	// Solicitar acesso aos dados do paciente
-	// RequestPatientMedicalData(contract, "1", "Teste", "Hospital", "29291240", "Dr. Apollo")
+	// RequestPatientMedicalData(contract, "1", "Teste", "Teste", "Hospital", "29291240", "Dr. Apollo", 1767225600)
 
-	// GetRequestsWithHealthcareProfessional(contract, "29291240")
-	// GetRequestsWithPatient(contract, "Teste")
+	// GetRequestsWithHealthcareProfessional(contract, "29291240", 20, "")
+	// GetRequestsWithPatient(contract, "Teste", 20, "")
 
-	// AnswerRequest(contract, 1, "1", "Teste", 192381)
+	// AnswerRequest(contract, 1, "1", "Teste", []string{})
 
-	RemoveAccess("2fd8fb37-0c6d-4e72-a83a-bac93bf9fb29", "62512f2a1bc071d3a176110a3278bac9bb3a7cb5d3d98bee2c93f9be9796c9ad")
+	// RemoveAccess(contract, "Teste", "1")
 
 	// AddPatientMedicalRecord(contract, "3", "Deslocou o tornozelo a correr na floresta.",
 	// 	"29291240", "Dr. MedTech", "Teste", "Organizacao Hospital",
 	// 	"Urgência médica", "Fisioterapeuta",
-	// 	34080)
+	// 	34080, "", "", nil, "", nil)
 
 	// É respondido por parte do utente que o pedido pode ir lá
-	//GetPatientMedicalHistory(contract, "Teste", "29291240")
+	//GetPatientMedicalHistory(contract, "Teste", "29291240", "treatment", MedicalHistoryQueryOptions{}, 20, "")
+
+	// GetHealthRecordWithPatientByID(contract, "Teste", "1")
+	// GetMedicalHistory(contract, "Teste", MedicalHistoryQueryOptions{SortBy: "eventDate", SortOrder: "desc"}, 20, "")
 
-	GetHealthRecordWithPatientByID(contract, "Teste", "1")
-	// GetMedicalHistory(contract, "Teste")
+	// GetAccessesByPatientID(contract, "Teste", 20, "")
+	// GetAccessesByHealthcareProfessionalID(contract, "29291240", 20, "")
 
-	// GetAccessesByPatientID(contract, "Teste")
-	// GetAccessesByHealthcareProfessionalID(contract, "29291240")
+	// Sem comando não se submete nada; os exemplos acima ficam para testar à mão.
+	return fmt.Errorf("usage: go run . serve|listen|notify|audit-report [flags]")
+}
+
+// printError mostra o erro de uma transação; os exemplos do main continuam para o seguinte.
+func printError(err error) {
+	fmt.Fprintf(os.Stderr, "*** Error: %v\n", err)
 }
 
 // Submit a transaction synchronously, blocking until it has been committed to the ledger.
 // Relembro que estas chamadas só retornam quando a ledger é atualizada, isto é,
 // A transacção completou todo o circuito.
-func AddPatientMedicalRecord(contract *client.Contract, recordID, description, healthCareProfessionalID, healthCareProfessional, patientID, organization, recordType, speciality string, eventDate int64) {
+// A signature (ver SignHealthRecord) é obrigatória quando o profissional tem certificado registado.
+// O sensitivityLabel marca registos sensíveis ("mental-health", "hiv", "sexual-health", "genetic").
+func AddPatientMedicalRecord(contract *client.Contract, recordID, description, healthCareProfessionalID, healthCareProfessional, patientID, organization, recordType, speciality string, eventDate int64, signature, sensitivityLabel string, observations []Observation, encounterID string, links []HealthRecordLink) {
 	fmt.Printf("\n--> Submit Transaction: Criar uma linha na blockchain com dados médicos. \n")
 
 	// Quando queremos submeter uma transação para o chaincode fazemos desta forma.
 	// Colocar como 1º parametro o nome do método que vai ser chamado no chaincode.
 	// Sempre que vamos alterar a bockchain utilizamos o método SubmitTransaction.
 	dateString := int64ToString(eventDate)
+	observationsJSON, err := observationsToJSON(observations)
+	if err != nil {
+		printError(err)
+		return
+	}
 
-	evaluateResult, _ := contract.SubmitTransaction("AddPatientMedicalRecord", recordID, description, healthCareProfessionalID, healthCareProfessional, patientID, organization, recordType, speciality, dateString)
+	if links == nil {
+		links = []HealthRecordLink{}
+	}
 
-	result := formatJSON(evaluateResult)
+	linksJSON, err := json.Marshal(links)
+	if err != nil {
+		printError(fmt.Errorf("failed to serialize links: %w", err))
+		return
+	}
+
+	submitResult, err := submitWithPatientKey(contract, "AddPatientMedicalRecord", recordID, description, healthCareProfessionalID, healthCareProfessional, patientID, organization, recordType, speciality, dateString, signature, sensitivityLabel, observationsJSON, encounterID, string(linksJSON))
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+
+	result := formatJSON(submitResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+
+	fmt.Printf("*** Transaction committed successfully\n")
+}
+
+// O paciente regista os seus próprios dados (tensão arterial, sintomas, medicação habitual).
+func AddPatientReportedRecord(contract *client.Contract, recordID, patientID, description, recordType string, eventDate int64, observations []Observation) {
+	fmt.Printf("\n--> Submit Transaction: Registo de dados pelo próprio paciente. \n")
+
+	observationsJSON, err := observationsToJSON(observations)
+	if err != nil {
+		printError(err)
+		return
+	}
+
+	submitResult, err := submitWithPatientKey(contract, "AddPatientReportedRecord", recordID, patientID, description, recordType, int64ToString(eventDate), observationsJSON)
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+	result := formatJSON(submitResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+
+	fmt.Printf("*** Transaction committed successfully\n")
+}
+
+// O paciente contesta um registo escrito por um profissional.
+func DisputeHealthRecord(contract *client.Contract, patientID, recordID, note string) {
+	fmt.Printf("\n--> Submit Transaction: Vamos contestar um registo médico. \n")
+
+	_, err := submitWithPatientKey(contract, "DisputeHealthRecord", patientID, recordID, note)
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+
+	fmt.Printf("*** Transaction committed successfully\n")
+}
+
+// Abrir um episódio de cuidados (ex.: internamento) para agrupar registos.
+func OpenEncounter(contract *client.Contract, encounterID, patientID, encounterType, organization, healthcareProfessionalID, healthcareProfessional string, startDate int64) {
+	fmt.Printf("\n--> Submit Transaction: Vamos abrir um episódio. \n")
+
+	submitResult, err := contract.SubmitTransaction("OpenEncounter", encounterID, patientID, encounterType, organization, healthcareProfessionalID, healthcareProfessional, int64ToString(startDate))
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+	result := formatJSON(submitResult)
 
 	fmt.Printf("*** Result:%s\n", result)
 
 	fmt.Printf("*** Transaction committed successfully\n")
 }
 
+func CloseEncounter(contract *client.Contract, encounterID, patientID, healthcareProfessionalID string, endDate int64) {
+	fmt.Printf("\n--> Submit Transaction: Vamos fechar um episódio. \n")
+
+	_, err := contract.SubmitTransaction("CloseEncounter", encounterID, patientID, healthcareProfessionalID, int64ToString(endDate))
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+
+	fmt.Printf("*** Transaction committed successfully\n")
+}
+
+// O profissional que escreveu o registo (ou um administrador) marca-o como introduzido por erro.
+func RetractHealthRecord(contract *client.Contract, patientID, recordID, healthcareProfessionalID, reason string) {
+	fmt.Printf("\n--> Submit Transaction: Vamos retirar um registo introduzido por erro. \n")
+
+	_, err := submitWithPatientKey(contract, "RetractHealthRecord", patientID, recordID, healthcareProfessionalID, reason)
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+
+	fmt.Printf("*** Transaction committed successfully\n")
+}
+
 func RemoveAccess(contract *client.Contract, patientID, requestID string) {
 	fmt.Printf("\n--> Submit Transaction: Vamos remover um acesso. \n")
 
 	_, err := contract.SubmitTransaction("RemoveAccess", patientID, requestID)
 
 	if err != nil {
-		panic(fmt.Errorf("failed to submit transaction: %w", err))
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
 	}
 
 	fmt.Printf("*** Transaction committed successfully\n")
 }
 
+// Apagar os dados do paciente (direito ao apagamento): a chave do paciente é destruída e o
+// estado atual fica com tombstones. Devolve o certificado de apagamento.
+func ErasePatientData(contract *client.Contract, patientID, reason string) {
+	fmt.Printf("\n--> Submit Transaction: Vamos apagar os dados do paciente. \n")
+
+	submitResult, err := contract.SubmitTransaction("ErasePatientData", patientID, reason)
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+	result := formatJSON(submitResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+
+	fmt.Printf("*** Transaction committed successfully\n")
+}
+
+// Registar um código de terminologia (só administradores), kind é "recordType", "speciality" ou
+// "observation"; só os códigos de observação têm unidade.
+func AddCode(contract *client.Contract, kind, system, code, display, unit string, references []CodeReference) {
+	fmt.Printf("\n--> Submit Transaction: Vamos registar um código. \n")
+
+	referencesJSON, err := json.Marshal(references)
+	if err != nil {
+		printError(fmt.Errorf("failed to serialize references: %w", err))
+		return
+	}
+
+	_, err = contract.SubmitTransaction("AddCode", kind, system, code, display, unit, string(referencesJSON))
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+
+	fmt.Printf("*** Transaction committed successfully\n")
+}
+
+// Mudar a descrição e as referências de um código existente (só administradores).
+func UpdateCode(contract *client.Contract, kind, code, display string, references []CodeReference) {
+	fmt.Printf("\n--> Submit Transaction: Vamos atualizar um código. \n")
+
+	referencesJSON, err := json.Marshal(references)
+	if err != nil {
+		printError(fmt.Errorf("failed to serialize references: %w", err))
+		return
+	}
+
+	_, err = contract.SubmitTransaction("UpdateCode", kind, code, display, string(referencesJSON))
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+
+	fmt.Printf("*** Transaction committed successfully\n")
+}
+
+// Voltar a permitir um código desativado (só administradores).
+func ReactivateCode(contract *client.Contract, kind, code string) {
+	fmt.Printf("\n--> Submit Transaction: Vamos reativar um código. \n")
+
+	_, err := contract.SubmitTransaction("ReactivateCode", kind, code)
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+
+	fmt.Printf("*** Transaction committed successfully\n")
+}
+
+// Registar o certificado do profissional (só administradores), usado para validar as assinaturas dos registos.
+func RegisterHealthcareProfessionalCertificate(contract *client.Contract, healthcareProfessionalID, certificatePEM string) {
+	fmt.Printf("\n--> Submit Transaction: Vamos registar o certificado do profissional de saúde. \n")
+
+	_, err := contract.SubmitTransaction("RegisterHealthcareProfessionalCertificate", healthcareProfessionalID, certificatePEM)
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+
+	fmt.Printf("*** Transaction committed successfully\n")
+}
+
+// RebuildIndexes submete o RebuildIndexes página a página, até o chaincode não devolver bookmark.
+func RebuildIndexes(contract *client.Contract, pageSize int32) {
+	fmt.Printf("\n--> Submit Transaction: Vamos reconstruir os índices da ledger. \n")
+
+	bookmark := ""
+	for {
+		submitResult, err := contract.SubmitTransaction("RebuildIndexes", int32ToString(pageSize), bookmark)
+		if err != nil {
+			printError(fmt.Errorf("failed to submit transaction: %w", err))
+			return
+		}
+
+		var page struct {
+			FetchedRecordsCount int32  `json:"fetchedRecordsCount"`
+			Bookmark            string `json:"bookmark"`
+		}
+		if err := json.Unmarshal(submitResult, &page); err != nil {
+			printError(fmt.Errorf("failed to parse result: %w", err))
+			return
+		}
+
+		fmt.Printf("*** %d objetos reindexados\n", page.FetchedRecordsCount)
+
+		if page.Bookmark == "" {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+
+	fmt.Printf("*** Transaction committed successfully\n")
+}
+
+func GetCodes(contract *client.Contract, kind string) {
+	fmt.Println("\n--> Evaluate Transaction: Vamos obter os códigos registados")
+
+	evaluateResult, err := contract.EvaluateTransaction("GetCodes", kind)
+	if err != nil {
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+func observationsToJSON(observations []Observation) (string, error) {
+	if observations == nil {
+		observations = []Observation{}
+	}
+
+	observationsJSON, err := json.Marshal(observations)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize observations: %w", err)
+	}
+
+	return string(observationsJSON), nil
+}
+
 func int64ToString(value int64) string {
 	return strconv.FormatInt(value, 10)
 }
 
 // Evaluate a transaction to query ledger state.
-func GetAccessesByPatientID(contract *client.Contract, patientID string) {
+func GetAccessesByPatientID(contract *client.Contract, patientID string, pageSize int32, bookmark string) {
 	fmt.Println("\n--> Evaluate Transaction: Vamos obter os acessos do paciente")
 
-	evaluateResult, err := contract.EvaluateTransaction("GetAccessesByPatientID", patientID)
+	evaluateResult, err := contract.EvaluateTransaction("GetAccessesByPatientID", patientID, int32ToString(pageSize), bookmark)
 	if err != nil {
-		panic(fmt.Errorf("failed to evaluate transaction: %w", err))
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
 	}
 	result := formatJSON(evaluateResult)
 
@@ -159,36 +445,183 @@ func GetAccessesByPatientID(contract *client.Contract, patientID string) {
 }
 
 // Evaluate a transaction to query ledger state.
-func GetAccessesByHealthcareProfessionalID(contract *client.Contract, healthcareProfessionalID string) {
+func GetAccessesByHealthcareProfessionalID(contract *client.Contract, healthcareProfessionalID string, pageSize int32, bookmark string) {
 	fmt.Println("\n--> Evaluate Transaction: Vamos obter os acessos através do profissional de saúde")
 
-	evaluateResult, err := contract.EvaluateTransaction("GetAccessesByHealthcareProfessionalID", healthcareProfessionalID)
+	evaluateResult, err := contract.EvaluateTransaction("GetAccessesByHealthcareProfessionalID", healthcareProfessionalID, int32ToString(pageSize), bookmark)
 	if err != nil {
-		panic(fmt.Errorf("failed to evaluate transaction: %w", err))
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
 	}
 	result := formatJSON(evaluateResult)
 
 	fmt.Printf("*** Result:%s\n", result)
 }
 
-func GetPatientMedicalHistory(contract *client.Contract, patientID, healthcareProfessionalID string) {
-	fmt.Println("\n--> Evaluate Transaction: Vamos obter o histórico médico pelo médico")
+func GetPatientMedicalHistory(contract *client.Contract, patientID, healthcareProfessionalID, purpose string, options MedicalHistoryQueryOptions, pageSize int32, bookmark string) {
+	fmt.Println("\n--> Submit Transaction: Vamos obter o histórico médico pelo médico")
+
+	optionsJSON, err := queryOptionsToJSON(options)
+	if err != nil {
+		printError(err)
+		return
+	}
 
-	evaluateResult, err := contract.EvaluateTransaction("GetPatientMedicalHistory", patientID, healthcareProfessionalID)
+	evaluateResult, err := submitProfessionalRead(contract, "GetPatientMedicalHistory", purpose, patientID, healthcareProfessionalID, optionsJSON, int32ToString(pageSize), bookmark)
 	if err != nil {
-		panic(fmt.Errorf("failed to evaluate transaction: %w", err))
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
 	}
 	result := formatJSON(evaluateResult)
 
 	fmt.Printf("*** Result:%s\n", result)
 }
 
-func GetMedicalHistory(contract *client.Contract, patientID string) {
+func GetMedicalHistory(contract *client.Contract, patientID string, options MedicalHistoryQueryOptions, pageSize int32, bookmark string) {
 	fmt.Println("\n--> Evaluate Transaction: Vamos obter o histórico médico pelo paciente")
 
-	evaluateResult, err := contract.EvaluateTransaction("GetMedicalHistory", patientID)
+	optionsJSON, err := queryOptionsToJSON(options)
+	if err != nil {
+		printError(err)
+		return
+	}
+
+	evaluateResult, err := contract.EvaluateTransaction("GetMedicalHistory", patientID, optionsJSON, int32ToString(pageSize), bookmark)
+	if err != nil {
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+func GetMedicalHistoryByCode(contract *client.Contract, patientID, kind, code string) {
+	fmt.Println("\n--> Evaluate Transaction: Vamos obter o histórico médico por código pelo paciente")
+
+	evaluateResult, err := contract.EvaluateTransaction("GetMedicalHistoryByCode", patientID, kind, code)
+	if err != nil {
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+func GetPatientMedicalHistoryByCode(contract *client.Contract, patientID, healthcareProfessionalID, purpose, kind, code string) {
+	fmt.Println("\n--> Submit Transaction: Vamos obter o histórico médico por código pelo médico")
+
+	evaluateResult, err := submitProfessionalRead(contract, "GetPatientMedicalHistoryByCode", purpose, patientID, healthcareProfessionalID, kind, code)
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+// Série temporal de uma observação (ex.: HbA1c) pelo paciente, para os dashboards.
+func GetObservationSeries(contract *client.Contract, patientID, code string) {
+	fmt.Println("\n--> Evaluate Transaction: Vamos obter a série de uma observação pelo paciente")
+
+	evaluateResult, err := contract.EvaluateTransaction("GetObservationSeries", patientID, code)
+	if err != nil {
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+func GetPatientObservationSeries(contract *client.Contract, patientID, healthcareProfessionalID, purpose, code string) {
+	fmt.Println("\n--> Submit Transaction: Vamos obter a série de uma observação pelo médico")
+
+	evaluateResult, err := submitProfessionalRead(contract, "GetPatientObservationSeries", purpose, patientID, healthcareProfessionalID, code)
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+// Grafo de registos ligados (seguimentos, resultados...) a partir de um registo, pelo paciente.
+func GetHealthRecordGraph(contract *client.Contract, patientID, recordID string) {
+	fmt.Println("\n--> Evaluate Transaction: Vamos obter os registos ligados pelo paciente")
+
+	evaluateResult, err := contract.EvaluateTransaction("GetHealthRecordGraph", patientID, recordID)
+	if err != nil {
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+func GetPatientHealthRecordGraph(contract *client.Contract, patientID, healthcareProfessionalID, purpose, recordID string) {
+	fmt.Println("\n--> Submit Transaction: Vamos obter os registos ligados pelo médico")
+
+	evaluateResult, err := submitProfessionalRead(contract, "GetPatientHealthRecordGraph", purpose, patientID, healthcareProfessionalID, recordID)
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+func GetEncounters(contract *client.Contract, patientID string) {
+	fmt.Println("\n--> Evaluate Transaction: Vamos obter os episódios pelo paciente")
+
+	evaluateResult, err := contract.EvaluateTransaction("GetEncounters", patientID)
+	if err != nil {
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+func GetEncounterRecords(contract *client.Contract, patientID, encounterID string) {
+	fmt.Println("\n--> Evaluate Transaction: Vamos obter os registos de um episódio pelo paciente")
+
+	evaluateResult, err := contract.EvaluateTransaction("GetEncounterRecords", patientID, encounterID)
 	if err != nil {
-		panic(fmt.Errorf("failed to evaluate transaction: %w", err))
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+func GetPatientEncounters(contract *client.Contract, patientID, healthcareProfessionalID, purpose string) {
+	fmt.Println("\n--> Submit Transaction: Vamos obter os episódios pelo médico")
+
+	evaluateResult, err := submitProfessionalRead(contract, "GetPatientEncounters", purpose, patientID, healthcareProfessionalID)
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+func GetPatientEncounterRecords(contract *client.Contract, patientID, healthcareProfessionalID, purpose, encounterID string) {
+	fmt.Println("\n--> Submit Transaction: Vamos obter os registos de um episódio pelo médico")
+
+	evaluateResult, err := submitProfessionalRead(contract, "GetPatientEncounterRecords", purpose, patientID, healthcareProfessionalID, encounterID)
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
 	}
 	result := formatJSON(evaluateResult)
 
@@ -200,7 +633,8 @@ func GetHealthRecordWithPatientByID(contract *client.Contract, patientID, record
 
 	evaluateResult, err := contract.EvaluateTransaction("GetHealthRecordWithPatientByID", patientID, recordID)
 	if err != nil {
-		panic(fmt.Errorf("failed to evaluate transaction: %w", err))
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
 	}
 	result := formatJSON(evaluateResult)
 
@@ -208,24 +642,123 @@ func GetHealthRecordWithPatientByID(contract *client.Contract, patientID, record
 }
 
 // Evaluate a transaction to query ledger state.
-func GetRequestsWithHealthcareProfessional(contract *client.Contract, healthcareProfessionalID string) {
+func GetHealthRecordHistory(contract *client.Contract, patientID, recordID string) {
+	fmt.Println("\n--> Evaluate Transaction: Vamos obter o histórico de versões do registo")
+
+	evaluateResult, err := contract.EvaluateTransaction("GetHealthRecordHistory", patientID, recordID)
+	if err != nil {
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+func GetRequestHistory(contract *client.Contract, patientID, requestID string) {
+	fmt.Println("\n--> Evaluate Transaction: Vamos obter o histórico de versões do pedido")
+
+	evaluateResult, err := contract.EvaluateTransaction("GetRequestHistory", patientID, requestID)
+	if err != nil {
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+func GetAccessHistory(contract *client.Contract, patientID, requestID string) {
+	fmt.Println("\n--> Evaluate Transaction: Vamos obter o histórico de versões do acesso")
+
+	evaluateResult, err := contract.EvaluateTransaction("GetAccessHistory", patientID, requestID)
+	if err != nil {
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+// GetMedicalHistoryAsOf mostra os dados do paciente numa data passada (só auditores),
+// healthCareProfessionalID pode ficar vazio.
+func GetMedicalHistoryAsOf(contract *client.Contract, patientID, healthCareProfessionalID string, asOf int64) {
+	fmt.Println("\n--> Evaluate Transaction: Vamos obter o histórico médico numa data passada")
+
+	evaluateResult, err := contract.EvaluateTransaction("GetMedicalHistoryAsOf", patientID, healthCareProfessionalID, int64ToString(asOf))
+	if err != nil {
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+func GetHealthRecordWithHealthcareProfessionalByID(contract *client.Contract, patientID, healthcareProfessionalID, purpose, recordID string) {
+	fmt.Println("\n--> Submit Transaction: Vamos obter um registo do paciente pelo médico")
+
+	evaluateResult, err := submitProfessionalRead(contract, "GetHealthRecordWithHealthcareProfessionalByID", purpose, patientID, healthcareProfessionalID, recordID)
+	if err != nil {
+		printError(fmt.Errorf("failed to submit transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+// GetAccessLogByPatientID mostra quem leu os dados do paciente. healthcareProfessionalID e as datas
+// são filtros opcionais (vazio/zero não filtra).
+func GetAccessLogByPatientID(contract *client.Contract, patientID, healthcareProfessionalID string, dateFrom, dateTo int64, pageSize int32, bookmark string) {
+	fmt.Println("\n--> Evaluate Transaction: Vamos obter o registo de leituras dos dados do paciente")
+
+	evaluateResult, err := contract.EvaluateTransaction("GetAccessLogByPatientID", patientID, healthcareProfessionalID,
+		int64ToString(dateFrom), int64ToString(dateTo), int32ToString(pageSize), bookmark)
+	if err != nil {
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+func GetMyAccessLog(contract *client.Contract, healthcareProfessionalID string, dateFrom, dateTo int64, pageSize int32, bookmark string) {
+	fmt.Println("\n--> Evaluate Transaction: Vamos obter o registo de leituras do médico")
+
+	evaluateResult, err := contract.EvaluateTransaction("GetMyAccessLog", healthcareProfessionalID,
+		int64ToString(dateFrom), int64ToString(dateTo), int32ToString(pageSize), bookmark)
+	if err != nil {
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+func GetRequestsWithHealthcareProfessional(contract *client.Contract, healthcareProfessionalID string, pageSize int32, bookmark string) {
 	fmt.Println("\n--> Evaluate Transaction: Vamos obter os pedidos efetuados pelo médico")
 
-	evaluateResult, err := contract.EvaluateTransaction("GetRequestsWithHealthcareProfessional", healthcareProfessionalID)
+	evaluateResult, err := contract.EvaluateTransaction("GetRequestsWithHealthcareProfessional", healthcareProfessionalID, int32ToString(pageSize), bookmark)
 	if err != nil {
-		panic(fmt.Errorf("failed to evaluate transaction: %w", err))
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
 	}
 	result := formatJSON(evaluateResult)
 
 	fmt.Printf("*** Result:%s\n", result)
 }
 
-func GetRequestsWithPatient(contract *client.Contract, patientID string) {
+func GetRequestsWithPatient(contract *client.Contract, patientID string, pageSize int32, bookmark string) {
 	fmt.Println("\n--> Evaluate Transaction: Vamos obter os pedidos efetuados pelo paciente")
 
-	evaluateResult, err := contract.EvaluateTransaction("GetRequestsWithPatient", patientID)
+	evaluateResult, err := contract.EvaluateTransaction("GetRequestsWithPatient", patientID, int32ToString(pageSize), bookmark)
 	if err != nil {
-		panic(fmt.Errorf("failed to evaluate transaction: %w", err))
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
 	}
 	result := formatJSON(evaluateResult)
 
@@ -233,60 +766,119 @@ func GetRequestsWithPatient(contract *client.Contract, patientID string) {
 }
 
 // Enviar uma transação para solicitar acesso aos dados de um paciente
-func RequestPatientMedicalData(contract *client.Contract, requestID, patientID, description, healthCareProfessionalID, healthcareProfessional string) {
+func RequestPatientMedicalData(contract *client.Contract, requestID, patientID, patientName, description, healthCareProfessionalID, healthcareProfessional string, expirationDate int64) {
 	fmt.Printf("\n--> Submeter Transação: Solicitar acesso aos dados de um paciente.\n")
 
 	// Submeter uma transação para o chaincode
-	_, err := contract.SubmitTransaction("RequestPatientMedicalData", patientID, description, healthCareProfessionalID, healthcareProfessional, requestID)
+	_, err := contract.SubmitTransaction("RequestPatientMedicalData", patientID, patientName, description, healthCareProfessionalID, healthcareProfessional, requestID, int64ToString(expirationDate))
 	if err != nil {
-		panic(fmt.Errorf("falha ao submeter a transação: %w", err))
+		printError(fmt.Errorf("falha ao submeter a transação: %w", err))
+		return
 	}
 
 	fmt.Printf("*** Transação submetida com sucesso\n")
 }
 
 // Responder a um pedido de acesso aos dados do paciente
-func AnswerRequest(contract *client.Contract, response int, requestID, patientID string, expirationDate int64) {
+// As sensitivityLabels são as etiquetas sensíveis (ex.: "mental-health") que o paciente aceita partilhar.
+func AnswerRequest(contract *client.Contract, response int, requestID, patientID string, sensitivityLabels []string) {
 	fmt.Printf("\n--> Submeter Transação: Responder a um pedido de acesso aos dados do paciente.\n")
 
-	// Converter o requestID para uma string
-	//requestIDString := strconv.Itoa(requestID)
+	responseString := intToString(response)
 
-	// Converter o valor de Status para uma string
-	// responseString := statusToString(response)
+	if sensitivityLabels == nil {
+		sensitivityLabels = []string{}
+	}
 
-	dateString := int64ToString(expirationDate)
-	responseString := intToString(response)
+	labelsJSON, err := json.Marshal(sensitivityLabels)
+	if err != nil {
+		printError(fmt.Errorf("falha ao serializar as etiquetas: %w", err))
+		return
+	}
 
 	// Submeter uma transação para o chaincode
-	_, err := contract.SubmitTransaction("AnswerRequest", responseString, requestID, patientID, dateString)
+	_, err = contract.SubmitTransaction("AnswerRequest", responseString, requestID, patientID, string(labelsJSON))
 	if err != nil {
-		panic(fmt.Errorf("falha ao submeter a transação: %w", err))
+		printError(fmt.Errorf("falha ao submeter a transação: %w", err))
+		return
 	}
 
 	fmt.Printf("*** Transação submetida com sucesso\n")
 }
 
+// Guardar como o paciente quer ser avisado dos pedidos e dos acessos a terminar.
+// Canais: "email", "sms", "push"; tipos: "RequestCreated", "AccessExpiring" (vazio aceita todos);
+// horas de silêncio "HH:MM" (vazias para não ter); língua "pt" ou "en".
+func SetNotificationPreferences(contract *client.Contract, patientID string, preferences NotificationPreferences) {
+	fmt.Printf("\n--> Submeter Transação: Guardar as preferências de notificação do paciente.\n")
+
+	if preferences.Channels == nil {
+		preferences.Channels = []string{}
+	}
+
+	if preferences.EventTypes == nil {
+		preferences.EventTypes = []string{}
+	}
+
+	channelsJSON, err := json.Marshal(preferences.Channels)
+	if err != nil {
+		printError(fmt.Errorf("falha ao serializar os canais: %w", err))
+		return
+	}
+
+	eventTypesJSON, err := json.Marshal(preferences.EventTypes)
+	if err != nil {
+		printError(fmt.Errorf("falha ao serializar os tipos de notificação: %w", err))
+		return
+	}
+
+	_, err = contract.SubmitTransaction("SetNotificationPreferences", patientID, string(channelsJSON),
+		preferences.QuietHoursStart, preferences.QuietHoursEnd, string(eventTypesJSON), preferences.Language)
+	if err != nil {
+		printError(fmt.Errorf("falha ao submeter a transação: %w", err))
+		return
+	}
+
+	fmt.Printf("*** Transação submetida com sucesso\n")
+}
+
+func GetNotificationPreferences(contract *client.Contract, patientID string) {
+	fmt.Println("\n--> Evaluate Transaction: Vamos obter as preferências de notificação do paciente")
+
+	evaluateResult, err := contract.EvaluateTransaction("GetNotificationPreferences", patientID)
+	if err != nil {
+		printError(fmt.Errorf("failed to evaluate transaction: %w", err))
+		return
+	}
+	result := formatJSON(evaluateResult)
+
+	fmt.Printf("*** Result:%s\n", result)
+}
+
+func int32ToString(i int32) string {
+	return strconv.FormatInt(int64(i), 10)
+}
+
 func intToString(i int) string {
 	return strconv.Itoa(i)
 }
 
-// Format JSON data
+// Format JSON data. As transações sem resultado devolvem vazio, que fica como está.
 func formatJSON(data []byte) string {
 	var prettyJSON bytes.Buffer
 	if err := json.Indent(&prettyJSON, data, "", "  "); err != nil {
-		panic(fmt.Errorf("failed to parse JSON: %w", err))
+		return string(data)
 	}
 	return prettyJSON.String()
 }
 
 // newGrpcConnection creates a gRPC connection to the Gateway server.
-func newGrpcConnection() *grpc.ClientConn {
+func newGrpcConnection() (*grpc.ClientConn, error) {
 
 	certificate, err := loadCertificate(tlsCertPath)
 
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	certPool := x509.NewCertPool()
@@ -295,25 +887,34 @@ func newGrpcConnection() *grpc.ClientConn {
 
 	connection, err := grpc.Dial(peerEndpoint, grpc.WithTransportCredentials(transportCredentials))
 	if err != nil {
-		panic(fmt.Errorf("failed to create gRPC connection: %w", err))
+		return nil, fmt.Errorf("failed to create gRPC connection: %w", err)
 	}
 
-	return connection
+	return connection, nil
+}
+
+// newGatewayConnection creates a Gateway connection for a client identity over the shared gRPC connection.
+func newGatewayConnection(id identity.Identity, sign identity.Sign, clientConnection *grpc.ClientConn) (*client.Gateway, error) {
+	return client.Connect(
+		id,
+		client.WithSign(sign),
+		client.WithClientConnection(clientConnection),
+		// Default timeouts for different gRPC calls
+		client.WithEvaluateTimeout(5*time.Second),
+		client.WithEndorseTimeout(15*time.Second),
+		client.WithSubmitTimeout(5*time.Second),
+		client.WithCommitStatusTimeout(1*time.Minute),
+	)
 }
 
 // newIdentity creates a client identity for this Gateway connection using an X.509 certificate.
-func newIdentity() *identity.X509Identity {
+func newIdentity() (*identity.X509Identity, error) {
 	certificate, err := loadCertificate(certPath)
 	if err != nil {
-		panic(err)
-	}
-
-	id, err := identity.NewX509Identity(mspID, certificate)
-	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return id
+	return identity.NewX509Identity(mspID, certificate)
 }
 
 func loadCertificate(filename string) (*x509.Certificate, error) {
@@ -325,26 +926,30 @@ func loadCertificate(filename string) (*x509.Certificate, error) {
 }
 
 // newSign creates a function that generates a digital signature from a message digest using a private key.
-func newSign() identity.Sign {
+func newSign() (identity.Sign, error) {
 	files, err := os.ReadDir(keyPath)
 	if err != nil {
-		panic(fmt.Errorf("failed to read private key directory: %w", err))
+		return nil, fmt.Errorf("failed to read private key directory: %w", err)
 	}
-	privateKeyPEM, err := os.ReadFile(path.Join(keyPath, files[0].Name()))
 
-	if err != nil {
-		panic(fmt.Errorf("failed to read private key file: %w", err))
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no private key in %s", keyPath)
 	}
 
-	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
+	return loadSign(path.Join(keyPath, files[0].Name()))
+}
+
+// loadSign creates the signing function for the PEM private key in filename.
+func loadSign(filename string) (identity.Sign, error) {
+	privateKeyPEM, err := os.ReadFile(filename)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
 	}
 
-	sign, err := identity.NewPrivateKeySign(privateKey)
+	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return sign
+	return identity.NewPrivateKeySign(privateKey)
 }
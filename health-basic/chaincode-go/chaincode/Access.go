@@ -1,12 +1,18 @@
 package chaincode
 
 type Access struct {
-	ResourceType             int    `json:"resourceType"` // 2
-	RequestID                string `json:"requestID"`
-	PatientID                string `json:"patientID"`
-	PatientName              string `json:"patientName"`
-	HealthcareProfessionalID string `json:"healthcareProfessionalID"`
-	HealthcareProfessional   string `json:"healthcareProfessional"`
-	CreatedDate              int64  `json:"createdDate"`
-	ExpirationDate           int64  `json:"expirationDate"`
+	ResourceType             int      `json:"resourceType"` // 2
+	RequestID                string   `json:"requestID"`
+	PatientID                string   `json:"patientID"`
+	PatientName              string   `json:"patientName"`
+	HealthcareProfessionalID string   `json:"healthcareProfessionalID"`
+	HealthcareProfessional   string   `json:"healthcareProfessional"`
+	CreatedDate              int64    `json:"createdDate"`
+	ExpirationDate           int64    `json:"expirationDate"`
+	SensitivityLabels        []string `json:"sensitivityLabels"`
+	RevokedDate              int64    `json:"revokedDate"`
+	Emergency                bool     `json:"emergency"`
+	EmergencyReason          string   `json:"emergencyReason"`
+	Erased                   bool     `json:"erased"`
+	ErasedDate               int64    `json:"erasedDate"`
 }
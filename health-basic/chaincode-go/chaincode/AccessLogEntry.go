@@ -0,0 +1,17 @@
+package chaincode
+
+// Registo de uma leitura auditada: que profissional leu que registos do paciente,
+// com que finalidade e ao abrigo de que pedidos (acessos).
+type AccessLogEntry struct {
+	ResourceType             int      `json:"resourceType"` // 9
+	TxID                     string   `json:"txID"`
+	PatientID                string   `json:"patientID"`
+	HealthcareProfessionalID string   `json:"healthcareProfessionalID"`
+	Transaction              string   `json:"transaction"`
+	Purpose                  string   `json:"purpose"`
+	RecordIDs                []string `json:"recordIDs"`
+	RequestIDs               []string `json:"requestIDs"`
+	CreatedDate              int64    `json:"createdDate"`
+	Erased                   bool     `json:"erased"`
+	ErasedDate               int64    `json:"erasedDate"`
+}
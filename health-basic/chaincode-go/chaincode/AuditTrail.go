@@ -0,0 +1,343 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Tipos de eventos do relatório de auditoria.
+const (
+	AuditEventRequestCreated    = "RequestCreated"
+	AuditEventRequestAnswered   = "RequestAnswered"
+	AuditEventRequestErased     = "RequestErased"
+	AuditEventAccessGranted     = "AccessGranted"
+	AuditEventEmergencyAccess   = "EmergencyAccess"
+	AuditEventAccessRevoked     = "AccessRevoked"
+	AuditEventAccessErased      = "AccessErased"
+	AuditEventHealthRecordsRead = "HealthRecordsRead"
+)
+
+// Um acontecimento do relatório de auditoria, reconstruído do histórico das chaves
+// e do registo de leituras. WriterMSPID é a organização de quem submeteu a transação.
+type AuditEvent struct {
+	Type                     string   `json:"type"`
+	TxID                     string   `json:"txID"`
+	Timestamp                int64    `json:"timestamp"`
+	PatientID                string   `json:"patientID"`
+	HealthcareProfessionalID string   `json:"healthcareProfessionalID"`
+	RequestID                string   `json:"requestID"`
+	RecordIDs                []string `json:"recordIDs"`
+	Details                  string   `json:"details"`
+	WriterID                 string   `json:"writerID"`
+	WriterMSPID              string   `json:"writerMSPID"`
+}
+
+// getAuditTrail devolve uma página dos pedidos, respostas, acessos dados e cortados e
+// leituras auditadas entre as datas indicadas (zero não limita) e da organização indicada
+// (vazio não filtra). Com o paciente percorre os pedidos, acessos e leituras dele; sem o
+// paciente percorre os índices por data, só entre as datas indicadas. Cada página junta os
+// acontecimentos de até pageSize objetos, ordenados; quem junta várias páginas ordena de novo.
+func getAuditTrail(ctx contractapi.TransactionContextInterface, patientID, organization string,
+	dateFrom, dateTo int64, pageSize int32, bookmark string) (*AuditTrailPage, error) {
+
+	page := AuditTrailPage{}
+	page.Events = []AuditEvent{}
+
+	// include junta à página os acontecimentos dentro das datas e da organização e diz se havia algum.
+	include := func(events []AuditEvent) bool {
+		included := false
+		for _, event := range events {
+			if (dateFrom != 0 && event.Timestamp < dateFrom) || (dateTo != 0 && event.Timestamp > dateTo) {
+				continue
+			}
+			if organization != "" && event.WriterMSPID != organization {
+				continue
+			}
+			page.Events = append(page.Events, event)
+			included = true
+		}
+		return included
+	}
+
+	from, to := sortableDate(dateFrom), sortableDate(dateTo)
+	if dateFrom == 0 {
+		from = sortableDate(math.MinInt64)
+	}
+	if dateTo == 0 {
+		to = sortableDate(math.MaxInt64)
+	}
+
+	visitAccessLogEntry := func(key string, value []byte) (bool, error) {
+		event, err := getAccessLogAuditEvent(ctx, value)
+		if err != nil {
+			return false, err
+		}
+		return include([]AuditEvent{*event}), nil
+	}
+
+	var scans []stateScan
+
+	if patientID != "" {
+		patientAttributes := []string{"patientID", patientID}
+
+		accessLogRange, err := dateKeyRange(ctx, "AccessLog", patientAttributes, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		scans = []stateScan{
+			{objectType: "Requests", attributes: patientAttributes, visit: func(key string, value []byte) (bool, error) {
+				events, err := getRequestAuditEvents(ctx, key)
+				if err != nil {
+					return false, err
+				}
+				return include(events), nil
+			}},
+			{objectType: accessesByPatientIndex, attributes: patientAttributes, visit: func(key string, value []byte) (bool, error) {
+				events, err := getAccessAuditEvents(ctx, key)
+				if err != nil {
+					return false, err
+				}
+				return include(events), nil
+			}},
+			{objectType: "AccessLog", attributes: patientAttributes, keyRange: accessLogRange, visit: visitAccessLogEntry},
+		}
+	} else {
+		requestsRange, err := dateKeyRange(ctx, requestsByChangeDateIndex, []string{}, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		accessesRange, err := dateKeyRange(ctx, accessesByChangeDateIndex, []string{}, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		accessLogRange, err := dateKeyRange(ctx, accessLogByDateIndex, []string{}, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		scans = []stateScan{
+			{objectType: requestsByChangeDateIndex, attributes: []string{}, keyRange: requestsRange, visit: func(key string, value []byte) (bool, error) {
+				events, err := getChangeAuditEvents(ctx, key, value, getRequestAuditEvents)
+				if err != nil {
+					return false, err
+				}
+				return include(events), nil
+			}},
+			{objectType: accessesByChangeDateIndex, attributes: []string{}, keyRange: accessesRange, visit: func(key string, value []byte) (bool, error) {
+				events, err := getChangeAuditEvents(ctx, key, value, getAccessAuditEvents)
+				if err != nil {
+					return false, err
+				}
+				return include(events), nil
+			}},
+			{objectType: accessLogByDateIndex, attributes: []string{}, keyRange: accessLogRange, visit: visitAccessLogEntry},
+		}
+	}
+
+	fetched, nextBookmark, err := scanStatesPage(ctx, scanStatePage, scans, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(page.Events, func(i, j int) bool {
+		if page.Events[i].Timestamp != page.Events[j].Timestamp {
+			return page.Events[i].Timestamp < page.Events[j].Timestamp
+		}
+		return page.Events[i].TxID < page.Events[j].TxID
+	})
+
+	page.FetchedRecordsCount = fetched
+	page.Bookmark = nextBookmark
+
+	return &page, nil
+}
+
+// getChangeAuditEvents devolve os acontecimentos de um objeto com a data de uma chave dos
+// índices de alterações por data (o valor da chave é a chave primária do objeto). As outras
+// alterações do objeto têm as suas próprias chaves, por isso não se repetem entre elas.
+func getChangeAuditEvents(ctx contractapi.TransactionContextInterface, indexKey string, primaryKey []byte,
+	getEvents func(ctx contractapi.TransactionContextInterface, key string) ([]AuditEvent, error)) ([]AuditEvent, error) {
+
+	_, attributes, err := ctx.GetStub().SplitCompositeKey(indexKey)
+	if err != nil || len(attributes) < 2 {
+		return nil, fmt.Errorf("invalid index key %q", indexKey)
+	}
+
+	date, err := parseSortableDate(attributes[1])
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := getEvents(ctx, string(primaryKey))
+	if err != nil {
+		return nil, err
+	}
+
+	var changes = []AuditEvent{}
+	for _, event := range events {
+		if event.Timestamp == date {
+			changes = append(changes, event)
+		}
+	}
+
+	return changes, nil
+}
+
+// getAccessLogAuditEvent converte uma leitura auditada num acontecimento.
+func getAccessLogAuditEvent(ctx contractapi.TransactionContextInterface, value []byte) (*AuditEvent, error) {
+
+	var entry AccessLogEntry
+	if err := json.Unmarshal(value, &entry); err != nil {
+		return nil, fmt.Errorf("error unmarshalling access log entry: %v", err)
+	}
+
+	writer, err := getTransactionWriter(ctx, entry.TxID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuditEvent{
+		Type:                     AuditEventHealthRecordsRead,
+		TxID:                     entry.TxID,
+		Timestamp:                entry.CreatedDate,
+		PatientID:                entry.PatientID,
+		HealthcareProfessionalID: entry.HealthcareProfessionalID,
+		RecordIDs:                entry.RecordIDs,
+		Details:                  fmt.Sprintf("%s (purpose: %s, requests: %v)", entry.Transaction, entry.Purpose, entry.RequestIDs),
+		WriterID:                 writer.ClientID,
+		WriterMSPID:              writer.MSPID,
+	}, nil
+}
+
+// getRequestAuditEvents compara cada versão do pedido com a anterior.
+func getRequestAuditEvents(ctx contractapi.TransactionContextInterface, key string) ([]AuditEvent, error) {
+
+	var events = []AuditEvent{}
+
+	modifications, err := getKeyHistory(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous *Request
+
+	// O histórico vem do mais recente para o mais antigo.
+	for i := len(modifications) - 1; i >= 0; i-- {
+		modification := modifications[i]
+		if modification.isDelete {
+			previous = nil
+			continue
+		}
+
+		var request Request
+		if err := json.Unmarshal(modification.value, &request); err != nil {
+			return nil, fmt.Errorf("error unmarshalling request: %v", err)
+		}
+
+		event := AuditEvent{
+			TxID:                     modification.txID,
+			Timestamp:                modification.timestamp,
+			PatientID:                request.PatientID,
+			HealthcareProfessionalID: request.HealthcareProfessionalID,
+			RequestID:                request.RequestID,
+			RecordIDs:                []string{},
+			WriterID:                 modification.writer.ClientID,
+			WriterMSPID:              modification.writer.MSPID,
+		}
+
+		switch {
+		case previous == nil:
+			event.Type = AuditEventRequestCreated
+			event.Details = fmt.Sprintf("expires %d", request.ExpirationDate)
+		case request.Erased && !previous.Erased:
+			event.Type = AuditEventRequestErased
+		case request.Status != previous.Status:
+			event.Type = AuditEventRequestAnswered
+			event.Details = fmt.Sprintf("status %d", request.Status)
+		}
+
+		if event.Type != "" {
+			events = append(events, event)
+		}
+
+		previous = &request
+	}
+
+	return events, nil
+}
+
+// getAccessAuditEvents compara cada versão do acesso com a anterior. Um acesso cortado
+// pelo RemoveAccess fica com a data de expiração antecipada.
+func getAccessAuditEvents(ctx contractapi.TransactionContextInterface, key string) ([]AuditEvent, error) {
+
+	var events = []AuditEvent{}
+
+	modifications, err := getKeyHistory(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous *Access
+
+	for i := len(modifications) - 1; i >= 0; i-- {
+		modification := modifications[i]
+		if modification.isDelete {
+			previous = nil
+			continue
+		}
+
+		var access Access
+		if err := json.Unmarshal(modification.value, &access); err != nil {
+			return nil, fmt.Errorf("error unmarshalling access: %v", err)
+		}
+
+		event := AuditEvent{
+			TxID:                     modification.txID,
+			Timestamp:                modification.timestamp,
+			PatientID:                access.PatientID,
+			HealthcareProfessionalID: access.HealthcareProfessionalID,
+			RequestID:                access.RequestID,
+			RecordIDs:                []string{},
+			WriterID:                 modification.writer.ClientID,
+			WriterMSPID:              modification.writer.MSPID,
+		}
+
+		switch {
+		case previous == nil && access.Emergency:
+			event.Type = AuditEventEmergencyAccess
+			event.Details = fmt.Sprintf("expires %d, reason: %s", access.ExpirationDate, access.EmergencyReason)
+		case previous == nil:
+			event.Type = AuditEventAccessGranted
+			event.Details = fmt.Sprintf("expires %d, sensitivity labels %v", access.ExpirationDate, access.SensitivityLabels)
+		case access.Erased && !previous.Erased:
+			event.Type = AuditEventAccessErased
+		case access.ExpirationDate < previous.ExpirationDate:
+			event.Type = AuditEventAccessRevoked
+			event.Details = fmt.Sprintf("expiration moved from %d to %d", previous.ExpirationDate, access.ExpirationDate)
+		}
+
+		if event.Type != "" {
+			events = append(events, event)
+		}
+
+		previous = &access
+	}
+
+	// O motivo de um acesso de emergência é conteúdo clínico: depois do apagamento só fica a data.
+	if previous != nil && previous.Erased {
+		for i := range events {
+			if events[i].Type == AuditEventEmergencyAccess {
+				events[i].Details = fmt.Sprintf("expires %d", previous.ExpirationDate)
+			}
+		}
+	}
+
+	return events, nil
+}
@@ -0,0 +1,33 @@
+package chaincode
+
+// Tipos de código suportados pelo registo de terminologia.
+const (
+	CodeKindRecordType  = "recordType"
+	CodeKindSpeciality  = "speciality"
+	CodeKindObservation = "observation"
+)
+
+// Sistemas de códigos reconhecidos, "local" para os códigos da própria rede.
+var codeSystems = map[string]bool{
+	"local":     true,
+	"ICD-10":    true,
+	"SNOMED-CT": true,
+	"LOINC":     true,
+}
+
+type CodeReference struct {
+	System string `json:"system"`
+	Code   string `json:"code"`
+}
+
+type Code struct {
+	ResourceType int             `json:"resourceType"` // 5
+	Kind         string          `json:"kind"`
+	System       string          `json:"system"`
+	Code         string          `json:"code"`
+	Display      string          `json:"display"`
+	References   []CodeReference `json:"references"`
+	Unit         string          `json:"unit"` // unidade UCUM das observações, só no kind "observation"
+	Active       bool            `json:"active"`
+	CreatedDate  int64           `json:"createdDate"`
+}
@@ -0,0 +1,26 @@
+package chaincode
+
+// Tipos de episódio de cuidados aceites.
+var encounterTypes = map[string]bool{
+	"inpatient":  true,
+	"outpatient": true,
+	"emergency":  true,
+	"home":       true,
+	"virtual":    true,
+}
+
+type Encounter struct {
+	ResourceType                      int    `json:"resourceType"` // 7
+	EncounterID                       string `json:"encounterID"`
+	PatientID                         string `json:"patientID"`
+	Type                              string `json:"type"`
+	Organization                      string `json:"organization"`
+	AttendingHealthcareProfessionalID string `json:"attendingHealthcareProfessionalID"`
+	AttendingHealthcareProfessional   string `json:"attendingHealthcareProfessional"`
+	StartDate                         int64  `json:"startDate"`
+	EndDate                           int64  `json:"endDate"`
+	Status                            int    `json:"status"` // 0 aberto, 1 fechado
+	CreatedDate                       int64  `json:"createdDate"`
+	Erased                            bool   `json:"erased"`
+	ErasedDate                        int64  `json:"erasedDate"`
+}
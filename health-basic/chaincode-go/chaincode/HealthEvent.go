@@ -0,0 +1,95 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Nome do evento de chaincode e versão do formato do payload. A versão só muda
+// quando um campo muda de significado ou deixa de existir; campos novos não a mudam.
+const (
+	healthEventName          = "HealthEvents"
+	HealthEventSchemaVersion = 1
+)
+
+// Tipos de eventos emitidos pelas transações que alteram o estado.
+const (
+	EventRequestCreated                 = "RequestCreated"
+	EventRequestAnswered                = "RequestAnswered"
+	EventAccessGranted                  = "AccessGranted"
+	EventAccessRevoked                  = "AccessRevoked"
+	EventHealthRecordAdded              = "HealthRecordAdded"
+	EventHealthRecordDisputed           = "HealthRecordDisputed"
+	EventHealthRecordRetracted          = "HealthRecordRetracted"
+	EventHealthRecordsRead              = "HealthRecordsRead"
+	EventEncounterOpened                = "EncounterOpened"
+	EventEncounterClosed                = "EncounterClosed"
+	EventPatientDataErased              = "PatientDataErased"
+	EventNotificationPreferencesChanged = "NotificationPreferencesChanged"
+)
+
+// Um acontecimento lógico. Os campos são sempre os mesmos para todos os tipos (vazios
+// quando não se aplicam). Os eventos chegam a todos os clientes do canal, por isso só
+// levam identificadores e nunca conteúdo clínico (descrições, notas, motivos, nomes) nem
+// as etiquetas sensíveis, que dizem que tipo de dados o paciente tem.
+type HealthEvent struct {
+	Type                     string   `json:"type"`
+	PatientID                string   `json:"patientID"`
+	HealthcareProfessionalID string   `json:"healthcareProfessionalID"`
+	RequestID                string   `json:"requestID"`
+	RecordID                 string   `json:"recordID"`
+	RecordIDs                []string `json:"recordIDs"`
+	EncounterID              string   `json:"encounterID"`
+	Status                   int      `json:"status"`
+	ExpirationDate           int64    `json:"expirationDate"`
+}
+
+// O payload do evento HealthEvents: todos os acontecimentos de uma transação.
+type HealthEventBatch struct {
+	Version        int           `json:"version"`
+	TxID           string        `json:"txID"`
+	Timestamp      int64         `json:"timestamp"`
+	SubmitterMSPID string        `json:"submitterMSPID"`
+	Events         []HealthEvent `json:"events"`
+}
+
+// setHealthEvents emite os acontecimentos da transação num único evento. O Fabric só
+// guarda o último SetEvent de cada transação, por isso cada transação junta os seus
+// acontecimentos e chama esta função uma vez, no fim.
+func setHealthEvents(ctx contractapi.TransactionContextInterface, events ...HealthEvent) error {
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	txDate, err := getTxDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+
+	for i := range events {
+		if events[i].RecordIDs == nil {
+			events[i].RecordIDs = []string{}
+		}
+	}
+
+	batchJSON, err := json.Marshal(HealthEventBatch{
+		Version:        HealthEventSchemaVersion,
+		TxID:           ctx.GetStub().GetTxID(),
+		Timestamp:      txDate,
+		SubmitterMSPID: mspID,
+		Events:         events,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to serialize event to JSON: %v", err)
+	}
+
+	return ctx.GetStub().SetEvent(healthEventName, batchJSON)
+}
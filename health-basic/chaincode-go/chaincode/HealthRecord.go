@@ -1,15 +1,48 @@
 package chaincode
 
 type HealthRecord struct {
-	ResourceType             int    `json:"resourceType"` // 3
-	RecordID                 string `json:"recordID"`
-	PatientID                string `json:"patientID"`
-	Description              string `json:"description"`
-	HealthCareProfessionalID string `json:"healthCareProfessionalID"`
-	HealthCareProfessional   string `json:"healthCareProfessional"`
-	CreatedDate              int64  `json:"createdDate"`
-	EventDate                int64  `json:"eventDate"`
-	Speciality               string `json:"speciality"`
-	RecordType               string `json:"recordType"`
-	Organization             string `json:"organization"`
+	ResourceType             int                   `json:"resourceType"` // 3
+	RecordID                 string                `json:"recordID"`
+	PatientID                string                `json:"patientID"`
+	Description              string                `json:"description"`
+	HealthCareProfessionalID string                `json:"healthCareProfessionalID"`
+	HealthCareProfessional   string                `json:"healthCareProfessional"`
+	CreatedDate              int64                 `json:"createdDate"`
+	EventDate                int64                 `json:"eventDate"`
+	Speciality               string                `json:"speciality"`
+	SpecialityCode           string                `json:"specialityCode"`
+	RecordType               string                `json:"recordType"`
+	RecordTypeCode           string                `json:"recordTypeCode"`
+	Organization             string                `json:"organization"`
+	EncounterID              string                `json:"encounterID"`
+	Links                    []HealthRecordLink    `json:"links"`
+	SensitivityLabel         string                `json:"sensitivityLabel"`
+	Observations             []Observation         `json:"observations"`
+	PatientAuthored          bool                  `json:"patientAuthored"`
+	Disputes                 []HealthRecordDispute `json:"disputes"`
+	Signature                string                `json:"signature"`
+	SignerCertificate        string                `json:"signerCertificate"`
+	EnteredInError           bool                  `json:"enteredInError"`
+	RetractedReason          string                `json:"retractedReason"`
+	RetractedDate            int64                 `json:"retractedDate"`
+	RetractedBy              string                `json:"retractedBy"`
+	EncryptedContent         string                `json:"encryptedContent"` // ver PatientKeys.go
+	Erased                   bool                  `json:"erased"`
+	ErasedDate               int64                 `json:"erasedDate"`
+}
+
+// Nota do paciente a contestar um registo escrito por um profissional.
+type HealthRecordDispute struct {
+	DisputeID   string `json:"disputeID"`
+	Note        string `json:"note"`
+	CreatedDate int64  `json:"createdDate"`
+}
+
+// Etiquetas de registos sensíveis, que só são partilhados se o paciente as
+// incluir explicitamente ao aceitar o pedido de acesso.
+var knownSensitivityLabels = map[string]bool{
+	"mental-health": true,
+	"hiv":           true,
+	"sexual-health": true,
+	"genetic":       true,
 }
@@ -0,0 +1,148 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Relações aceites entre registos.
+var healthRecordRelations = map[string]bool{
+	"follow-up-of": true,
+	"result-of":    true,
+	"replaces":     true,
+	"related-to":   true,
+}
+
+// Ligação de um registo a outro registo do mesmo paciente.
+type HealthRecordLink struct {
+	RecordID string `json:"recordID"`
+	Relation string `json:"relation"`
+}
+
+type HealthRecordGraphEdge struct {
+	FromRecordID string `json:"fromRecordID"`
+	ToRecordID   string `json:"toRecordID"`
+	Relation     string `json:"relation"`
+}
+
+// Grafo do episódio de cuidados a que um registo pertence.
+type HealthRecordGraph struct {
+	HealthRecords []HealthRecord          `json:"healthRecords"`
+	Edges         []HealthRecordGraphEdge `json:"edges"`
+}
+
+// validateHealthRecordLinks confirma que as relações são conhecidas e que os registos
+// referidos existem para o mesmo paciente e não foram retirados nem apagados.
+func validateHealthRecordLinks(ctx contractapi.TransactionContextInterface, patientID, recordID string, links []HealthRecordLink) bool {
+
+	for _, link := range links {
+		if !healthRecordRelations[link.Relation] || link.RecordID == "" || link.RecordID == recordID {
+			return false
+		}
+
+		linkedRecord, err := getHealthRecordByID(ctx, patientID, link.RecordID)
+		if err != nil || linkedRecord.RecordID == "" || linkedRecord.EnteredInError || linkedRecord.Erased {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getHealthRecordGraph percorre as ligações em ambos os sentidos a partir do registo,
+// devolvendo os registos ligados e as arestas entre eles. Os registos para os quais
+// visible devolve false não entram no grafo nem são atravessados, para que os registos
+// só alcançáveis através deles também fiquem de fora.
+func getHealthRecordGraph(ctx contractapi.TransactionContextInterface, patientID, recordID string,
+	visible func(HealthRecord) bool) (*HealthRecordGraph, error) {
+
+	graph := HealthRecordGraph{
+		HealthRecords: []HealthRecord{},
+		Edges:         []HealthRecordGraphEdge{},
+	}
+
+	visited := map[string]bool{}
+	seenEdges := map[HealthRecordGraphEdge]bool{}
+	pending := []string{recordID}
+
+	for len(pending) > 0 {
+		currentID := pending[0]
+		pending = pending[1:]
+
+		if visited[currentID] {
+			continue
+		}
+		visited[currentID] = true
+
+		healthRecord, err := getHealthRecordByID(ctx, patientID, currentID)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao obter o dado de saúde: %v", err)
+		}
+
+		if healthRecord.RecordID == "" || !visible(*healthRecord) {
+			continue
+		}
+
+		graph.HealthRecords = append(graph.HealthRecords, *healthRecord)
+
+		for _, link := range healthRecord.Links {
+			edge := HealthRecordGraphEdge{FromRecordID: currentID, ToRecordID: link.RecordID, Relation: link.Relation}
+			if !seenEdges[edge] {
+				seenEdges[edge] = true
+				graph.Edges = append(graph.Edges, edge)
+			}
+			pending = append(pending, link.RecordID)
+		}
+
+		linkingRecords, err := getHealthRecordsLinkingTo(ctx, patientID, currentID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, linkingRecord := range linkingRecords {
+			for _, link := range linkingRecord.Links {
+				if link.RecordID != currentID {
+					continue
+				}
+				edge := HealthRecordGraphEdge{FromRecordID: linkingRecord.RecordID, ToRecordID: currentID, Relation: link.Relation}
+				if !seenEdges[edge] {
+					seenEdges[edge] = true
+					graph.Edges = append(graph.Edges, edge)
+				}
+			}
+			pending = append(pending, linkingRecord.RecordID)
+		}
+	}
+
+	return filterHealthRecordGraph(&graph, graph.HealthRecords), nil
+}
+
+func getHealthRecordsLinkingTo(ctx contractapi.TransactionContextInterface, patientID, recordID string) ([]HealthRecord, error) {
+
+	return getHealthRecords(ctx, healthRecordLinksIndex, []string{"patientID", patientID, "recordID", recordID},
+		func(healthRecord HealthRecord) bool { return true })
+}
+
+// filterHealthRecordGraph deixa no grafo só os registos visíveis, e as arestas entre eles.
+// As arestas para registos que não foram incluídos no grafo também saem.
+func filterHealthRecordGraph(graph *HealthRecordGraph, visible []HealthRecord) *HealthRecordGraph {
+
+	visibleIDs := map[string]bool{}
+	for _, healthRecord := range visible {
+		visibleIDs[healthRecord.RecordID] = true
+	}
+
+	filtered := HealthRecordGraph{
+		HealthRecords: visible,
+		Edges:         []HealthRecordGraphEdge{},
+	}
+
+	for _, edge := range graph.Edges {
+		if visibleIDs[edge.FromRecordID] && visibleIDs[edge.ToRecordID] {
+			filtered.Edges = append(filtered.Edges, edge)
+		}
+	}
+
+	return &filtered
+}
@@ -0,0 +1,8 @@
+package chaincode
+
+type HealthcareProfessionalCertificate struct {
+	ResourceType             int    `json:"resourceType"` // 6
+	HealthcareProfessionalID string `json:"healthcareProfessionalID"`
+	CertificatePEM           string `json:"certificatePEM"`
+	CreatedDate              int64  `json:"createdDate"`
+}
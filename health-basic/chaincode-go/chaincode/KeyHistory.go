@@ -0,0 +1,355 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Versões de um objeto, tal como ficaram na ledger em cada transação.
+// O objeto vem vazio nas versões em que a chave foi apagada e nas anteriores ao
+// apagamento dos dados do paciente (ErasePatientData), que só mostram os metadados.
+type HealthRecordVersion struct {
+	TxID         string       `json:"txID"`
+	Timestamp    int64        `json:"timestamp"`
+	IsDelete     bool         `json:"isDelete"`
+	WriterID     string       `json:"writerID"`
+	WriterMSPID  string       `json:"writerMSPID"`
+	HealthRecord HealthRecord `json:"healthRecord"`
+}
+
+type RequestVersion struct {
+	TxID        string  `json:"txID"`
+	Timestamp   int64   `json:"timestamp"`
+	IsDelete    bool    `json:"isDelete"`
+	WriterID    string  `json:"writerID"`
+	WriterMSPID string  `json:"writerMSPID"`
+	Request     Request `json:"request"`
+}
+
+type AccessVersion struct {
+	TxID        string `json:"txID"`
+	Timestamp   int64  `json:"timestamp"`
+	IsDelete    bool   `json:"isDelete"`
+	WriterID    string `json:"writerID"`
+	WriterMSPID string `json:"writerMSPID"`
+	Access      Access `json:"access"`
+}
+
+// keyModification é uma versão da chave já com a identidade de quem a escreveu.
+type keyModification struct {
+	txID      string
+	timestamp int64
+	isDelete  bool
+	writer    TransactionWriter
+	value     []byte
+}
+
+// storeTransactionWriter guarda a identidade de quem invoca, uma vez por transação.
+// Deve ser chamado por todas as transações que alteram registos, pedidos ou acessos.
+func storeTransactionWriter(ctx contractapi.TransactionContextInterface) error {
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+
+	createdDate, err := getTxDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	writer := TransactionWriter{
+		ResourceType: 8,
+		TxID:         ctx.GetStub().GetTxID(),
+		ClientID:     clientID,
+		MSPID:        mspID,
+		CreatedDate:  createdDate,
+	}
+
+	compositeKey, err := createTransactionWriterCompositeKey(ctx, writer.TxID)
+	if err != nil {
+		return err
+	}
+
+	writerJSON, err := json.Marshal(writer)
+	if err != nil {
+		return fmt.Errorf("failed to serialize transaction writer to JSON: %v", err)
+	}
+
+	err = ctx.GetStub().PutState(compositeKey, writerJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store transaction writer on the ledger: %v", err)
+	}
+
+	return nil
+}
+
+// getTransactionWriter devolve quem escreveu na transação. Para as transações
+// anteriores a guardarmos esta informação devolve uma identidade vazia.
+func getTransactionWriter(ctx contractapi.TransactionContextInterface, txID string) (TransactionWriter, error) {
+
+	var writer TransactionWriter
+
+	compositeKey, err := createTransactionWriterCompositeKey(ctx, txID)
+	if err != nil {
+		return writer, err
+	}
+
+	writerJSON, err := ctx.GetStub().GetState(compositeKey)
+	if err != nil {
+		return writer, fmt.Errorf("failed to read transaction writer from the ledger: %v", err)
+	}
+
+	if writerJSON == nil {
+		return writer, nil
+	}
+
+	if err := json.Unmarshal(writerJSON, &writer); err != nil {
+		return writer, fmt.Errorf("error unmarshalling transaction writer: %v", err)
+	}
+
+	return writer, nil
+}
+
+// getKeyHistory devolve todas as versões da chave, da mais recente para a mais antiga.
+func getKeyHistory(ctx contractapi.TransactionContextInterface, key string) ([]keyModification, error) {
+
+	var modifications = []keyModification{}
+
+	historyIterator, err := ctx.GetStub().GetHistoryForKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history for key: %v", err)
+	}
+	defer historyIterator.Close()
+
+	for historyIterator.HasNext() {
+		modification, err := historyIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving next history entry: %v", err)
+		}
+
+		writer, err := getTransactionWriter(ctx, modification.TxId)
+		if err != nil {
+			return nil, err
+		}
+
+		var timestamp int64
+		if modification.GetTimestamp() != nil {
+			timestamp = modification.GetTimestamp().GetSeconds()
+		}
+
+		modifications = append(modifications, keyModification{
+			txID:      modification.TxId,
+			timestamp: timestamp,
+			isDelete:  modification.IsDelete,
+			writer:    writer,
+			value:     modification.Value,
+		})
+	}
+
+	return modifications, nil
+}
+
+func getHealthRecordHistory(ctx contractapi.TransactionContextInterface, patientID, recordID string) ([]HealthRecordVersion, error) {
+
+	var versions = []HealthRecordVersion{}
+
+	compositeKey, err := createPatientWalletCompositeKey(ctx, patientID, recordID)
+	if err != nil {
+		return nil, err
+	}
+
+	modifications, err := getKeyHistory(ctx, compositeKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// O histórico vem do mais recente para o mais antigo: depois da versão apagada,
+	// as restantes têm o conteúdo que o paciente pediu para apagar.
+	erased := false
+	for _, modification := range modifications {
+		version := HealthRecordVersion{
+			TxID:        modification.txID,
+			Timestamp:   modification.timestamp,
+			IsDelete:    modification.isDelete,
+			WriterID:    modification.writer.ClientID,
+			WriterMSPID: modification.writer.MSPID,
+		}
+
+		if !modification.isDelete {
+			version.HealthRecord, err = unmarshalHealthRecord(ctx, modification.value)
+			if err != nil {
+				return nil, err
+			}
+
+			if erased {
+				version.HealthRecord = HealthRecord{}
+			}
+			erased = erased || version.HealthRecord.Erased
+		}
+
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+func getRequestHistory(ctx contractapi.TransactionContextInterface, patientID, requestID string) ([]RequestVersion, error) {
+
+	var versions = []RequestVersion{}
+
+	requestKey, err := getRequestKeyByRequestID(ctx, patientID, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	if requestKey == "" {
+		return versions, nil
+	}
+
+	modifications, err := getKeyHistory(ctx, requestKey)
+	if err != nil {
+		return nil, err
+	}
+
+	erased := false
+	for _, modification := range modifications {
+		version := RequestVersion{
+			TxID:        modification.txID,
+			Timestamp:   modification.timestamp,
+			IsDelete:    modification.isDelete,
+			WriterID:    modification.writer.ClientID,
+			WriterMSPID: modification.writer.MSPID,
+		}
+
+		if !modification.isDelete {
+			if err := json.Unmarshal(modification.value, &version.Request); err != nil {
+				return nil, fmt.Errorf("error unmarshalling request: %v", err)
+			}
+
+			if erased {
+				version.Request = Request{}
+			}
+			erased = erased || version.Request.Erased
+		}
+
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+// getAccessHistory devolve as versões do acesso do paciente ao abrigo do pedido.
+func getAccessHistory(ctx contractapi.TransactionContextInterface, patientID, requestID string) ([]AccessVersion, error) {
+
+	var versions = []AccessVersion{}
+
+	compositeKey, err := getAccessKey(ctx, patientID, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	modifications, err := getKeyHistory(ctx, compositeKey)
+	if err != nil {
+		return nil, err
+	}
+
+	erased := false
+	for _, modification := range modifications {
+		version := AccessVersion{
+			TxID:        modification.txID,
+			Timestamp:   modification.timestamp,
+			IsDelete:    modification.isDelete,
+			WriterID:    modification.writer.ClientID,
+			WriterMSPID: modification.writer.MSPID,
+		}
+
+		if !modification.isDelete {
+			if err := json.Unmarshal(modification.value, &version.Access); err != nil {
+				return nil, fmt.Errorf("error unmarshalling access: %v", err)
+			}
+
+			// A chave antiga é só do pedido e pode ter versões de acessos de outros pacientes.
+			if version.Access.PatientID != patientID {
+				continue
+			}
+
+			if erased {
+				version.Access = Access{}
+			}
+			erased = erased || version.Access.Erased
+		}
+
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+// getValueAsOf devolve o valor que a chave tinha no instante asOf, ou nil se ainda
+// não existia (ou estava apagada) nessa altura. Tal como no histórico, o conteúdo que o
+// paciente pediu para apagar não é devolvido: se a chave foi apagada depois, devolve a
+// versão apagada, que só tem os identificadores e as datas.
+func getValueAsOf(ctx contractapi.TransactionContextInterface, key string, asOf int64) ([]byte, error) {
+
+	historyIterator, err := ctx.GetStub().GetHistoryForKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history for key: %v", err)
+	}
+	defer historyIterator.Close()
+
+	var value []byte
+	var valueTimestamp int64
+	var erasedValue []byte
+	found := false
+
+	// Ficamos com a versão mais recente até asOf. O histórico vem do mais recente para
+	// o mais antigo, por isso em versões no mesmo segundo fica a primeira lida.
+	for historyIterator.HasNext() {
+		modification, err := historyIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving next history entry: %v", err)
+		}
+
+		if erasedValue == nil && !modification.IsDelete {
+			var version struct {
+				Erased bool `json:"erased"`
+			}
+			if err := json.Unmarshal(modification.Value, &version); err != nil {
+				return nil, fmt.Errorf("error unmarshalling history entry: %v", err)
+			}
+			if version.Erased {
+				erasedValue = modification.Value
+			}
+		}
+
+		if modification.GetTimestamp() == nil {
+			continue
+		}
+
+		timestamp := modification.GetTimestamp().GetSeconds()
+		if timestamp > asOf || (found && timestamp <= valueTimestamp) {
+			continue
+		}
+
+		found = true
+		valueTimestamp = timestamp
+		value = modification.Value
+		if modification.IsDelete {
+			value = nil
+		}
+	}
+
+	if value != nil && erasedValue != nil {
+		return erasedValue, nil
+	}
+
+	return value, nil
+}
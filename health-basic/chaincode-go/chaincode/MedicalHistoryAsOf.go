@@ -0,0 +1,131 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Estado dos dados do paciente num instante passado, reconstruído a partir do histórico das chaves.
+// Se for indicado um profissional, diz também se tinha acesso e que registos podia ver.
+type MedicalHistoryAsOf struct {
+	AsOf                            int64          `json:"asOf"`
+	HealthRecords                   []HealthRecord `json:"healthRecords"`
+	Accesses                        []Access       `json:"accesses"`
+	Requests                        []Request      `json:"requests"`
+	HealthcareProfessionalHadAccess bool           `json:"healthcareProfessionalHadAccess"`
+	VisibleRecordIDs                []string       `json:"visibleRecordIDs"`
+	WithheldHealthRecords           int            `json:"withheldHealthRecords"`
+}
+
+func getMedicalHistoryAsOf(ctx contractapi.TransactionContextInterface, patientID, healthcareProfessionalID string, asOf int64) (*MedicalHistoryAsOf, error) {
+
+	history := MedicalHistoryAsOf{
+		AsOf:             asOf,
+		HealthRecords:    []HealthRecord{},
+		Accesses:         []Access{},
+		Requests:         []Request{},
+		VisibleRecordIDs: []string{},
+	}
+
+	// As chaves nunca são apagadas (o apagamento deixa tombstones), por isso as chaves
+	// atuais são todas as que o paciente já teve.
+	err := scanState(ctx, "HealthRecords", []string{"patientID", patientID}, func(key string, value []byte) error {
+		valueAsOf, err := getValueAsOf(ctx, key, asOf)
+		if err != nil || valueAsOf == nil {
+			return err
+		}
+
+		healthRecord, err := unmarshalHealthRecord(ctx, valueAsOf)
+		if err != nil {
+			return err
+		}
+		history.HealthRecords = append(history.HealthRecords, healthRecord)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanState(ctx, accessesByPatientIndex, []string{"patientID", patientID}, func(key string, value []byte) error {
+		valueAsOf, err := getValueAsOf(ctx, key, asOf)
+		if err != nil || valueAsOf == nil {
+			return err
+		}
+
+		var access Access
+		if err := json.Unmarshal(valueAsOf, &access); err != nil {
+			return fmt.Errorf("error unmarshalling access: %v", err)
+		}
+		history.Accesses = append(history.Accesses, access)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanState(ctx, "Requests", []string{"patientID", patientID}, func(key string, value []byte) error {
+		valueAsOf, err := getValueAsOf(ctx, key, asOf)
+		if err != nil || valueAsOf == nil {
+			return err
+		}
+
+		var request Request
+		if err := json.Unmarshal(valueAsOf, &request); err != nil {
+			return fmt.Errorf("error unmarshalling request: %v", err)
+		}
+		history.Requests = append(history.Requests, request)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(history.HealthRecords, func(i, j int) bool {
+		return history.HealthRecords[i].EventDate > history.HealthRecords[j].EventDate
+	})
+
+	if healthcareProfessionalID != "" {
+		allowedLabels := map[string]bool{}
+
+		for _, access := range history.Accesses {
+			if access.HealthcareProfessionalID != healthcareProfessionalID || access.ExpirationDate <= asOf {
+				continue
+			}
+
+			history.HealthcareProfessionalHadAccess = true
+			for _, label := range access.SensitivityLabels {
+				allowedLabels[label] = true
+			}
+		}
+
+		if history.HealthcareProfessionalHadAccess {
+			history.VisibleRecordIDs, history.WithheldHealthRecords = visibleRecordIDs(history.HealthRecords, allowedLabels)
+		}
+	}
+
+	return &history, nil
+}
+
+// visibleRecordIDs devolve os registos que o profissional podia ler e quantos lhe eram
+// retidos. Os tombstones de registos apagados não têm conteúdo para ver, por isso não contam
+// como visíveis nem como retidos.
+func visibleRecordIDs(healthRecords []HealthRecord, allowedLabels map[string]bool) ([]string, int) {
+
+	notErased := []HealthRecord{}
+	for _, healthRecord := range healthRecords {
+		if !healthRecord.Erased {
+			notErased = append(notErased, healthRecord)
+		}
+	}
+
+	recordIDs := []string{}
+	visible, withheld := withholdSensitiveHealthRecords(hideRetractedHealthRecords(notErased), allowedLabels)
+	for _, healthRecord := range visible {
+		recordIDs = append(recordIDs, healthRecord.RecordID)
+	}
+
+	return recordIDs, withheld
+}
@@ -0,0 +1,26 @@
+package chaincode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVisibleRecordIDsSkipsErasedRecords(t *testing.T) {
+	healthRecords := []HealthRecord{
+		{RecordID: "r1", PatientID: "p1"},
+		{RecordID: "r2", PatientID: "p1", SensitivityLabel: "HIV"},
+		{RecordID: "r3", PatientID: "p1", SensitivityLabel: "HIV", Erased: true},
+		{RecordID: "r4", PatientID: "p1", SensitivityLabel: "PSY", Erased: true},
+		{RecordID: "r5", PatientID: "p1", SensitivityLabel: "PSY"},
+		{RecordID: "r6", PatientID: "p1", Erased: true},
+	}
+
+	recordIDs, withheld := visibleRecordIDs(healthRecords, map[string]bool{"HIV": true})
+
+	if !reflect.DeepEqual(recordIDs, []string{"r1", "r2"}) {
+		t.Errorf("visible records: got %v, want [r1 r2]", recordIDs)
+	}
+	if withheld != 1 {
+		t.Errorf("withheld records: got %d, want 1", withheld)
+	}
+}
@@ -0,0 +1,138 @@
+package chaincode
+
+import (
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Limites usados quando o intervalo de datas não é indicado.
+const (
+	minEventDate int64 = -1 << 53
+	maxEventDate int64 = 1 << 53
+)
+
+// Filtros e ordenação do histórico médico, iguais para o paciente e para o profissional.
+// Os campos vazios não filtram. As datas só filtram com HasEventDateFrom/HasEventDateTo,
+// para o 0 (1970-01-01) também poder ser um limite. O IncludeRetracted só conta para o
+// profissional, o paciente vê sempre os registos retirados.
+type MedicalHistoryQueryOptions struct {
+	EventDateFrom       int64  `json:"eventDateFrom"`
+	HasEventDateFrom    bool   `json:"hasEventDateFrom"`
+	EventDateTo         int64  `json:"eventDateTo"`
+	HasEventDateTo      bool   `json:"hasEventDateTo"`
+	SpecialityCode      string `json:"specialityCode"`
+	RecordTypeCode      string `json:"recordTypeCode"`
+	Organization        string `json:"organization"`
+	AuthorID            string `json:"authorID"`
+	DescriptionContains string `json:"descriptionContains"`
+	SortBy              string `json:"sortBy"`    // "eventDate" (por omissão) ou "createdDate"
+	SortOrder           string `json:"sortOrder"` // "desc" (por omissão) ou "asc"
+	IncludeRetracted    bool   `json:"includeRetracted"`
+}
+
+// medicalHistoryIndex valida as options e devolve o índice com a ordem pedida.
+func medicalHistoryIndex(options MedicalHistoryQueryOptions) (string, error) {
+
+	sortOrder := options.SortOrder
+	if sortOrder == "" {
+		sortOrder = "desc"
+	}
+	if sortOrder != "asc" && sortOrder != "desc" {
+		return "", invalidArgumentError("invalid sort order: %s", options.SortOrder)
+	}
+
+	eventDateFrom, eventDateTo := medicalHistoryEventDateRange(options)
+	if eventDateFrom > eventDateTo {
+		return "", invalidArgumentError("invalid event date range")
+	}
+
+	switch options.SortBy {
+	case "", "eventDate":
+		if sortOrder == "asc" {
+			return healthRecordsByEventDateIndex, nil
+		}
+		return healthRecordsByEventDateDescIndex, nil
+
+	case "createdDate":
+		if sortOrder == "asc" {
+			return healthRecordsByCreatedDateIndex, nil
+		}
+		return healthRecordsByCreatedDateDescIndex, nil
+
+	default:
+		return "", invalidArgumentError("invalid sort field: %s", options.SortBy)
+	}
+}
+
+func medicalHistoryEventDateRange(options MedicalHistoryQueryOptions) (int64, int64) {
+
+	eventDateFrom := minEventDate
+	if options.HasEventDateFrom {
+		eventDateFrom = options.EventDateFrom
+	}
+
+	eventDateTo := maxEventDate
+	if options.HasEventDateTo {
+		eventDateTo = options.EventDateTo
+	}
+
+	return eventDateFrom, eventDateTo
+}
+
+// medicalHistoryKeyRange limita a leitura dos índices por data do evento ao intervalo
+// pedido. Os índices por data de criação são lidos todos e filtrados.
+func medicalHistoryKeyRange(ctx contractapi.TransactionContextInterface, index, patientID string,
+	options MedicalHistoryQueryOptions) (*stateKeyRange, error) {
+
+	if !options.HasEventDateFrom && !options.HasEventDateTo {
+		return nil, nil
+	}
+
+	eventDateFrom, eventDateTo := medicalHistoryEventDateRange(options)
+	attributes := []string{"patientID", patientID}
+
+	switch index {
+	case healthRecordsByEventDateIndex:
+		return dateKeyRange(ctx, index, attributes, sortableDate(eventDateFrom), sortableDate(eventDateTo))
+	case healthRecordsByEventDateDescIndex:
+		return dateKeyRange(ctx, index, attributes, reverseSortableDate(eventDateTo), reverseSortableDate(eventDateFrom))
+	default:
+		return nil, nil
+	}
+}
+
+// hasMedicalHistoryFilters diz se as options filtram os registos (a ordem não conta).
+func hasMedicalHistoryFilters(options MedicalHistoryQueryOptions) bool {
+	return options.HasEventDateFrom || options.HasEventDateTo || options.SpecialityCode != "" ||
+		options.RecordTypeCode != "" || options.Organization != "" || options.AuthorID != "" ||
+		options.DescriptionContains != ""
+}
+
+// matchesMedicalHistoryOptions aplica os filtros das options a um registo.
+func matchesMedicalHistoryOptions(healthRecord HealthRecord, options MedicalHistoryQueryOptions) bool {
+
+	eventDateFrom, eventDateTo := medicalHistoryEventDateRange(options)
+	if healthRecord.EventDate < eventDateFrom || healthRecord.EventDate > eventDateTo {
+		return false
+	}
+
+	if options.SpecialityCode != "" && healthRecord.SpecialityCode != options.SpecialityCode {
+		return false
+	}
+	if options.RecordTypeCode != "" && healthRecord.RecordTypeCode != options.RecordTypeCode {
+		return false
+	}
+	if options.Organization != "" && healthRecord.Organization != options.Organization {
+		return false
+	}
+	if options.AuthorID != "" && healthRecord.HealthCareProfessionalID != options.AuthorID {
+		return false
+	}
+	if options.DescriptionContains != "" &&
+		!strings.Contains(strings.ToLower(healthRecord.Description), strings.ToLower(options.DescriptionContains)) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,68 @@
+package chaincode
+
+import "time"
+
+// Canais, tipos de notificação e línguas que os gateways sabem enviar.
+var (
+	notificationChannels = map[string]bool{
+		"email": true,
+		"sms":   true,
+		"push":  true,
+	}
+	notificationTypes = map[string]bool{
+		"RequestCreated": true,
+		"AccessExpiring": true,
+	}
+	notificationLanguages = map[string]bool{
+		"pt": true,
+		"en": true,
+	}
+)
+
+// Preferências de notificação do paciente, iguais para os gateways de todas as organizações.
+// EventTypes vazio aceita todos os tipos. As horas de silêncio são "HH:MM" (ambas ou nenhuma).
+// Os contactos (email, telefone) não ficam na ledger.
+type NotificationPreferences struct {
+	ResourceType    int      `json:"resourceType"` // 10
+	PatientID       string   `json:"patientID"`
+	Channels        []string `json:"channels"`
+	QuietHoursStart string   `json:"quietHoursStart"`
+	QuietHoursEnd   string   `json:"quietHoursEnd"`
+	EventTypes      []string `json:"eventTypes"`
+	Language        string   `json:"language"`
+	UpdatedDate     int64    `json:"updatedDate"`
+}
+
+func validateNotificationPreferences(preferences NotificationPreferences) error {
+
+	for _, channel := range preferences.Channels {
+		if !notificationChannels[channel] {
+			return invalidArgumentError("invalid notification channel: %s", channel)
+		}
+	}
+
+	for _, eventType := range preferences.EventTypes {
+		if !notificationTypes[eventType] {
+			return invalidArgumentError("invalid notification type: %s", eventType)
+		}
+	}
+
+	if !notificationLanguages[preferences.Language] {
+		return invalidArgumentError("invalid language: %s", preferences.Language)
+	}
+
+	if (preferences.QuietHoursStart == "") != (preferences.QuietHoursEnd == "") {
+		return invalidArgumentError("quiet hours need both a start and an end")
+	}
+
+	for _, hour := range []string{preferences.QuietHoursStart, preferences.QuietHoursEnd} {
+		if hour == "" {
+			continue
+		}
+		if _, err := time.Parse("15:04", hour); err != nil {
+			return invalidArgumentError("invalid quiet hour: %s", hour)
+		}
+	}
+
+	return nil
+}
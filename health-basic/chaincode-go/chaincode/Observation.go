@@ -0,0 +1,114 @@
+package chaincode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Observação numérica estruturada (análises, sinais vitais), o código tem de
+// estar registado no registo de terminologia com kind "observation".
+type Observation struct {
+	Code          string  `json:"code"`
+	Display       string  `json:"display"`
+	Value         float64 `json:"value"`
+	Unit          string  `json:"unit"`
+	ReferenceLow  float64 `json:"referenceLow"`
+	ReferenceHigh float64 `json:"referenceHigh"`
+	AbnormalFlag  string  `json:"abnormalFlag"`
+}
+
+// Ponto da série temporal de uma observação.
+type ObservationPoint struct {
+	RecordID      string  `json:"recordID"`
+	EventDate     int64   `json:"eventDate"`
+	Value         float64 `json:"value"`
+	Unit          string  `json:"unit"`
+	ReferenceLow  float64 `json:"referenceLow"`
+	ReferenceHigh float64 `json:"referenceHigh"`
+	AbnormalFlag  string  `json:"abnormalFlag"`
+}
+
+type unitConversion struct {
+	unit   string
+	factor float64
+}
+
+// Unidades aceites (em minúsculas) e a unidade UCUM para a qual são normalizadas. Só há
+// conversões que não dependem do analito; a unidade de cada código de observação é uma
+// destas unidades UCUM e os valores noutra unidade são rejeitados.
+var observationUnits = map[string]unitConversion{
+	"mm[hg]":   {"mm[Hg]", 1},
+	"mmhg":     {"mm[Hg]", 1},
+	"%":        {"%", 1},
+	"mmol/mol": {"mmol/mol", 1},
+	"mg/dl":    {"mg/dL", 1},
+	"mmol/l":   {"mmol/L", 1},
+	"g/dl":     {"g/dL", 1},
+	"g/l":      {"g/dL", 0.1},
+	"kg":       {"kg", 1},
+	"g":        {"kg", 0.001},
+	"lb":       {"kg", 0.45359237},
+	"cm":       {"cm", 1},
+	"m":        {"cm", 100},
+	"/min":     {"/min", 1},
+	"bpm":      {"/min", 1},
+	"cel":      {"Cel", 1},
+	"°c":       {"Cel", 1},
+	"kg/m2":    {"kg/m2", 1},
+}
+
+// L/H fora do intervalo de referência, LL/HH valores críticos, A anormal sem direção, N normal.
+var abnormalFlags = map[string]bool{
+	"":   true,
+	"N":  true,
+	"L":  true,
+	"H":  true,
+	"LL": true,
+	"HH": true,
+	"A":  true,
+}
+
+// normalizeObservation valida a unidade e o intervalo de referência, converte o valor
+// para a unidade do código e calcula a flag de anormal quando não vem preenchida.
+func normalizeObservation(observation Observation, codeUnit string) (Observation, error) {
+
+	if observation.Code == "" {
+		return observation, fmt.Errorf("observation code cannot be empty")
+	}
+
+	conversion, ok := observationUnits[strings.ToLower(strings.TrimSpace(observation.Unit))]
+	if !ok {
+		return observation, fmt.Errorf("invalid unit for observation %s: %s", observation.Code, observation.Unit)
+	}
+
+	if conversion.unit != codeUnit {
+		return observation, fmt.Errorf("unit %s cannot be converted to %s for observation %s", observation.Unit, codeUnit, observation.Code)
+	}
+
+	if !abnormalFlags[observation.AbnormalFlag] {
+		return observation, fmt.Errorf("invalid abnormal flag for observation %s: %s", observation.Code, observation.AbnormalFlag)
+	}
+
+	if observation.ReferenceLow > observation.ReferenceHigh {
+		return observation, fmt.Errorf("invalid reference range for observation %s", observation.Code)
+	}
+
+	observation.Unit = conversion.unit
+	observation.Value *= conversion.factor
+	observation.ReferenceLow *= conversion.factor
+	observation.ReferenceHigh *= conversion.factor
+
+	hasReferenceRange := observation.ReferenceLow != 0 || observation.ReferenceHigh != 0
+	if observation.AbnormalFlag == "" && hasReferenceRange {
+		switch {
+		case observation.Value < observation.ReferenceLow:
+			observation.AbnormalFlag = "L"
+		case observation.Value > observation.ReferenceHigh:
+			observation.AbnormalFlag = "H"
+		default:
+			observation.AbnormalFlag = "N"
+		}
+	}
+
+	return observation, nil
+}
@@ -0,0 +1,143 @@
+package chaincode
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeObservation(t *testing.T) {
+	tests := []struct {
+		name        string
+		observation Observation
+		codeUnit    string
+		expected    Observation
+		err         string
+	}{
+		{
+			name:        "already in the code unit",
+			observation: Observation{Code: "8480-6", Value: 120, Unit: "mm[Hg]", ReferenceLow: 90, ReferenceHigh: 140},
+			codeUnit:    "mm[Hg]",
+			expected:    Observation{Code: "8480-6", Value: 120, Unit: "mm[Hg]", ReferenceLow: 90, ReferenceHigh: 140, AbnormalFlag: "N"},
+		},
+		{
+			name:        "unit case and spaces",
+			observation: Observation{Code: "8480-6", Value: 120, Unit: " MMHG "},
+			codeUnit:    "mm[Hg]",
+			expected:    Observation{Code: "8480-6", Value: 120, Unit: "mm[Hg]"},
+		},
+		{
+			name:        "converts value and reference range",
+			observation: Observation{Code: "718-7", Value: 135, Unit: "g/L", ReferenceLow: 120, ReferenceHigh: 160},
+			codeUnit:    "g/dL",
+			expected:    Observation{Code: "718-7", Value: 13.5, Unit: "g/dL", ReferenceLow: 12, ReferenceHigh: 16, AbnormalFlag: "N"},
+		},
+		{
+			name:        "pounds to kilograms",
+			observation: Observation{Code: "29463-7", Value: 100, Unit: "lb"},
+			codeUnit:    "kg",
+			expected:    Observation{Code: "29463-7", Value: 45.359237, Unit: "kg"},
+		},
+		{
+			name:        "low flag computed",
+			observation: Observation{Code: "8480-6", Value: 80, Unit: "mm[Hg]", ReferenceLow: 90, ReferenceHigh: 140},
+			codeUnit:    "mm[Hg]",
+			expected:    Observation{Code: "8480-6", Value: 80, Unit: "mm[Hg]", ReferenceLow: 90, ReferenceHigh: 140, AbnormalFlag: "L"},
+		},
+		{
+			name:        "high flag computed after conversion",
+			observation: Observation{Code: "718-7", Value: 170, Unit: "g/L", ReferenceLow: 120, ReferenceHigh: 160},
+			codeUnit:    "g/dL",
+			expected:    Observation{Code: "718-7", Value: 17, Unit: "g/dL", ReferenceLow: 12, ReferenceHigh: 16, AbnormalFlag: "H"},
+		},
+		{
+			name:        "flag sent by the professional is kept",
+			observation: Observation{Code: "8480-6", Value: 120, Unit: "mm[Hg]", ReferenceLow: 90, ReferenceHigh: 140, AbnormalFlag: "A"},
+			codeUnit:    "mm[Hg]",
+			expected:    Observation{Code: "8480-6", Value: 120, Unit: "mm[Hg]", ReferenceLow: 90, ReferenceHigh: 140, AbnormalFlag: "A"},
+		},
+		{
+			name:        "empty code",
+			observation: Observation{Value: 120, Unit: "mm[Hg]"},
+			codeUnit:    "mm[Hg]",
+			err:         "code cannot be empty",
+		},
+		{
+			name:        "unknown unit",
+			observation: Observation{Code: "8480-6", Value: 120, Unit: "bar"},
+			codeUnit:    "mm[Hg]",
+			err:         "invalid unit",
+		},
+		{
+			name:        "unit of another dimension",
+			observation: Observation{Code: "8302-2", Value: 70, Unit: "kg"},
+			codeUnit:    "cm",
+			err:         "cannot be converted",
+		},
+		{
+			name:        "invalid abnormal flag",
+			observation: Observation{Code: "8480-6", Value: 120, Unit: "mm[Hg]", AbnormalFlag: "X"},
+			codeUnit:    "mm[Hg]",
+			err:         "invalid abnormal flag",
+		},
+		{
+			name:        "inverted reference range",
+			observation: Observation{Code: "8480-6", Value: 120, Unit: "mm[Hg]", ReferenceLow: 140, ReferenceHigh: 90},
+			codeUnit:    "mm[Hg]",
+			err:         "invalid reference range",
+		},
+	}
+
+	closeTo := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			normalized, err := normalizeObservation(test.observation, test.codeUnit)
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if normalized.Unit != test.expected.Unit || normalized.AbnormalFlag != test.expected.AbnormalFlag ||
+				!closeTo(normalized.Value, test.expected.Value) ||
+				!closeTo(normalized.ReferenceLow, test.expected.ReferenceLow) ||
+				!closeTo(normalized.ReferenceHigh, test.expected.ReferenceHigh) {
+				t.Errorf("got %+v, want %+v", normalized, test.expected)
+			}
+		})
+	}
+}
+
+// O profissional assina as observações como ficam guardadas, já normalizadas, por isso a
+// assinatura feita sobre o registo normalizado valida-o mesmo que tenha vindo noutra unidade.
+func TestVerifyHealthRecordSignatureAfterNormalization(t *testing.T) {
+	ecdsaSigner := newSigner(t, "ecdsa")
+
+	healthRecord := signedTestRecord()
+	healthRecord.Observations = []Observation{{Code: "718-7", Value: 135, Unit: "g/L", ReferenceLow: 120, ReferenceHigh: 160}}
+
+	normalized, err := normalizeObservation(healthRecord.Observations[0], "g/dL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthRecord.Observations = []Observation{normalized}
+
+	signature := ecdsaSigner.signHealthRecord(t, healthRecord)
+
+	// Normalizar outra vez não muda nada, por isso o registo guardado continua a validar.
+	renormalized, err := normalizeObservation(normalized, "g/dL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthRecord.Observations = []Observation{renormalized}
+
+	if err := verifyHealthRecordSignature(healthRecord, signature, ecdsaSigner.certificatePEM); err != nil {
+		t.Errorf("expected a valid signature after normalization, got %v", err)
+	}
+}
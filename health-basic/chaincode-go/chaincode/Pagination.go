@@ -0,0 +1,54 @@
+package chaincode
+
+// Tamanho de página usado quando o pedido não indica um (ou indica um inválido).
+const (
+	defaultPageSize int32 = 50
+	maxPageSize     int32 = 500
+)
+
+type HealthRecordsPage struct {
+	HealthRecords       []HealthRecord `json:"healthRecords"`
+	FetchedRecordsCount int32          `json:"fetchedRecordsCount"`
+	Bookmark            string         `json:"bookmark"`
+}
+
+type AccessesPage struct {
+	Accesses            []Access `json:"accesses"`
+	FetchedRecordsCount int32    `json:"fetchedRecordsCount"`
+	Bookmark            string   `json:"bookmark"`
+}
+
+type RequestsPage struct {
+	Requests            []Request `json:"requests"`
+	FetchedRecordsCount int32     `json:"fetchedRecordsCount"`
+	Bookmark            string    `json:"bookmark"`
+}
+
+func normalizePageSize(pageSize int32) int32 {
+	if pageSize <= 0 {
+		return defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		return maxPageSize
+	}
+	return pageSize
+}
+
+type AccessLogPage struct {
+	AccessLogEntries    []AccessLogEntry `json:"accessLogEntries"`
+	FetchedRecordsCount int32            `json:"fetchedRecordsCount"`
+	Bookmark            string           `json:"bookmark"`
+}
+
+// AuditTrailPage junta os acontecimentos de uma página de objetos percorridos pelo GetAuditTrail.
+type AuditTrailPage struct {
+	Events              []AuditEvent `json:"events"`
+	FetchedRecordsCount int32        `json:"fetchedRecordsCount"`
+	Bookmark            string       `json:"bookmark"`
+}
+
+// RebuildIndexesPage diz quantos objetos o RebuildIndexes reindexou e onde continuar.
+type RebuildIndexesPage struct {
+	FetchedRecordsCount int32  `json:"fetchedRecordsCount"`
+	Bookmark            string `json:"bookmark"`
+}
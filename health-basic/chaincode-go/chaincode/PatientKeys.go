@@ -0,0 +1,215 @@
+package chaincode
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Cifra por registo, para o apagamento (crypto-shredding). O conteúdo clínico de cada registo
+// é guardado cifrado com AES-256-GCM, com uma chave por registo derivada da chave do paciente.
+// A chave do paciente está numa coleção de dados privados (chaincode-go/collections_config.json,
+// a indicar no deploy com --collections-config) que, ao contrário do estado público e do
+// histórico das chaves, pode ser purgada: depois de o ErasePatientData a purgar, nem o estado
+// atual nem as versões anteriores dos registos podem ser decifrados por ninguém.
+//
+// O chaincode não pode gerar números aleatórios (cada peer geraria outros), por isso a chave
+// do paciente vem do cliente, no campo transiente patientKeyTransientField, da primeira
+// transação que guarda um registo do paciente. Depois disso o campo é ignorado.
+const (
+	patientKeysCollection    = "PatientKeys"
+	patientKeyTransientField = "patientKey"
+	patientKeySize           = 32
+)
+
+// O conteúdo clínico cifrado de um registo. Os identificadores, datas, códigos e etiquetas
+// ficam em claro, porque os índices e as verificações de acesso precisam deles.
+type healthRecordContent struct {
+	Description     string                `json:"description"`
+	Observations    []Observation         `json:"observations"`
+	Disputes        []HealthRecordDispute `json:"disputes"`
+	RetractedReason string                `json:"retractedReason"`
+}
+
+// getPatientKey devolve a chave do paciente, ou nil se ainda não existir ou já tiver sido purgada.
+func getPatientKey(ctx contractapi.TransactionContextInterface, patientID string) ([]byte, error) {
+
+	compositeKey, err := createPatientKeyCompositeKey(ctx, patientID)
+	if err != nil {
+		return nil, err
+	}
+
+	patientKey, err := ctx.GetStub().GetPrivateData(patientKeysCollection, compositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patient key: %v", err)
+	}
+
+	return patientKey, nil
+}
+
+// getOrCreatePatientKey devolve a chave do paciente e, se ainda não existir, guarda a que vem
+// no campo transiente.
+func getOrCreatePatientKey(ctx contractapi.TransactionContextInterface, patientID string) ([]byte, error) {
+
+	patientKey, err := getPatientKey(ctx, patientID)
+	if err != nil || patientKey != nil {
+		return patientKey, err
+	}
+
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transient data: %v", err)
+	}
+
+	patientKey = transient[patientKeyTransientField]
+	if len(patientKey) != patientKeySize {
+		return nil, invalidArgumentError("the patient has no encryption key yet: send %d random bytes in the transient field %q",
+			patientKeySize, patientKeyTransientField)
+	}
+
+	compositeKey, err := createPatientKeyCompositeKey(ctx, patientID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.GetStub().PutPrivateData(patientKeysCollection, compositeKey, patientKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store patient key: %v", err)
+	}
+
+	return patientKey, nil
+}
+
+// purgePatientKey purga a chave do paciente da coleção, incluindo as versões anteriores
+// guardadas pelos peers. Devolve false se o paciente não tinha chave.
+func purgePatientKey(ctx contractapi.TransactionContextInterface, patientID string) (bool, error) {
+
+	patientKey, err := getPatientKey(ctx, patientID)
+	if err != nil || patientKey == nil {
+		return false, err
+	}
+
+	compositeKey, err := createPatientKeyCompositeKey(ctx, patientID)
+	if err != nil {
+		return false, err
+	}
+
+	err = ctx.GetStub().PurgePrivateData(patientKeysCollection, compositeKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to purge patient key: %v", err)
+	}
+
+	return true, nil
+}
+
+// healthRecordCipher devolve o AES-GCM com a chave do registo, derivada da chave do paciente
+// com HMAC-SHA256 sobre o ID do registo.
+func healthRecordCipher(patientKey []byte, recordID string) (cipher.AEAD, error) {
+
+	mac := hmac.New(sha256.New, patientKey)
+	mac.Write([]byte("HealthRecords\x00" + recordID))
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// healthRecordAdditionalData liga o texto cifrado ao registo, para não poder ser copiado para outro.
+func healthRecordAdditionalData(healthRecord HealthRecord) []byte {
+	return []byte(healthRecord.PatientID + "\x00" + healthRecord.RecordID)
+}
+
+// sealHealthRecord devolve o registo como fica guardado: o conteúdo clínico passa para
+// EncryptedContent. O nonce vem do ID da transação, para todos os peers cifrarem igual;
+// cada transação guarda cada registo no máximo uma vez.
+func sealHealthRecord(ctx contractapi.TransactionContextInterface, healthRecord HealthRecord) (HealthRecord, error) {
+
+	patientKey, err := getOrCreatePatientKey(ctx, healthRecord.PatientID)
+	if err != nil {
+		return HealthRecord{}, err
+	}
+
+	aead, err := healthRecordCipher(patientKey, healthRecord.RecordID)
+	if err != nil {
+		return HealthRecord{}, err
+	}
+
+	contentJSON, err := json.Marshal(healthRecordContent{
+		Description:     healthRecord.Description,
+		Observations:    healthRecord.Observations,
+		Disputes:        healthRecord.Disputes,
+		RetractedReason: healthRecord.RetractedReason,
+	})
+	if err != nil {
+		return HealthRecord{}, fmt.Errorf("failed to serialize health record content: %v", err)
+	}
+
+	nonce := sha256.Sum256([]byte(ctx.GetStub().GetTxID() + "\x00" + healthRecord.RecordID))
+	sealed := aead.Seal(nil, nonce[:aead.NonceSize()], contentJSON, healthRecordAdditionalData(healthRecord))
+
+	healthRecord.Description = ""
+	healthRecord.Observations = []Observation{}
+	healthRecord.Disputes = []HealthRecordDispute{}
+	healthRecord.RetractedReason = ""
+	healthRecord.EncryptedContent = base64.StdEncoding.EncodeToString(append(nonce[:aead.NonceSize()], sealed...))
+
+	return healthRecord, nil
+}
+
+// unmarshalHealthRecord lê um registo guardado e decifra o conteúdo clínico. Sem a chave com
+// que foi cifrado (purgada pelo apagamento) o registo fica sem conteúdo, com EncryptedContent.
+// Os registos guardados antes da cifra não têm EncryptedContent e já estão em claro.
+func unmarshalHealthRecord(ctx contractapi.TransactionContextInterface, value []byte) (HealthRecord, error) {
+
+	var healthRecord HealthRecord
+	if err := json.Unmarshal(value, &healthRecord); err != nil {
+		return HealthRecord{}, fmt.Errorf("erro ao transformar os dados na wallet: %v", err)
+	}
+
+	if healthRecord.EncryptedContent == "" {
+		return healthRecord, nil
+	}
+
+	patientKey, err := getPatientKey(ctx, healthRecord.PatientID)
+	if err != nil || patientKey == nil {
+		return healthRecord, err
+	}
+
+	aead, err := healthRecordCipher(patientKey, healthRecord.RecordID)
+	if err != nil {
+		return HealthRecord{}, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(healthRecord.EncryptedContent)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return HealthRecord{}, fmt.Errorf("invalid encrypted content in health record %s", healthRecord.RecordID)
+	}
+
+	// Uma versão cifrada com uma chave já purgada, de antes de o paciente ter uma chave nova.
+	contentJSON, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], healthRecordAdditionalData(healthRecord))
+	if err != nil {
+		return healthRecord, nil
+	}
+
+	var content healthRecordContent
+	if err := json.Unmarshal(contentJSON, &content); err != nil {
+		return HealthRecord{}, fmt.Errorf("error unmarshalling health record content: %v", err)
+	}
+
+	healthRecord.Description = content.Description
+	healthRecord.Observations = content.Observations
+	healthRecord.Disputes = content.Disputes
+	healthRecord.RetractedReason = content.RetractedReason
+	healthRecord.EncryptedContent = ""
+
+	return healthRecord, nil
+}
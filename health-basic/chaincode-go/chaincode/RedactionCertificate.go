@@ -0,0 +1,26 @@
+package chaincode
+
+// RedactionCertificate é o certificado de apagamento que o paciente guarda.
+// KeyDestroyed diz que a chave do paciente foi purgada: o conteúdo clínico dos registos
+// deixa de poder ser decifrado, em todas as versões. Fica false se o paciente não tinha
+// chave, por não ter nenhum registo guardado desde que os registos são cifrados; os
+// registos guardados antes disso continuam em claro no histórico.
+// HistoryRetained é sempre true: os pedidos, acessos, episódios e leituras não são cifrados
+// e as suas versões anteriores (bem como as chaves dos índices, com os códigos em claro)
+// continuam no histórico da ledger.
+type RedactionCertificate struct {
+	ResourceType                   int    `json:"resourceType"` // 4
+	CertificateID                  string `json:"certificateID"`
+	PatientID                      string `json:"patientID"`
+	Reason                         string `json:"reason"`
+	RedactedDate                   int64  `json:"redactedDate"`
+	HealthRecordsRedacted          int    `json:"healthRecordsRedacted"`
+	RequestsRedacted               int    `json:"requestsRedacted"`
+	AccessesRedacted               int    `json:"accessesRedacted"`
+	EncountersRedacted             int    `json:"encountersRedacted"`
+	AccessLogEntriesRedacted       int    `json:"accessLogEntriesRedacted"`
+	NotificationPreferencesDeleted bool   `json:"notificationPreferencesDeleted"`
+	RedactedKeysDigest             string `json:"redactedKeysDigest"`
+	KeyDestroyed                   bool   `json:"keyDestroyed"`
+	HistoryRetained                bool   `json:"historyRetained"`
+}
@@ -12,4 +12,6 @@ type Request struct {
 	Status                   int    `json:"status"`
 	StatusChangedDate        int64  `json:"statusChangedDate"`
 	ExpirationDate           int64  `json:"expirationDate"`
+	Erased                   bool   `json:"erased"`
+	ErasedDate               int64  `json:"erasedDate"`
 }
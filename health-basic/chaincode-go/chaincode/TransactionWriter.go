@@ -0,0 +1,11 @@
+package chaincode
+
+// Identidade de quem submeteu uma transação, guardada para o histórico das chaves
+// (o GetHistoryForKey só devolve o ID da transação).
+type TransactionWriter struct {
+	ResourceType int    `json:"resourceType"` // 8
+	TxID         string `json:"txID"`
+	ClientID     string `json:"clientID"`
+	MSPID        string `json:"mspID"`
+	CreatedDate  int64  `json:"createdDate"`
+}
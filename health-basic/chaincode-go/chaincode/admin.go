@@ -0,0 +1,375 @@
+package chaincode
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// AddCode regista um código de terminologia novo. Só para administradores.
+// Os registos guardam só o código, por isso não pode haver dois códigos iguais do
+// mesmo kind em sistemas diferentes; um código existente muda-se com o UpdateCode.
+// Os códigos de observação precisam da unidade para a qual os valores são convertidos,
+// os outros kinds não têm unidade.
+func (c *HealthContract) AddCode(ctx contractapi.TransactionContextInterface,
+	kind, system, code, display, unit string, references []CodeReference) error {
+
+	if !checkIfCallerIsAdmin(ctx) {
+		return accessDeniedError("only an admin can manage codes")
+	}
+
+	if kind != CodeKindRecordType && kind != CodeKindSpeciality && kind != CodeKindObservation {
+		return invalidArgumentError("invalid code kind: %s", kind)
+	}
+
+	if !codeSystems[system] {
+		return invalidArgumentError("invalid code system: %s", system)
+	}
+
+	if err := validateCodeContent(code, display, references); err != nil {
+		return err
+	}
+
+	if kind == CodeKindObservation {
+		conversion, ok := observationUnits[strings.ToLower(strings.TrimSpace(unit))]
+		if !ok {
+			return invalidArgumentError("invalid unit for observation code %s: %s", code, unit)
+		}
+		unit = conversion.unit
+	} else if unit != "" {
+		return invalidArgumentError("only observation codes have a unit")
+	}
+
+	existingCode, err := getCode(ctx, kind, code)
+	if err != nil {
+		return err
+	}
+
+	if existingCode != nil {
+		return conflictError("code already exists: %s %s (%s)", kind, code, existingCode.System)
+	}
+
+	createdDate, err := getTxDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	newCode := Code{
+		ResourceType: 5,
+		Kind:         kind,
+		System:       system,
+		Code:         code,
+		Display:      display,
+		References:   references,
+		Unit:         unit,
+		Active:       true,
+		CreatedDate:  createdDate,
+	}
+
+	if newCode.References == nil {
+		newCode.References = []CodeReference{}
+	}
+
+	return storeCode(ctx, newCode)
+}
+
+// UpdateCode muda a descrição e as referências de um código existente. O sistema, a
+// unidade e o estado (ativo ou não) mantêm-se.
+func (c *HealthContract) UpdateCode(ctx contractapi.TransactionContextInterface,
+	kind, code, display string, references []CodeReference) error {
+
+	if !checkIfCallerIsAdmin(ctx) {
+		return accessDeniedError("only an admin can manage codes")
+	}
+
+	if err := validateCodeContent(code, display, references); err != nil {
+		return err
+	}
+
+	existingCode, err := getCode(ctx, kind, code)
+	if err != nil {
+		return err
+	}
+
+	if existingCode == nil {
+		return notFoundError("code %s", code)
+	}
+
+	existingCode.Display = display
+	existingCode.References = references
+
+	if existingCode.References == nil {
+		existingCode.References = []CodeReference{}
+	}
+
+	return storeCode(ctx, *existingCode)
+}
+
+// DeactivateCode impede que o código seja usado em novos registos, os registos
+// antigos mantêm o código.
+func (c *HealthContract) DeactivateCode(ctx contractapi.TransactionContextInterface, kind, code string) error {
+
+	return setCodeActive(ctx, kind, code, false)
+}
+
+// ReactivateCode volta a permitir um código desativado com o DeactivateCode.
+func (c *HealthContract) ReactivateCode(ctx contractapi.TransactionContextInterface, kind, code string) error {
+
+	return setCodeActive(ctx, kind, code, true)
+}
+
+func setCodeActive(ctx contractapi.TransactionContextInterface, kind, code string, active bool) error {
+
+	if !checkIfCallerIsAdmin(ctx) {
+		return accessDeniedError("only an admin can manage codes")
+	}
+
+	existingCode, err := getCode(ctx, kind, code)
+	if err != nil {
+		return err
+	}
+
+	if existingCode == nil {
+		return notFoundError("code %s", code)
+	}
+
+	existingCode.Active = active
+
+	return storeCode(ctx, *existingCode)
+}
+
+func validateCodeContent(code, display string, references []CodeReference) error {
+
+	if code == "" || display == "" {
+		return invalidArgumentError("code and display cannot be empty")
+	}
+
+	for _, reference := range references {
+		if !codeSystems[reference.System] || reference.Code == "" {
+			return invalidArgumentError("invalid code reference: %s %s", reference.System, reference.Code)
+		}
+	}
+
+	return nil
+}
+
+func (c *HealthContract) GetCodes(ctx contractapi.TransactionContextInterface, kind string) ([]Code, error) {
+
+	var codes = []Code{}
+
+	err := scanState(ctx, "Codes", []string{"kind", kind}, func(key string, value []byte) error {
+		var code Code
+		if err := json.Unmarshal(value, &code); err != nil {
+			return fmt.Errorf("error unmarshalling code: %v", err)
+		}
+		codes = append(codes, code)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// RegisterHealthcareProfessionalCertificate regista o certificado usado para validar
+// as assinaturas dos registos do profissional. Só para administradores.
+func (c *HealthContract) RegisterHealthcareProfessionalCertificate(ctx contractapi.TransactionContextInterface,
+	healthcareProfessionalID, certificatePEM string) error {
+
+	if !checkIfCallerIsAdmin(ctx) {
+		return accessDeniedError("only an admin can register certificates")
+	}
+
+	if healthcareProfessionalID == "" {
+		return invalidArgumentError("healthcare professional ID cannot be empty")
+	}
+
+	block, _ := pem.Decode([]byte(certificatePEM))
+	if block == nil {
+		return invalidArgumentError("invalid certificate PEM")
+	}
+
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	createdDate, err := getTxDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	certificate := HealthcareProfessionalCertificate{
+		ResourceType:             6,
+		HealthcareProfessionalID: healthcareProfessionalID,
+		CertificatePEM:           certificatePEM,
+		CreatedDate:              createdDate,
+	}
+
+	compositeKey, err := createHealthcareProfessionalCertificateCompositeKey(ctx, healthcareProfessionalID)
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	certificateJSON, err := json.Marshal(certificate)
+	if err != nil {
+		return fmt.Errorf("failed to serialize certificate to JSON: %v", err)
+	}
+
+	err = ctx.GetStub().PutState(compositeKey, certificateJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store certificate on the ledger: %v", err)
+	}
+
+	return nil
+}
+
+func (c *HealthContract) GetHealthcareProfessionalCertificate(ctx contractapi.TransactionContextInterface,
+	healthcareProfessionalID string) (*HealthcareProfessionalCertificate, error) {
+
+	certificate, err := getHealthcareProfessionalCertificate(ctx, healthcareProfessionalID)
+	if err != nil {
+		return nil, err
+	}
+
+	if certificate == nil {
+		return nil, notFoundError("no certificate registered for healthcare professional %s", healthcareProfessionalID)
+	}
+
+	return certificate, nil
+}
+
+// GetMedicalHistoryForAudit devolve todo o histórico do paciente, incluindo os registos
+// introduzidos por erro. Só para auditores e administradores.
+func (c *HealthContract) GetMedicalHistoryForAudit(ctx contractapi.TransactionContextInterface,
+	patientID string, pageSize int32, bookmark string) (*HealthRecordsPage, error) {
+
+	if !checkIfCallerIsAuditor(ctx) {
+		return nil, accessDeniedError("only an auditor can read the full medical history")
+	}
+
+	page, err := getMedicalHistory(ctx, scanStatePage, patientID, MedicalHistoryQueryOptions{}, nil, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get patient wallet: %v", err)
+	}
+
+	return page, nil
+}
+
+// Os tipos de objeto com índices secundários, pela ordem em que o RebuildIndexes os percorre.
+var indexedObjectTypes = []string{"HealthRecords", "Requests", "Acesses", "AccessLog"}
+
+// RebuildIndexes volta a escrever as chaves dos índices secundários dos registos, pedidos,
+// acessos e leituras auditadas guardados antes de os índices existirem. Os acessos guardados
+// na chave antiga, só com o pedido, ficam nela e os índices apontam para lá (ver getAccessKey),
+// para o RemoveAccess e o GetAccessHistory os encontrarem com o histórico. Cada transação trata
+// no máximo pageSize objetos; o bookmark devolvido é onde a seguinte continua, vazio no fim.
+// Só para administradores.
+func (c *HealthContract) RebuildIndexes(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*RebuildIndexesPage, error) {
+
+	if !checkIfCallerIsAdmin(ctx) {
+		return nil, accessDeniedError("only an admin can rebuild indexes")
+	}
+
+	var scans = []stateScan{}
+	for _, objectType := range indexedObjectTypes {
+		objectType := objectType
+		scans = append(scans, stateScan{
+			objectType: objectType,
+			attributes: []string{},
+			visit: func(key string, value []byte) (bool, error) {
+				indexKeys, err := storedObjectIndexKeys(ctx, objectType, value)
+				if err != nil {
+					return false, err
+				}
+
+				return true, putIndexKeys(ctx, key, indexKeys)
+			},
+		})
+	}
+
+	fetched, nextBookmark, err := scanStatesPage(ctx, scanStatePageForUpdate, scans, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rebuild indexes: %v", err)
+	}
+
+	return &RebuildIndexesPage{FetchedRecordsCount: fetched, Bookmark: nextBookmark}, nil
+}
+
+// storedObjectIndexKeys devolve as chaves de índice de um objeto guardado do tipo indicado.
+func storedObjectIndexKeys(ctx contractapi.TransactionContextInterface, objectType string, value []byte) ([]string, error) {
+
+	switch objectType {
+	case "HealthRecords":
+		var healthRecord HealthRecord
+		if err := json.Unmarshal(value, &healthRecord); err != nil {
+			return nil, fmt.Errorf("error unmarshalling health record: %v", err)
+		}
+		return healthRecordIndexKeys(ctx, healthRecord)
+
+	case "Requests":
+		var request Request
+		if err := json.Unmarshal(value, &request); err != nil {
+			return nil, fmt.Errorf("error unmarshalling request: %v", err)
+		}
+		return requestIndexKeys(ctx, request)
+
+	case "Acesses":
+		var access Access
+		if err := json.Unmarshal(value, &access); err != nil {
+			return nil, fmt.Errorf("error unmarshalling access: %v", err)
+		}
+		return accessIndexKeys(ctx, access)
+
+	case "AccessLog":
+		var entry AccessLogEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return nil, fmt.Errorf("error unmarshalling access log entry: %v", err)
+		}
+		return accessLogEntryIndexKeys(ctx, entry)
+
+	default:
+		return nil, fmt.Errorf("no indexes for %s", objectType)
+	}
+}
+
+// GetMedicalHistoryAsOf mostra os registos, acessos e pedidos do paciente tal como estavam
+// no instante asOf, para litígios médico-legais. Com healthcareProfessionalID indica também o
+// que esse profissional podia ver nessa altura. Só para auditores e administradores.
+func (c *HealthContract) GetMedicalHistoryAsOf(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID string, asOf int64) (*MedicalHistoryAsOf, error) {
+
+	if !checkIfCallerIsAuditor(ctx) {
+		return nil, accessDeniedError("only an auditor can read the medical history as of a past date")
+	}
+
+	history, err := getMedicalHistoryAsOf(ctx, patientID, healthcareProfessionalID, asOf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get medical history as of %d: %v", asOf, err)
+	}
+
+	return history, nil
+}
+
+// GetAuditTrail devolve uma página dos acontecimentos para o relatório de auditoria entre as
+// datas indicadas, de um paciente e/ou de uma organização (MSP ID de quem submeteu). Sem o
+// paciente usa os índices por data, por isso um relatório de uma organização só lê o período
+// pedido. Repete-se com o bookmark devolvido até vir vazio. Só para auditores e administradores.
+func (c *HealthContract) GetAuditTrail(ctx contractapi.TransactionContextInterface,
+	patientID, organization string, dateFrom, dateTo int64, pageSize int32, bookmark string) (*AuditTrailPage, error) {
+
+	if !checkIfCallerIsAuditor(ctx) {
+		return nil, accessDeniedError("only an auditor can read the audit trail")
+	}
+
+	page, err := getAuditTrail(ctx, patientID, organization, dateFrom, dateTo, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit trail: %v", err)
+	}
+
+	return page, nil
+}
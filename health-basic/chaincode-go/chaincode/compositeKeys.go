@@ -6,6 +6,11 @@ import (
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
+// Os IDs que vêm de quem invoca só chegam à ledger como atributos das chaves compostas.
+// O CreateCompositeKey rejeita atributos com o separador (U+0000) ou UTF-8 inválido, por
+// isso um ID não consegue levar uma leitura ou escrita para a chave de outro objeto. As chaves são
+// sempre criadas por estas funções, nunca concatenadas à mão.
+
 func createPatientWalletCompositeKey(ctx contractapi.TransactionContextInterface, patientID, recordID string) (string, error) {
 	compositeKey, err := ctx.GetStub().CreateCompositeKey("HealthRecords", []string{"patientID", patientID, "recordID", recordID})
 	if err != nil {
@@ -22,10 +27,175 @@ func createRequestCompositeKey(ctx contractapi.TransactionContextInterface, pati
 	return compositeKey, nil
 }
 
-func createAcessesCompositeKey(ctx contractapi.TransactionContextInterface, requestID string) (string, error) {
+// O ID do pedido só é único por paciente, por isso a chave do acesso inclui o paciente.
+func createAcessesCompositeKey(ctx contractapi.TransactionContextInterface, patientID, requestID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey("Acesses", []string{"patientID", patientID, "requestID", requestID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+// Chave dos acessos guardados antes de a chave incluir o paciente (ver getAccessKey).
+func createLegacyAccessCompositeKey(ctx contractapi.TransactionContextInterface, requestID string) (string, error) {
 	compositeKey, err := ctx.GetStub().CreateCompositeKey("Acesses", []string{"requestID", requestID})
 	if err != nil {
 		return "", fmt.Errorf("failed to create composite key: %v", err)
 	}
 	return compositeKey, nil
 }
+
+func createRedactionCertificateCompositeKey(ctx contractapi.TransactionContextInterface, patientID, certificateID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey("RedactionCertificates", []string{"patientID", patientID, "certificateID", certificateID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createCodeCompositeKey(ctx contractapi.TransactionContextInterface, kind, code string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey("Codes", []string{"kind", kind, "code", code})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createHealthcareProfessionalCertificateCompositeKey(ctx contractapi.TransactionContextInterface, healthcareProfessionalID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey("HealthcareProfessionalCertificates", []string{"healthcareProfessionalID", healthcareProfessionalID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createEncounterCompositeKey(ctx contractapi.TransactionContextInterface, patientID, encounterID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey("Encounters", []string{"patientID", patientID, "encounterID", encounterID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+// Chaves dos índices secundários. O valor guardado em cada uma é a chave primária do objeto.
+
+func createHealthRecordByDateCompositeKey(ctx contractapi.TransactionContextInterface, index, patientID, date, recordID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(index, []string{"patientID", patientID, "date", date, "recordID", recordID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createHealthRecordByEncounterCompositeKey(ctx contractapi.TransactionContextInterface, patientID, encounterID, recordID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(healthRecordsByEncounterIndex, []string{"patientID", patientID, "encounterID", encounterID, "recordID", recordID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createHealthRecordLinkCompositeKey(ctx contractapi.TransactionContextInterface, patientID, toRecordID, fromRecordID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(healthRecordLinksIndex, []string{"patientID", patientID, "recordID", toRecordID, "fromRecordID", fromRecordID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createRequestByRequestIDCompositeKey(ctx contractapi.TransactionContextInterface, patientID, requestID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(requestsByRequestIDIndex, []string{"patientID", patientID, "requestID", requestID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createRequestByHealthcareProfessionalCompositeKey(ctx contractapi.TransactionContextInterface, healthcareProfessionalID, patientID, requestID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(requestsByHealthcareProfessionalIndex, []string{"healthcareProfessionalID", healthcareProfessionalID, "patientID", patientID, "requestID", requestID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createRequestByChangeDateCompositeKey(ctx contractapi.TransactionContextInterface, date, patientID, healthcareProfessionalID, requestID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(requestsByChangeDateIndex, []string{"date", date, "patientID", patientID, "healthcareProfessionalID", healthcareProfessionalID, "requestID", requestID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createAccessByPatientCompositeKey(ctx contractapi.TransactionContextInterface, patientID, requestID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(accessesByPatientIndex, []string{"patientID", patientID, "requestID", requestID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createAccessByHealthcareProfessionalCompositeKey(ctx contractapi.TransactionContextInterface, healthcareProfessionalID, patientID, requestID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(accessesByHealthcareProfessionalIndex, []string{"healthcareProfessionalID", healthcareProfessionalID, "patientID", patientID, "requestID", requestID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createAccessByChangeDateCompositeKey(ctx contractapi.TransactionContextInterface, date, patientID, requestID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(accessesByChangeDateIndex, []string{"date", date, "patientID", patientID, "requestID", requestID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createTransactionWriterCompositeKey(ctx contractapi.TransactionContextInterface, txID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey("TransactionWriters", []string{"txID", txID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createAccessLogCompositeKey(ctx contractapi.TransactionContextInterface, patientID, date, txID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey("AccessLog", []string{"patientID", patientID, "date", date, "txID", txID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createAccessLogByHealthcareProfessionalCompositeKey(ctx contractapi.TransactionContextInterface, healthcareProfessionalID, date, txID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(accessLogByHealthcareProfessionalIndex, []string{"healthcareProfessionalID", healthcareProfessionalID, "date", date, "txID", txID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createAccessLogByDateCompositeKey(ctx contractapi.TransactionContextInterface, date, txID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(accessLogByDateIndex, []string{"date", date, "txID", txID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+// Chave da coleção privada patientKeysCollection, não do estado público.
+func createPatientKeyCompositeKey(ctx contractapi.TransactionContextInterface, patientID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey("PatientKeys", []string{"patientID", patientID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
+
+func createNotificationPreferencesCompositeKey(ctx contractapi.TransactionContextInterface, patientID string) (string, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey("NotificationPreferences", []string{"patientID", patientID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return compositeKey, nil
+}
@@ -0,0 +1,40 @@
+package chaincode
+
+import "fmt"
+
+// Os erros que o cliente deve tratar começam por um prefixo fixo, que os gateways usam para
+// os distinguir (ex.: no código HTTP) sem depender do resto da mensagem. Quando são
+// embrulhados com contexto ("failed to ...: access denied: ...") o prefixo vem depois de ": ".
+// Os erros sem prefixo são falhas internas.
+const (
+	errorPrefixAccessDenied    = "access denied: "
+	errorPrefixNotFound        = "not found: "
+	errorPrefixConflict        = "conflict: "
+	errorPrefixGone            = "gone: "
+	errorPrefixInvalidArgument = "invalid argument: "
+)
+
+// accessDeniedError: quem invoca não pode fazer a operação.
+func accessDeniedError(format string, args ...any) error {
+	return fmt.Errorf(errorPrefixAccessDenied+format, args...)
+}
+
+// notFoundError: o objeto pedido não existe (ou é de outro paciente).
+func notFoundError(format string, args ...any) error {
+	return fmt.Errorf(errorPrefixNotFound+format, args...)
+}
+
+// conflictError: o objeto já existe ou já não está no estado que a operação precisa.
+func conflictError(format string, args ...any) error {
+	return fmt.Errorf(errorPrefixConflict+format, args...)
+}
+
+// goneError: o objeto foi apagado a pedido do paciente.
+func goneError(format string, args ...any) error {
+	return fmt.Errorf(errorPrefixGone+format, args...)
+}
+
+// invalidArgumentError: os argumentos da transação não são válidos.
+func invalidArgumentError(format string, args ...any) error {
+	return fmt.Errorf(errorPrefixInvalidArgument+format, args...)
+}
@@ -3,7 +3,6 @@ package chaincode
 import (
 	"encoding/json"
 	"fmt"
-	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
@@ -12,6 +11,30 @@ type AddPatientMedicalRecordResponse struct {
 	HealthcareProfessionalHasAccess bool `json:"healthcareProfessionalHasAccess"`
 	HealthRecordAlreadyExist        bool `json:"healthRecordAlreadyExist"`
 	HealthRecordAdded               bool `json:"healthRecordAdded"`
+	InvalidRecordType               bool `json:"invalidRecordType"`
+	InvalidSpeciality               bool `json:"invalidSpeciality"`
+	InvalidSignature                bool `json:"invalidSignature"`
+	InvalidSensitivityLabel         bool `json:"invalidSensitivityLabel"`
+	InvalidObservations             bool `json:"invalidObservations"`
+	InvalidEncounter                bool `json:"invalidEncounter"`
+	InvalidLinks                    bool `json:"invalidLinks"`
+}
+
+type GetPatientHealthRecordGraphResponse struct {
+	HealthcareProfessionalHasAccess bool               `json:"healthcareProfessionalHasAccess"`
+	Graph                           *HealthRecordGraph `json:"graph"`
+	WithheldHealthRecords           int                `json:"withheldHealthRecords"`
+}
+
+type OpenEncounterResponse struct {
+	HealthcareProfessionalHasAccess bool `json:"healthcareProfessionalHasAccess"`
+	EncounterAlreadyExist           bool `json:"encounterAlreadyExist"`
+	EncounterOpened                 bool `json:"encounterOpened"`
+}
+
+type GetPatientEncountersResponse struct {
+	HealthcareProfessionalHasAccess bool        `json:"healthcareProfessionalHasAccess"`
+	Encounters                      []Encounter `json:"encounters"`
 }
 
 type RequestPatientMedicalDataResponse struct {
@@ -23,15 +46,42 @@ type RequestPatientMedicalDataResponse struct {
 type GetPatientMedicalHistoryResponse struct {
 	HealthcareProfessionalHasAccess bool           `json:"healthcareProfessionalHasAccess"`
 	HealthRecords                   []HealthRecord `json:"healthRecords"`
+	WithheldHealthRecords           int            `json:"withheldHealthRecords"`
+	FetchedRecordsCount             int32          `json:"fetchedRecordsCount"`
+	Bookmark                        string         `json:"bookmark"`
+}
+
+type GetPatientObservationSeriesResponse struct {
+	HealthcareProfessionalHasAccess bool               `json:"healthcareProfessionalHasAccess"`
+	Observations                    []ObservationPoint `json:"observations"`
 }
 
 type GetHealthRecordWithHealthcareProfessionalByIDResponse struct {
 	HealthcareProfessionalHasAccess bool         `json:"healthcareProfessionalHasAccess"`
 	HealthRecord                    HealthRecord `json:"healthRecord"`
+	HealthRecordWithheld            bool         `json:"healthRecordWithheld"`
+	HealthRecordRetracted           bool         `json:"healthRecordRetracted"`
 }
 
+// GetPatientMedicalHistory é a leitura avaliada do histórico: não fica no registo de acessos,
+// por isso o gateway usa sempre o GetPatientMedicalHistoryAudited.
 func (c *HealthContract) GetPatientMedicalHistory(ctx contractapi.TransactionContextInterface,
-	patientID, healthcareProfessionalID string) (*GetPatientMedicalHistoryResponse, error) {
+	patientID, healthcareProfessionalID string, options MedicalHistoryQueryOptions,
+	pageSize int32, bookmark string) (*GetPatientMedicalHistoryResponse, error) {
+
+	if err := checkProfessionalRead(ctx, healthcareProfessionalID); err != nil {
+		return nil, err
+	}
+
+	return getPatientMedicalHistory(ctx, scanStatePage, patientID, healthcareProfessionalID, options, pageSize, bookmark)
+}
+
+// getPatientMedicalHistory devolve uma página do histórico, filtrada e ordenada pelas options
+// (as mesmas do GetMedicalHistory). WithheldHealthRecords conta só os retidos nesta página,
+// e só sem filtros: com filtros diria se há registos sensíveis que lhes correspondem.
+func getPatientMedicalHistory(ctx contractapi.TransactionContextInterface, scan statePageScanner,
+	patientID, healthcareProfessionalID string, options MedicalHistoryQueryOptions,
+	pageSize int32, bookmark string) (*GetPatientMedicalHistoryResponse, error) {
 
 	resp := GetPatientMedicalHistoryResponse{}
 	resp.HealthRecords = []HealthRecord{}
@@ -39,17 +89,117 @@ func (c *HealthContract) GetPatientMedicalHistory(ctx contractapi.TransactionCon
 	resp.HealthcareProfessionalHasAccess = checkIfHealthcareProfessionalHaveAccess(ctx, patientID, healthcareProfessionalID)
 
 	if resp.HealthcareProfessionalHasAccess {
-		healthRecords, err := getMedicalHistory(ctx, patientID)
+		allowedLabels := getHealthcareProfessionalSensitivityLabels(ctx, patientID, healthcareProfessionalID)
+
+		// Os registos escondidos não entram na página, para o FetchedRecordsCount contar
+		// só os devolvidos e as páginas virem cheias.
+		withheld := 0
+		visible := func(healthRecord HealthRecord) bool {
+			if isHealthRecordWithheld(healthRecord, allowedLabels) {
+				withheld++
+				return false
+			}
+			return options.IncludeRetracted || !healthRecord.EnteredInError
+		}
+
+		page, err := getMedicalHistory(ctx, scan, patientID, options, visible, pageSize, bookmark)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get patient wallet: %v", err)
 		}
-		resp.HealthRecords = healthRecords
+
+		resp.HealthRecords = page.HealthRecords
+		if !hasMedicalHistoryFilters(options) {
+			resp.WithheldHealthRecords = withheld
+		}
+		resp.FetchedRecordsCount = page.FetchedRecordsCount
+		resp.Bookmark = page.Bookmark
 	}
 
 	return &resp, nil
 }
 
-func (c *HealthContract) GetHealthRecordWithHealthcareProfessionalByID(ctx contractapi.TransactionContextInterface, patientID, healthcareProfessionalID, recordID string) (*GetHealthRecordWithHealthcareProfessionalByIDResponse, error) {
+func (c *HealthContract) GetPatientMedicalHistoryByCode(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, kind, code string) (*GetPatientMedicalHistoryResponse, error) {
+
+	if err := checkProfessionalRead(ctx, healthcareProfessionalID); err != nil {
+		return nil, err
+	}
+
+	return getPatientMedicalHistoryByCode(ctx, patientID, healthcareProfessionalID, kind, code)
+}
+
+// getPatientMedicalHistoryByCode devolve os registos do paciente com o código indicado,
+// kind é "recordType" ou "speciality". Como o código é um filtro, não diz quantos registos
+// foram retidos.
+func getPatientMedicalHistoryByCode(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, kind, code string) (*GetPatientMedicalHistoryResponse, error) {
+
+	resp := GetPatientMedicalHistoryResponse{}
+	resp.HealthRecords = []HealthRecord{}
+
+	resp.HealthcareProfessionalHasAccess = checkIfHealthcareProfessionalHaveAccess(ctx, patientID, healthcareProfessionalID)
+
+	if resp.HealthcareProfessionalHasAccess {
+		healthRecords, err := getMedicalHistoryByCode(ctx, patientID, kind, code)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get patient wallet: %v", err)
+		}
+
+		allowedLabels := getHealthcareProfessionalSensitivityLabels(ctx, patientID, healthcareProfessionalID)
+		resp.HealthRecords, _ = withholdSensitiveHealthRecords(healthRecords, allowedLabels)
+		resp.HealthRecords = hideRetractedHealthRecords(resp.HealthRecords)
+	}
+
+	return &resp, nil
+}
+
+func (c *HealthContract) GetPatientObservationSeries(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, code string) (*GetPatientObservationSeriesResponse, error) {
+
+	if err := checkProfessionalRead(ctx, healthcareProfessionalID); err != nil {
+		return nil, err
+	}
+
+	return getPatientObservationSeries(ctx, patientID, healthcareProfessionalID, code)
+}
+
+// getPatientObservationSeries devolve a série temporal de uma observação (ex.: HbA1c) do paciente,
+// respeitando o acesso do profissional, as etiquetas sensíveis e os registos retirados.
+// Tal como no getPatientMedicalHistoryByCode, não diz quantos registos foram retidos.
+func getPatientObservationSeries(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, code string) (*GetPatientObservationSeriesResponse, error) {
+
+	resp := GetPatientObservationSeriesResponse{}
+	resp.Observations = []ObservationPoint{}
+
+	resp.HealthcareProfessionalHasAccess = checkIfHealthcareProfessionalHaveAccess(ctx, patientID, healthcareProfessionalID)
+
+	if resp.HealthcareProfessionalHasAccess {
+		healthRecords, err := getMedicalHistoryByObservationCode(ctx, patientID, code)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get patient wallet: %v", err)
+		}
+
+		allowedLabels := getHealthcareProfessionalSensitivityLabels(ctx, patientID, healthcareProfessionalID)
+		visible, _ := withholdSensitiveHealthRecords(healthRecords, allowedLabels)
+
+		resp.Observations = getObservationSeries(hideRetractedHealthRecords(visible), code)
+	}
+
+	return &resp, nil
+}
+
+func (c *HealthContract) GetHealthRecordWithHealthcareProfessionalByID(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, recordID string) (*GetHealthRecordWithHealthcareProfessionalByIDResponse, error) {
+
+	if err := checkProfessionalRead(ctx, healthcareProfessionalID); err != nil {
+		return nil, err
+	}
+
+	return getHealthRecordWithHealthcareProfessionalByID(ctx, patientID, healthcareProfessionalID, recordID)
+}
+
+func getHealthRecordWithHealthcareProfessionalByID(ctx contractapi.TransactionContextInterface, patientID, healthcareProfessionalID, recordID string) (*GetHealthRecordWithHealthcareProfessionalByIDResponse, error) {
 
 	resp := GetHealthRecordWithHealthcareProfessionalByIDResponse{}
 	resp.HealthRecord = HealthRecord{}
@@ -62,64 +212,69 @@ func (c *HealthContract) GetHealthRecordWithHealthcareProfessionalByID(ctx contr
 			return nil, fmt.Errorf("erro ao obter o dado de saúde: %v", err)
 		}
 
-		resp.HealthRecord = *healthRecord
+		allowedLabels := getHealthcareProfessionalSensitivityLabels(ctx, patientID, healthcareProfessionalID)
+		if healthRecord.EnteredInError {
+			resp.HealthRecordRetracted = true
+		} else if healthRecord.SensitivityLabel != "" && !allowedLabels[healthRecord.SensitivityLabel] {
+			resp.HealthRecordWithheld = true
+		} else {
+			resp.HealthRecord = *healthRecord
+		}
 	}
 
 	return &resp, nil
 }
 
 func (c *HealthContract) GetAccessesByHealthcareProfessionalID(ctx contractapi.TransactionContextInterface,
-	healthcareProfessionalID string) ([]Access, error) {
-
-	var accesses = []Access{}
-
-	// Construct the selector query to retrieve accesses by patientID
-	queryString := fmt.Sprintf(`{
-        "selector": {
-            "healthcareProfessionalID": "%s",
-			"resourceType": 2
-        }
-    }`, healthcareProfessionalID)
+	healthcareProfessionalID string, pageSize int32, bookmark string) (*AccessesPage, error) {
 
-	// Execute the selector query
-	queryResultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
-	if err != nil {
-		return accesses, nil
+	if !checkIfCallerIsHealthcareProfessional(ctx, healthcareProfessionalID) {
+		return nil, accessDeniedError("only the healthcare professional can read their accesses")
 	}
-	defer queryResultsIterator.Close()
 
-	// Iterate through the query results
-	for queryResultsIterator.HasNext() {
-		queryResponse, err := queryResultsIterator.Next()
-		if err != nil {
-			return nil, fmt.Errorf("error retrieving next query result: %v", err)
-		}
+	page := AccessesPage{}
+	page.Accesses = []Access{}
 
-		// Deserialize the access from JSON
-		var access Access
-		err = json.Unmarshal(queryResponse.Value, &access)
-		if err != nil {
-			return nil, fmt.Errorf("error unmarshalling access: %v", err)
-		}
+	fetched, nextBookmark, err := scanStatePage(ctx, accessesByHealthcareProfessionalIndex,
+		[]string{"healthcareProfessionalID", healthcareProfessionalID}, nil, pageSize, bookmark,
+		func(key string, value []byte) (bool, error) {
+			var access Access
+			if err := json.Unmarshal(value, &access); err != nil {
+				return false, fmt.Errorf("error unmarshalling access: %v", err)
+			}
 
-		// Append the retrieved access to the slice
-		accesses = append(accesses, access)
+			page.Accesses = append(page.Accesses, access)
+			return true, nil
+		})
+	if err != nil {
+		return nil, err
 	}
 
-	// Return the retrieved accesses
-	return accesses, nil
+	page.FetchedRecordsCount = fetched
+	page.Bookmark = nextBookmark
+
+	return &page, nil
 }
 
 func (c *HealthContract) RequestPatientMedicalData(ctx contractapi.TransactionContextInterface,
 	patientID, patientName, description, healthcareProfessionalID,
 	healthcareProfessional, requestID string, expirationDate int64) (*RequestPatientMedicalDataResponse, error) {
 
+	if !checkIfCallerIsHealthcareProfessional(ctx, healthcareProfessionalID) {
+		return nil, accessDeniedError("only the healthcare professional can request access in their name")
+	}
+
 	resp := RequestPatientMedicalDataResponse{}
 
 	resp.HealthcareProfessionalAlreadyHasAccess = checkIfHealthcareProfessionalHaveAccess(ctx, patientID, healthcareProfessionalID)
 	resp.AlreadyHavePendingRequest = checkIfHealthcareProfessionaRequestAlreadyExist(ctx, patientID, healthcareProfessionalID)
 
 	if !resp.HealthcareProfessionalAlreadyHasAccess && !resp.AlreadyHavePendingRequest {
+		createdDate, err := getTxDate(ctx)
+		if err != nil {
+			return nil, err
+		}
+
 		request := Request{
 			ResourceType:             1,
 			RequestID:                requestID,
@@ -129,95 +284,229 @@ func (c *HealthContract) RequestPatientMedicalData(ctx contractapi.TransactionCo
 			Status:                   0,
 			HealthcareProfessionalID: healthcareProfessionalID,
 			HealthcareProfessional:   healthcareProfessional,
-			StatusChangedDate:        time.Now().Unix(),
-			CreatedDate:              time.Now().Unix(),
+			StatusChangedDate:        createdDate,
+			CreatedDate:              createdDate,
 			ExpirationDate:           expirationDate,
 		}
 
-		err := storeRequest(ctx, request)
+		err = storeRequest(ctx, request)
 		if err != nil {
 			return nil, fmt.Errorf("failed to store request: %v", err)
 		}
 
+		err = setHealthEvents(ctx, HealthEvent{
+			Type:                     EventRequestCreated,
+			PatientID:                patientID,
+			HealthcareProfessionalID: healthcareProfessionalID,
+			RequestID:                requestID,
+			ExpirationDate:           expirationDate,
+		})
+		if err != nil {
+			return nil, err
+		}
+
 		resp.RequestSent = true
 	}
 
 	return &resp, nil
 }
 
-func (c *HealthContract) GetRequestsWithHealthcareProfessional(ctx contractapi.TransactionContextInterface, healthcareProfessionalID string) ([]Request, error) {
+// Duração, em segundos, de um acesso de emergência.
+const emergencyAccessDuration int64 = 24 * 60 * 60
 
-	var requests = []Request{}
+// GrantEmergencyAccess dá ao profissional um acesso imediato aos dados do paciente, sem
+// esperar pela resposta, quando não há tempo para pedir (ex.: paciente inconsciente).
+// O motivo é obrigatório. O acesso dura emergencyAccessDuration, não inclui os registos com
+// etiquetas sensíveis e fica marcado como de emergência para o paciente e para a auditoria;
+// o paciente pode cortá-lo com o RemoveAccess, como os outros.
+func (c *HealthContract) GrantEmergencyAccess(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, healthcareProfessional, reason string) (*Access, error) {
 
-	queryString := fmt.Sprintf(`{
-        "selector": {
-            "healthcareProfessionalID": "%s",
-			"resourceType": 1,
-			"status" : 0,
-			"expirationDate": {
-                "$gt": %d
-            }
-        }
-    }`, healthcareProfessionalID, time.Now().Unix())
+	if !checkIfCallerIsHealthcareProfessional(ctx, healthcareProfessionalID) {
+		return nil, accessDeniedError("only the healthcare professional can get an emergency access in their name")
+	}
+
+	if reason == "" {
+		return nil, invalidArgumentError("an emergency access needs a reason")
+	}
 
-	queryResultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	createdDate, err := getTxDate(ctx)
 	if err != nil {
-		return requests, nil
+		return nil, err
 	}
-	defer queryResultsIterator.Close()
 
-	for queryResultsIterator.HasNext() {
-		queryResponse, err := queryResultsIterator.Next()
-		if err != nil {
-			return nil, fmt.Errorf("error retrieving next query result: %v", err)
-		}
+	access := Access{
+		ResourceType:             2,
+		RequestID:                "emergency-" + ctx.GetStub().GetTxID(),
+		PatientID:                patientID,
+		HealthcareProfessionalID: healthcareProfessionalID,
+		HealthcareProfessional:   healthcareProfessional,
+		CreatedDate:              createdDate,
+		ExpirationDate:           createdDate + emergencyAccessDuration,
+		SensitivityLabels:        []string{},
+		Emergency:                true,
+		EmergencyReason:          reason,
+	}
 
-		var request Request
-		if err := json.Unmarshal(queryResponse.Value, &request); err != nil {
-			return nil, fmt.Errorf("error unmarshalling query result: %v", err)
-		}
-		requests = append(requests, request)
+	err = storeAccess(ctx, access)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add access: %v", err)
+	}
+
+	err = setHealthEvents(ctx, HealthEvent{
+		Type:                     EventAccessGranted,
+		PatientID:                patientID,
+		HealthcareProfessionalID: healthcareProfessionalID,
+		RequestID:                access.RequestID,
+		ExpirationDate:           access.ExpirationDate,
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return requests, nil
+	return &access, nil
+}
+
+func (c *HealthContract) GetRequestsWithHealthcareProfessional(ctx contractapi.TransactionContextInterface,
+	healthcareProfessionalID string, pageSize int32, bookmark string) (*RequestsPage, error) {
+
+	if !checkIfCallerIsHealthcareProfessional(ctx, healthcareProfessionalID) {
+		return nil, accessDeniedError("only the healthcare professional can read their requests")
+	}
+
+	page := RequestsPage{}
+	page.Requests = []Request{}
+
+	now, err := getTxDate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fetched, nextBookmark, err := scanStatePage(ctx, requestsByHealthcareProfessionalIndex,
+		[]string{"healthcareProfessionalID", healthcareProfessionalID}, nil, pageSize, bookmark,
+		func(key string, value []byte) (bool, error) {
+			var request Request
+			if err := json.Unmarshal(value, &request); err != nil {
+				return false, fmt.Errorf("error unmarshalling query result: %v", err)
+			}
+
+			if request.Status != 0 || request.ExpirationDate <= now {
+				return false, nil
+			}
+
+			page.Requests = append(page.Requests, request)
+			return true, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	page.FetchedRecordsCount = fetched
+	page.Bookmark = nextBookmark
+
+	return &page, nil
 }
 
 func (c *HealthContract) AddPatientMedicalRecord(ctx contractapi.TransactionContextInterface,
 	recordID, description, healthcareProfessionalID, healthcareProfessional, patientID,
-	organization, recordType, speciality string, eventDate int64) (*AddPatientMedicalRecordResponse, error) {
+	organization, recordType, speciality string, eventDate int64, signature, sensitivityLabel string,
+	observations []Observation, encounterID string, links []HealthRecordLink) (*AddPatientMedicalRecordResponse, error) {
+
+	if !checkIfCallerIsHealthcareProfessional(ctx, healthcareProfessionalID) {
+		return nil, accessDeniedError("only the healthcare professional can add a health record in their name")
+	}
 
 	resp := AddPatientMedicalRecordResponse{}
 	resp.HealthRecordAlreadyExist = checkIfHealthRecordAlreadyExist(ctx, recordID, patientID)
 	resp.HealthcareProfessionalHasAccess = checkIfHealthcareProfessionalHaveAccess(ctx, patientID, healthcareProfessionalID)
 
-	if !resp.HealthRecordAlreadyExist && resp.HealthcareProfessionalHasAccess {
+	// O tipo de registo e a especialidade têm de ser códigos registados e ativos.
+	recordTypeCode := getActiveCode(ctx, CodeKindRecordType, recordType)
+	specialityCode := getActiveCode(ctx, CodeKindSpeciality, speciality)
+	resp.InvalidRecordType = recordTypeCode == nil
+	resp.InvalidSpeciality = specialityCode == nil
+	resp.InvalidSensitivityLabel = sensitivityLabel != "" && !knownSensitivityLabels[sensitivityLabel]
+
+	normalizedObservations, validObservations := validateObservations(ctx, observations)
+	resp.InvalidObservations = !validObservations
+
+	// O episódio é opcional, mas se vier tem de existir para o mesmo paciente e estar aberto.
+	if encounterID != "" {
+		encounter, err := getEncounter(ctx, patientID, encounterID)
+		if err != nil {
+			return nil, err
+		}
+		resp.InvalidEncounter = encounter == nil || encounter.Status == 1
+	}
+
+	resp.InvalidLinks = !validateHealthRecordLinks(ctx, patientID, recordID, links)
+	if links == nil {
+		links = []HealthRecordLink{}
+	}
+
+	if !resp.HealthRecordAlreadyExist && resp.HealthcareProfessionalHasAccess &&
+		!resp.InvalidRecordType && !resp.InvalidSpeciality && !resp.InvalidSensitivityLabel &&
+		!resp.InvalidObservations && !resp.InvalidEncounter && !resp.InvalidLinks {
+		createdDate, err := getTxDate(ctx)
+		if err != nil {
+			return nil, err
+		}
+
 		newRecord := HealthRecord{
 			ResourceType:             3,
 			RecordID:                 recordID,
 			PatientID:                patientID,
 			Description:              description,
-			CreatedDate:              time.Now().Unix(),
+			CreatedDate:              createdDate,
 			HealthCareProfessional:   healthcareProfessional,
 			HealthCareProfessionalID: healthcareProfessionalID,
 			EventDate:                eventDate,
 			Organization:             organization,
-			RecordType:               recordType,
-			Speciality:               speciality,
+			EncounterID:              encounterID,
+			Links:                    links,
+			SensitivityLabel:         sensitivityLabel,
+			Observations:             normalizedObservations,
+			RecordType:               recordTypeCode.Display,
+			RecordTypeCode:           recordTypeCode.Code,
+			Speciality:               specialityCode.Display,
+			SpecialityCode:           specialityCode.Code,
+			Disputes:                 []HealthRecordDispute{},
 		}
 
-		compositeKey, err := createPatientWalletCompositeKey(ctx, patientID, recordID)
+		// Com certificado registado a assinatura é obrigatória e tem de ser válida para ele.
+		// Sem certificado só se aceitam registos sem assinatura, que não há como validar.
+		certificate, err := getHealthcareProfessionalCertificate(ctx, healthcareProfessionalID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create composite key: %v", err)
+			return nil, err
 		}
 
-		newRecordJSON, err := json.Marshal(newRecord)
+		if certificate != nil {
+			if signature == "" || verifyHealthRecordSignature(newRecord, signature, certificate.CertificatePEM) != nil {
+				resp.InvalidSignature = true
+				return &resp, nil
+			}
+
+			newRecord.Signature = signature
+			newRecord.SignerCertificate = certificate.CertificatePEM
+		} else if signature != "" {
+			resp.InvalidSignature = true
+			return &resp, nil
+		}
+
+		err = storeHealthRecord(ctx, newRecord)
 		if err != nil {
-			return &resp, fmt.Errorf("failed to serialize request to JSON: %v", err)
+			return &resp, err
 		}
 
-		err = ctx.GetStub().PutState(compositeKey, newRecordJSON)
+		err = setHealthEvents(ctx, HealthEvent{
+			Type:                     EventHealthRecordAdded,
+			PatientID:                patientID,
+			HealthcareProfessionalID: healthcareProfessionalID,
+			RecordID:                 recordID,
+			EncounterID:              encounterID,
+		})
 		if err != nil {
-			return &resp, fmt.Errorf("failed to update health records: %v", err)
+			return &resp, err
 		}
 
 		resp.HealthRecordAdded = true
@@ -226,33 +515,626 @@ func (c *HealthContract) AddPatientMedicalRecord(ctx contractapi.TransactionCont
 	return &resp, nil
 }
 
+func (c *HealthContract) GetPatientHealthRecordGraph(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, recordID string) (*GetPatientHealthRecordGraphResponse, error) {
+
+	if err := checkProfessionalRead(ctx, healthcareProfessionalID); err != nil {
+		return nil, err
+	}
+
+	return getPatientHealthRecordGraph(ctx, patientID, healthcareProfessionalID, recordID)
+}
+
+// getPatientHealthRecordGraph devolve o grafo de registos ligados ao registo indicado
+// (seguimentos, resultados, substituições), sem os registos a que o profissional não tem acesso.
+func getPatientHealthRecordGraph(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, recordID string) (*GetPatientHealthRecordGraphResponse, error) {
+
+	resp := GetPatientHealthRecordGraphResponse{}
+	resp.Graph = &HealthRecordGraph{HealthRecords: []HealthRecord{}, Edges: []HealthRecordGraphEdge{}}
+
+	resp.HealthcareProfessionalHasAccess = checkIfHealthcareProfessionalHaveAccess(ctx, patientID, healthcareProfessionalID)
+
+	if resp.HealthcareProfessionalHasAccess {
+		// Os registos retirados e os retidos pelas etiquetas não são atravessados, senão o
+		// grafo mostraria os registos a que só se chega através deles.
+		allowedLabels := getHealthcareProfessionalSensitivityLabels(ctx, patientID, healthcareProfessionalID)
+		withheld := 0
+
+		graph, err := getHealthRecordGraph(ctx, patientID, recordID, func(healthRecord HealthRecord) bool {
+			if healthRecord.EnteredInError {
+				return false
+			}
+			if healthRecord.SensitivityLabel != "" && !allowedLabels[healthRecord.SensitivityLabel] {
+				withheld++
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Graph = graph
+		resp.WithheldHealthRecords = withheld
+	}
+
+	return &resp, nil
+}
+
+// OpenEncounter abre um episódio de cuidados (ex.: internamento) para agrupar os registos relacionados.
+// O profissional responsável é quem invoca.
+func (c *HealthContract) OpenEncounter(ctx contractapi.TransactionContextInterface,
+	encounterID, patientID, encounterType, organization, healthcareProfessionalID,
+	healthcareProfessional string, startDate int64) (*OpenEncounterResponse, error) {
+
+	if !encounterTypes[encounterType] {
+		return nil, invalidArgumentError("invalid encounter type: %s", encounterType)
+	}
+
+	if !checkIfCallerIsHealthcareProfessional(ctx, healthcareProfessionalID) {
+		return nil, accessDeniedError("only the healthcare professional can open an encounter in their name")
+	}
+
+	resp := OpenEncounterResponse{}
+	resp.HealthcareProfessionalHasAccess = checkIfHealthcareProfessionalHaveAccess(ctx, patientID, healthcareProfessionalID)
+
+	existingEncounter, err := getEncounter(ctx, patientID, encounterID)
+	if err != nil {
+		return nil, err
+	}
+	resp.EncounterAlreadyExist = existingEncounter != nil
+
+	if resp.HealthcareProfessionalHasAccess && !resp.EncounterAlreadyExist {
+		createdDate, err := getTxDate(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		encounter := Encounter{
+			ResourceType:                      7,
+			EncounterID:                       encounterID,
+			PatientID:                         patientID,
+			Type:                              encounterType,
+			Organization:                      organization,
+			AttendingHealthcareProfessionalID: healthcareProfessionalID,
+			AttendingHealthcareProfessional:   healthcareProfessional,
+			StartDate:                         startDate,
+			Status:                            0,
+			CreatedDate:                       createdDate,
+		}
+
+		err = storeEncounter(ctx, encounter)
+		if err != nil {
+			return nil, err
+		}
+
+		err = setHealthEvents(ctx, HealthEvent{
+			Type:                     EventEncounterOpened,
+			PatientID:                patientID,
+			HealthcareProfessionalID: healthcareProfessionalID,
+			EncounterID:              encounterID,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		resp.EncounterOpened = true
+	}
+
+	return &resp, nil
+}
+
+// CloseEncounter fecha o episódio, só o profissional responsável o pode fazer.
+func (c *HealthContract) CloseEncounter(ctx contractapi.TransactionContextInterface,
+	encounterID, patientID, healthcareProfessionalID string, endDate int64) error {
+
+	if !checkIfCallerIsHealthcareProfessional(ctx, healthcareProfessionalID) {
+		return accessDeniedError("only the attending healthcare professional can close the encounter")
+	}
+
+	encounter, err := getEncounter(ctx, patientID, encounterID)
+	if err != nil {
+		return err
+	}
+
+	if encounter == nil {
+		return notFoundError("encounter %s", encounterID)
+	}
+
+	if encounter.AttendingHealthcareProfessionalID != healthcareProfessionalID {
+		return accessDeniedError("only the attending healthcare professional can close the encounter")
+	}
+
+	if encounter.Status == 1 {
+		return conflictError("encounter already closed: %s", encounterID)
+	}
+
+	if endDate < encounter.StartDate {
+		return invalidArgumentError("encounter end date cannot be before the start date")
+	}
+
+	encounter.EndDate = endDate
+	encounter.Status = 1
+
+	err = storeEncounter(ctx, *encounter)
+	if err != nil {
+		return err
+	}
+
+	return setHealthEvents(ctx, HealthEvent{
+		Type:                     EventEncounterClosed,
+		PatientID:                patientID,
+		HealthcareProfessionalID: healthcareProfessionalID,
+		EncounterID:              encounterID,
+	})
+}
+
+func (c *HealthContract) GetPatientEncounters(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID string) (*GetPatientEncountersResponse, error) {
+
+	if err := checkProfessionalRead(ctx, healthcareProfessionalID); err != nil {
+		return nil, err
+	}
+
+	return getPatientEncounters(ctx, patientID, healthcareProfessionalID)
+}
+
+func getPatientEncounters(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID string) (*GetPatientEncountersResponse, error) {
+
+	resp := GetPatientEncountersResponse{}
+	resp.Encounters = []Encounter{}
+
+	resp.HealthcareProfessionalHasAccess = checkIfHealthcareProfessionalHaveAccess(ctx, patientID, healthcareProfessionalID)
+
+	if resp.HealthcareProfessionalHasAccess {
+		encounters, err := getEncounters(ctx, patientID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get encounters: %v", err)
+		}
+		resp.Encounters = encounters
+	}
+
+	return &resp, nil
+}
+
+func (c *HealthContract) GetPatientEncounterRecords(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, encounterID string) (*GetPatientMedicalHistoryResponse, error) {
+
+	if err := checkProfessionalRead(ctx, healthcareProfessionalID); err != nil {
+		return nil, err
+	}
+
+	return getPatientEncounterRecords(ctx, patientID, healthcareProfessionalID, encounterID)
+}
+
+func getPatientEncounterRecords(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, encounterID string) (*GetPatientMedicalHistoryResponse, error) {
+
+	resp := GetPatientMedicalHistoryResponse{}
+	resp.HealthRecords = []HealthRecord{}
+
+	resp.HealthcareProfessionalHasAccess = checkIfHealthcareProfessionalHaveAccess(ctx, patientID, healthcareProfessionalID)
+
+	if resp.HealthcareProfessionalHasAccess {
+		healthRecords, err := getEncounterRecords(ctx, patientID, encounterID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get patient wallet: %v", err)
+		}
+
+		allowedLabels := getHealthcareProfessionalSensitivityLabels(ctx, patientID, healthcareProfessionalID)
+		resp.HealthRecords, resp.WithheldHealthRecords = withholdSensitiveHealthRecords(hideRetractedHealthRecords(healthRecords), allowedLabels)
+	}
+
+	return &resp, nil
+}
+
+// RetractHealthRecord marca um registo como introduzido por erro (ex.: no paciente errado).
+// Só o profissional que o escreveu (pelo certificado de quem invoca) ou um administrador o
+// pode fazer. O registo deixa de aparecer aos profissionais, exceto se o pedirem com
+// IncludeRetracted, mas continua visível para o paciente e para auditoria.
+func (c *HealthContract) RetractHealthRecord(ctx contractapi.TransactionContextInterface,
+	patientID, recordID, healthcareProfessionalID, reason string) error {
+
+	if reason == "" {
+		return invalidArgumentError("retraction reason cannot be empty")
+	}
+
+	healthRecord, err := getHealthRecordByID(ctx, patientID, recordID)
+	if err != nil {
+		return fmt.Errorf("erro ao obter o dado de saúde: %v", err)
+	}
+
+	if healthRecord.RecordID == "" {
+		return notFoundError("health record %s", recordID)
+	}
+
+	retractedBy := healthcareProfessionalID
+	if checkIfCallerIsAdmin(ctx) {
+		retractedBy = "admin"
+	} else if !checkIfCallerIsHealthcareProfessional(ctx, healthcareProfessionalID) ||
+		healthRecord.PatientAuthored || healthRecord.HealthCareProfessionalID != healthcareProfessionalID {
+		return accessDeniedError("only the authoring healthcare professional or an admin can retract the health record")
+	}
+
+	if healthRecord.Erased {
+		return goneError("health record was erased: %s", recordID)
+	}
+
+	if healthRecord.EnteredInError {
+		return conflictError("health record already retracted: %s", recordID)
+	}
+
+	retractedDate, err := getTxDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	healthRecord.EnteredInError = true
+	healthRecord.RetractedReason = reason
+	healthRecord.RetractedDate = retractedDate
+	healthRecord.RetractedBy = retractedBy
+
+	err = storeHealthRecord(ctx, *healthRecord)
+	if err != nil {
+		return err
+	}
+
+	return setHealthEvents(ctx, HealthEvent{
+		Type:                     EventHealthRecordRetracted,
+		PatientID:                patientID,
+		HealthcareProfessionalID: healthRecord.HealthCareProfessionalID,
+		RecordID:                 recordID,
+	})
+}
+
+// hideRetractedHealthRecords retira os registos introduzidos por erro das vistas dos profissionais.
+func hideRetractedHealthRecords(healthRecords []HealthRecord) []HealthRecord {
+
+	var visible = []HealthRecord{}
+
+	for _, healthRecord := range healthRecords {
+		if !healthRecord.EnteredInError {
+			visible = append(visible, healthRecord)
+		}
+	}
+
+	return visible
+}
+
 func checkIfHealthcareProfessionalHaveAccess(ctx contractapi.TransactionContextInterface, patientID, healthcareProfessionalID string) bool {
-	queryString := fmt.Sprintf(`{
-        "selector": {
-            "patientID": "%s",
-            "healthcareProfessionalID": "%s",
-            "resourceType": 2,
-            "expirationDate": {
-                "$gt": %d
-            }
-        }
-    }`, patientID, healthcareProfessionalID, time.Now().Unix())
 
-	return checkIfAnyDataAlreadyExist(ctx, queryString)
+	accesses, err := getValidAccesses(ctx, patientID, healthcareProfessionalID)
+	if err != nil {
+		return false
+	}
+
+	return len(accesses) > 0
+}
+
+// getValidAccesses devolve os acessos ainda não expirados do profissional aos dados do paciente.
+func getValidAccesses(ctx contractapi.TransactionContextInterface, patientID, healthcareProfessionalID string) ([]Access, error) {
+
+	var accesses = []Access{}
+
+	now, err := getTxDate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanState(ctx, accessesByHealthcareProfessionalIndex,
+		[]string{"healthcareProfessionalID", healthcareProfessionalID, "patientID", patientID},
+		func(key string, value []byte) error {
+			var access Access
+			if err := json.Unmarshal(value, &access); err != nil {
+				return fmt.Errorf("error unmarshalling access: %v", err)
+			}
+
+			if access.ExpirationDate > now {
+				accesses = append(accesses, access)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return accesses, nil
+}
+
+// getHealthcareProfessionalSensitivityLabels devolve as etiquetas sensíveis que o paciente
+// incluiu nos acessos ainda válidos do profissional.
+func getHealthcareProfessionalSensitivityLabels(ctx contractapi.TransactionContextInterface, patientID, healthcareProfessionalID string) map[string]bool {
+
+	allowedLabels := map[string]bool{}
+
+	accesses, err := getValidAccesses(ctx, patientID, healthcareProfessionalID)
+	if err != nil {
+		return allowedLabels
+	}
+
+	for _, access := range accesses {
+		for _, label := range access.SensitivityLabels {
+			allowedLabels[label] = true
+		}
+	}
+
+	return allowedLabels
+}
+
+// isHealthRecordWithheld diz se o registo tem uma etiqueta sensível que o paciente não partilhou.
+func isHealthRecordWithheld(healthRecord HealthRecord, allowedLabels map[string]bool) bool {
+	return healthRecord.SensitivityLabel != "" && !allowedLabels[healthRecord.SensitivityLabel]
+}
+
+// withholdSensitiveHealthRecords retira os registos com etiquetas não autorizadas,
+// devolvendo apenas quantos foram retidos.
+func withholdSensitiveHealthRecords(healthRecords []HealthRecord, allowedLabels map[string]bool) ([]HealthRecord, int) {
+
+	var visible = []HealthRecord{}
+	withheld := 0
+
+	for _, healthRecord := range healthRecords {
+		if isHealthRecordWithheld(healthRecord, allowedLabels) {
+			withheld++
+			continue
+		}
+		visible = append(visible, healthRecord)
+	}
+
+	return visible, withheld
 }
 
 func checkIfHealthcareProfessionaRequestAlreadyExist(ctx contractapi.TransactionContextInterface, patientID, healthcareProfessionalID string) bool {
-	queryString := fmt.Sprintf(`{
-        "selector": {
-            "patientID": "%s",
-            "healthcareProfessionalID": "%s",
-            "resourceType": 1,
-			"status" : 0,
-            "expirationDate": {
-                "$gt": %d
-            }
-        }
-    }`, patientID, healthcareProfessionalID, time.Now().Unix())
-
-	return checkIfAnyDataAlreadyExist(ctx, queryString)
+
+	// Sem a data da transação não se sabe se o pedido expirou; conta como pendente.
+	now, err := getTxDate(ctx)
+	if err != nil {
+		return true
+	}
+
+	pendingRequest := false
+
+	err = scanState(ctx, "Requests", []string{"patientID", patientID, "healthcareProfessionalID", healthcareProfessionalID},
+		func(key string, value []byte) error {
+			var request Request
+			if err := json.Unmarshal(value, &request); err != nil {
+				return fmt.Errorf("error unmarshalling query result: %v", err)
+			}
+
+			if request.Status == 0 && request.ExpirationDate > now {
+				pendingRequest = true
+			}
+			return nil
+		})
+	if err != nil {
+		return false
+	}
+
+	return pendingRequest
+}
+
+// As variantes Audited são submetidas em vez de avaliadas e guardam no registo de acessos
+// que registos o profissional leu, com que finalidade e ao abrigo de que pedidos, antes de
+// devolver os dados. Sem acesso não há dados, por isso também não há registo.
+// As leituras avaliadas (GetPatientMedicalHistory, ...) continuam disponíveis mas não deixam
+// registo, por isso o gateway só usa estas.
+
+// checkProfessionalRead verifica que quem invoca é o profissional em nome de quem se lê.
+func checkProfessionalRead(ctx contractapi.TransactionContextInterface, healthcareProfessionalID string) error {
+
+	if !checkIfCallerIsHealthcareProfessional(ctx, healthcareProfessionalID) {
+		return accessDeniedError("only the healthcare professional can read the patient's data in their name")
+	}
+
+	return nil
+}
+
+// checkAuditedRead verifica também que a leitura tem finalidade.
+func checkAuditedRead(ctx contractapi.TransactionContextInterface, healthcareProfessionalID, purpose string) error {
+
+	if purpose == "" {
+		return invalidArgumentError("read purpose cannot be empty")
+	}
+
+	return checkProfessionalRead(ctx, healthcareProfessionalID)
+}
+
+func (c *HealthContract) GetPatientMedicalHistoryAudited(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID string, options MedicalHistoryQueryOptions,
+	pageSize int32, bookmark, purpose string) (*GetPatientMedicalHistoryResponse, error) {
+
+	if err := checkAuditedRead(ctx, healthcareProfessionalID, purpose); err != nil {
+		return nil, err
+	}
+
+	resp, err := getPatientMedicalHistory(ctx, scanStatePageForUpdate, patientID, healthcareProfessionalID, options, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.HealthcareProfessionalHasAccess {
+		err := storeAccessLogEntry(ctx, patientID, healthcareProfessionalID, "GetPatientMedicalHistory", purpose, healthRecordIDs(resp.HealthRecords))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+func (c *HealthContract) GetHealthRecordWithHealthcareProfessionalByIDAudited(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, recordID, purpose string) (*GetHealthRecordWithHealthcareProfessionalByIDResponse, error) {
+
+	if err := checkAuditedRead(ctx, healthcareProfessionalID, purpose); err != nil {
+		return nil, err
+	}
+
+	resp, err := getHealthRecordWithHealthcareProfessionalByID(ctx, patientID, healthcareProfessionalID, recordID)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.HealthcareProfessionalHasAccess {
+		recordIDs := []string{}
+		if resp.HealthRecord.RecordID != "" {
+			recordIDs = append(recordIDs, resp.HealthRecord.RecordID)
+		}
+
+		err := storeAccessLogEntry(ctx, patientID, healthcareProfessionalID, "GetHealthRecordWithHealthcareProfessionalByID", purpose, recordIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+func (c *HealthContract) GetPatientMedicalHistoryByCodeAudited(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, kind, code, purpose string) (*GetPatientMedicalHistoryResponse, error) {
+
+	if err := checkAuditedRead(ctx, healthcareProfessionalID, purpose); err != nil {
+		return nil, err
+	}
+
+	resp, err := getPatientMedicalHistoryByCode(ctx, patientID, healthcareProfessionalID, kind, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.HealthcareProfessionalHasAccess {
+		err := storeAccessLogEntry(ctx, patientID, healthcareProfessionalID, "GetPatientMedicalHistoryByCode", purpose, healthRecordIDs(resp.HealthRecords))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+func (c *HealthContract) GetPatientObservationSeriesAudited(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, code, purpose string) (*GetPatientObservationSeriesResponse, error) {
+
+	if err := checkAuditedRead(ctx, healthcareProfessionalID, purpose); err != nil {
+		return nil, err
+	}
+
+	resp, err := getPatientObservationSeries(ctx, patientID, healthcareProfessionalID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.HealthcareProfessionalHasAccess {
+		recordIDs := []string{}
+		seen := map[string]bool{}
+		for _, point := range resp.Observations {
+			if !seen[point.RecordID] {
+				seen[point.RecordID] = true
+				recordIDs = append(recordIDs, point.RecordID)
+			}
+		}
+
+		err := storeAccessLogEntry(ctx, patientID, healthcareProfessionalID, "GetPatientObservationSeries", purpose, recordIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+func (c *HealthContract) GetPatientHealthRecordGraphAudited(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, recordID, purpose string) (*GetPatientHealthRecordGraphResponse, error) {
+
+	if err := checkAuditedRead(ctx, healthcareProfessionalID, purpose); err != nil {
+		return nil, err
+	}
+
+	resp, err := getPatientHealthRecordGraph(ctx, patientID, healthcareProfessionalID, recordID)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.HealthcareProfessionalHasAccess {
+		err := storeAccessLogEntry(ctx, patientID, healthcareProfessionalID, "GetPatientHealthRecordGraph", purpose, healthRecordIDs(resp.Graph.HealthRecords))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+func (c *HealthContract) GetPatientEncountersAudited(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, purpose string) (*GetPatientEncountersResponse, error) {
+
+	if err := checkAuditedRead(ctx, healthcareProfessionalID, purpose); err != nil {
+		return nil, err
+	}
+
+	resp, err := getPatientEncounters(ctx, patientID, healthcareProfessionalID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A lista de episódios não tem registos, mas a leitura fica igualmente no registo de acessos.
+	if resp.HealthcareProfessionalHasAccess {
+		err := storeAccessLogEntry(ctx, patientID, healthcareProfessionalID, "GetPatientEncounters", purpose, []string{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+func (c *HealthContract) GetPatientEncounterRecordsAudited(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, encounterID, purpose string) (*GetPatientMedicalHistoryResponse, error) {
+
+	if err := checkAuditedRead(ctx, healthcareProfessionalID, purpose); err != nil {
+		return nil, err
+	}
+
+	resp, err := getPatientEncounterRecords(ctx, patientID, healthcareProfessionalID, encounterID)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.HealthcareProfessionalHasAccess {
+		err := storeAccessLogEntry(ctx, patientID, healthcareProfessionalID, "GetPatientEncounterRecords", purpose, healthRecordIDs(resp.HealthRecords))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+func healthRecordIDs(healthRecords []HealthRecord) []string {
+
+	var recordIDs = []string{}
+
+	for _, healthRecord := range healthRecords {
+		recordIDs = append(recordIDs, healthRecord.RecordID)
+	}
+
+	return recordIDs
+}
+
+// GetMyAccessLog devolve as leituras auditadas feitas pelo profissional, de todos os pacientes.
+func (c *HealthContract) GetMyAccessLog(ctx contractapi.TransactionContextInterface,
+	healthcareProfessionalID string, dateFrom, dateTo int64, pageSize int32, bookmark string) (*AccessLogPage, error) {
+
+	if !checkIfCallerIsHealthcareProfessional(ctx, healthcareProfessionalID) {
+		return nil, accessDeniedError("only the healthcare professional can read their access log")
+	}
+
+	page, err := getAccessLog(ctx, accessLogByHealthcareProfessionalIndex, []string{"healthcareProfessionalID", healthcareProfessionalID},
+		func(entry AccessLogEntry) bool { return true }, dateFrom, dateTo, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access log: %v", err)
+	}
+
+	return page, nil
 }
@@ -0,0 +1,62 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRequestPatientMedicalDataRejectsRequestIDOfAnotherProfessional(t *testing.T) {
+	ctx := newFakeContext()
+	contract := &HealthContract{}
+
+	ctx.callAs(map[string]string{"healthcareProfessionalID": "hp1"})
+	_, err := contract.RequestPatientMedicalData(ctx, "p1", "Teste", "Consulta", "hp1", "Dr. Apollo", "r1", ctx.stub.txDate+86400)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx.stub.txID = "tx2"
+	ctx.callAs(map[string]string{"healthcareProfessionalID": "hp2"})
+	_, err = contract.RequestPatientMedicalData(ctx, "p1", "Teste", "Consulta", "hp2", "Dr. MedTech", "r1", ctx.stub.txDate+86400)
+	if err == nil || !strings.Contains(err.Error(), errorPrefixConflict) {
+		t.Fatalf("expected a conflict for a request ID already used for the patient, got %v", err)
+	}
+
+	// O índice por paciente e pedido continua a apontar para o pedido do primeiro profissional.
+	requestKey, err := getRequestKeyByRequestID(ctx, "p1", "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firstRequestKey, _ := createRequestCompositeKey(ctx, "p1", "hp1", "r1")
+	if requestKey != firstRequestKey {
+		t.Errorf("request r1 now resolves to %q, expected the first professional's request", requestKey)
+	}
+
+	secondRequestKey, _ := createRequestCompositeKey(ctx, "p1", "hp2", "r1")
+	if _, found := ctx.stub.state[secondRequestKey]; found {
+		t.Error("the second professional's request was stored")
+	}
+}
+
+func TestRetractHealthRecordRejectsErasedRecord(t *testing.T) {
+	ctx := newFakeContext()
+
+	erased := HealthRecord{RecordID: "r1", PatientID: "p1", HealthCareProfessionalID: "hp1",
+		Observations: []Observation{}, Erased: true, ErasedDate: ctx.stub.txDate - 10}
+	erasedJSON, _ := json.Marshal(erased)
+
+	recordKey, _ := createPatientWalletCompositeKey(ctx, "p1", "r1")
+	ctx.stub.state[recordKey] = erasedJSON
+
+	ctx.callAs(map[string]string{"healthcareProfessionalID": "hp1"})
+	err := (&HealthContract{}).RetractHealthRecord(ctx, "p1", "r1", "hp1", "paciente errado")
+	if err == nil || !strings.HasPrefix(err.Error(), errorPrefixGone) {
+		t.Fatalf("expected gone for an erased record, got %v", err)
+	}
+
+	if string(ctx.stub.state[recordKey]) != string(erasedJSON) {
+		t.Error("the erased record was rewritten")
+	}
+}
@@ -3,14 +3,86 @@ package chaincode
 import (
 	"encoding/json"
 	"fmt"
-	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
-func (c *HealthContract) GetMedicalHistory(ctx contractapi.TransactionContextInterface, patientID string) ([]HealthRecord, error) {
+type AddPatientReportedRecordResponse struct {
+	HealthRecordAlreadyExist bool `json:"healthRecordAlreadyExist"`
+	InvalidRecordType        bool `json:"invalidRecordType"`
+	InvalidObservations      bool `json:"invalidObservations"`
+	HealthRecordAdded        bool `json:"healthRecordAdded"`
+}
+
+// GetMedicalHistory devolve uma página do histórico do paciente, filtrada e ordenada pelas options.
+func (c *HealthContract) GetMedicalHistory(ctx contractapi.TransactionContextInterface, patientID string,
+	options MedicalHistoryQueryOptions, pageSize int32, bookmark string) (*HealthRecordsPage, error) {
+
+	if !checkIfCallerIsPatient(ctx, patientID) {
+		return nil, accessDeniedError("only the patient can read their medical history")
+	}
+
+	page, err := getMedicalHistory(ctx, scanStatePage, patientID, options, nil, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get patient wallet: %v", err)
+	}
+
+	return page, nil
+}
+
+// GetMedicalHistoryByCode devolve os registos do paciente com o código indicado,
+// kind é "recordType" ou "speciality".
+func (c *HealthContract) GetMedicalHistoryByCode(ctx contractapi.TransactionContextInterface, patientID, kind, code string) ([]HealthRecord, error) {
+
+	if !checkIfCallerIsPatient(ctx, patientID) {
+		return nil, accessDeniedError("only the patient can read their medical history")
+	}
+
+	healthRecords, err := getMedicalHistoryByCode(ctx, patientID, kind, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get patient wallet: %v", err)
+	}
+
+	return healthRecords, nil
+}
+
+// GetObservationSeries devolve a série temporal de uma observação (ex.: HbA1c) do paciente,
+// sem os valores dos registos retirados por erro.
+func (c *HealthContract) GetObservationSeries(ctx contractapi.TransactionContextInterface, patientID, code string) ([]ObservationPoint, error) {
+
+	if !checkIfCallerIsPatient(ctx, patientID) {
+		return nil, accessDeniedError("only the patient can read their medical history")
+	}
+
+	healthRecords, err := getMedicalHistoryByObservationCode(ctx, patientID, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get patient wallet: %v", err)
+	}
+
+	return getObservationSeries(hideRetractedHealthRecords(healthRecords), code), nil
+}
+
+func (c *HealthContract) GetEncounters(ctx contractapi.TransactionContextInterface, patientID string) ([]Encounter, error) {
+
+	if !checkIfCallerIsPatient(ctx, patientID) {
+		return nil, accessDeniedError("only the patient can read their encounters")
+	}
+
+	encounters, err := getEncounters(ctx, patientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encounters: %v", err)
+	}
+
+	return encounters, nil
+}
 
-	healthRecords, err := getMedicalHistory(ctx, patientID)
+func (c *HealthContract) GetEncounterRecords(ctx contractapi.TransactionContextInterface, patientID, encounterID string) ([]HealthRecord, error) {
+
+	if !checkIfCallerIsPatient(ctx, patientID) {
+		return nil, accessDeniedError("only the patient can read their medical history")
+	}
+
+	healthRecords, err := getEncounterRecords(ctx, patientID, encounterID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get patient wallet: %v", err)
 	}
@@ -18,8 +90,22 @@ func (c *HealthContract) GetMedicalHistory(ctx contractapi.TransactionContextInt
 	return healthRecords, nil
 }
 
+// GetHealthRecordGraph devolve o grafo de registos ligados ao registo indicado.
+func (c *HealthContract) GetHealthRecordGraph(ctx contractapi.TransactionContextInterface, patientID, recordID string) (*HealthRecordGraph, error) {
+
+	if !checkIfCallerIsPatient(ctx, patientID) {
+		return nil, accessDeniedError("only the patient can read their medical history")
+	}
+
+	return getHealthRecordGraph(ctx, patientID, recordID, func(healthRecord HealthRecord) bool { return true })
+}
+
 func (c *HealthContract) GetHealthRecordWithPatientByID(ctx contractapi.TransactionContextInterface, patientID, recordID string) (*HealthRecord, error) {
 
+	if !checkIfCallerIsPatient(ctx, patientID) {
+		return nil, accessDeniedError("only the patient can read their medical history")
+	}
+
 	healthRecord, err := getHealthRecordByID(ctx, patientID, recordID)
 
 	if err != nil {
@@ -29,192 +115,642 @@ func (c *HealthContract) GetHealthRecordWithPatientByID(ctx contractapi.Transact
 	return healthRecord, nil
 }
 
-func (c *HealthContract) GetAccessesByPatientID(ctx contractapi.TransactionContextInterface, patientID string) ([]Access, error) {
+func (c *HealthContract) GetAccessesByPatientID(ctx contractapi.TransactionContextInterface, patientID string, pageSize int32, bookmark string) (*AccessesPage, error) {
 
-	var accesses = []Access{}
+	if !checkIfCallerIsPatient(ctx, patientID) {
+		return nil, accessDeniedError("only the patient can read their accesses")
+	}
+
+	page := AccessesPage{}
+	page.Accesses = []Access{}
 
-	// Construct the selector query to retrieve accesses by patientID
-	queryString := fmt.Sprintf(`{
-        "selector": {
-            "patientID": "%s",
-			"resourceType": 2
-        }
-    }`, patientID)
+	fetched, nextBookmark, err := scanStatePage(ctx, accessesByPatientIndex, []string{"patientID", patientID}, nil, pageSize, bookmark,
+		func(key string, value []byte) (bool, error) {
+			var access Access
+			if err := json.Unmarshal(value, &access); err != nil {
+				return false, fmt.Errorf("error unmarshalling access: %v", err)
+			}
 
-	// Execute the selector query
-	queryResultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+			page.Accesses = append(page.Accesses, access)
+			return true, nil
+		})
 	if err != nil {
-		return accesses, nil
+		return nil, err
 	}
-	defer queryResultsIterator.Close()
 
-	// Iterate through the query results
-	for queryResultsIterator.HasNext() {
-		queryResponse, err := queryResultsIterator.Next()
-		if err != nil {
-			return nil, fmt.Errorf("error retrieving next query result: %v", err)
-		}
+	page.FetchedRecordsCount = fetched
+	page.Bookmark = nextBookmark
 
-		// Deserialize the access from JSON
-		var access Access
-		err = json.Unmarshal(queryResponse.Value, &access)
-		if err != nil {
-			return nil, fmt.Errorf("error unmarshalling access: %v", err)
-		}
+	return &page, nil
+}
 
-		// Append the retrieved access to the slice
-		accesses = append(accesses, access)
+func (c *HealthContract) RemoveAccess(ctx contractapi.TransactionContextInterface, patientID, requestID string) error {
+
+	if !checkIfCallerIsPatient(ctx, patientID) {
+		return accessDeniedError("only the patient can remove an access to their data")
 	}
 
-	// Return the retrieved accesses
-	return accesses, nil
-}
+	compositeKey, err := getAccessKey(ctx, patientID, requestID)
+	if err != nil {
+		return err
+	}
 
-func (c *HealthContract) RemoveAccess(ctx contractapi.TransactionContextInterface, patientID, requestID string) error {
+	accessJSON, err := ctx.GetStub().GetState(compositeKey)
+	if err != nil {
+		return fmt.Errorf("failed to read access: %v", err)
+	}
 
-	queryString := fmt.Sprintf(`{
-        "selector": {
-			"requestID": "%s",
-			"patientID": "%s",
-			"resourceType": 2
-        }
-    }`, requestID, patientID)
+	if accessJSON == nil {
+		return notFoundError("access %s", requestID)
+	}
 
-	queryResultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	var access Access
+	err = json.Unmarshal(accessJSON, &access)
 	if err != nil {
-		return fmt.Errorf("no access found: %v", err)
+		return fmt.Errorf("error unmarshalling access: %v", err)
 	}
 
-	if queryResultsIterator.HasNext() {
-		queryResponse, err := queryResultsIterator.Next()
-		if err != nil {
-			return fmt.Errorf("error retrieving next query result: %v", err)
-		}
+	// Expiramos o acesso, simplificado por agora.
+	access.ExpirationDate, err = getTxDate(ctx)
+	if err != nil {
+		return err
+	}
+	access.RevokedDate = access.ExpirationDate
 
-		var access Access
-		err = json.Unmarshal(queryResponse.Value, &access)
-		if err != nil {
-			return fmt.Errorf("error unmarshalling access: %v", err)
-		}
+	updatedAccessJSON, err := json.Marshal(access)
+	if err != nil {
+		return fmt.Errorf("failed to marshal updated request: %v", err)
+	}
 
-		// Expiramos o acesso, simplificado por agora.
-		access.ExpirationDate = time.Now().Unix()
+	err = ctx.GetStub().PutState(compositeKey, updatedAccessJSON)
+	if err != nil {
+		return fmt.Errorf("failed to update request: %v", err)
+	}
 
-		updatedAccessJSON, err := json.Marshal(access)
-		if err != nil {
-			return fmt.Errorf("failed to marshal updated request: %v", err)
-		}
+	err = putAccessIndexKeys(ctx, access)
+	if err != nil {
+		return err
+	}
 
-		err = ctx.GetStub().PutState(queryResponse.Key, updatedAccessJSON)
-		if err != nil {
-			return fmt.Errorf("failed to update request: %v", err)
-		}
+	err = storeTransactionWriter(ctx)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return setHealthEvents(ctx, HealthEvent{
+		Type:                     EventAccessRevoked,
+		PatientID:                patientID,
+		HealthcareProfessionalID: access.HealthcareProfessionalID,
+		RequestID:                requestID,
+		ExpirationDate:           access.ExpirationDate,
+	})
 }
 
-func (c *HealthContract) GetRequestsWithPatient(ctx contractapi.TransactionContextInterface, patientID string) ([]Request, error) {
+func (c *HealthContract) GetRequestsWithPatient(ctx contractapi.TransactionContextInterface, patientID string, pageSize int32, bookmark string) (*RequestsPage, error) {
 
-	var requests = []Request{}
+	if !checkIfCallerIsPatient(ctx, patientID) {
+		return nil, accessDeniedError("only the patient can read their requests")
+	}
 
-	queryString := fmt.Sprintf(`{
-        "selector": {
-            "patientID": "%s",
-			"resourceType": 1,
-			"status" : 0,
-			"expirationDate": {
-                "$gt": %d
-            }
-        }
-    }`, patientID, time.Now().Unix())
+	page := RequestsPage{}
+	page.Requests = []Request{}
 
-	queryResultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	now, err := getTxDate(ctx)
 	if err != nil {
-		return requests, nil
+		return nil, err
 	}
 
-	defer queryResultsIterator.Close()
+	fetched, nextBookmark, err := scanStatePage(ctx, "Requests", []string{"patientID", patientID}, nil, pageSize, bookmark,
+		func(key string, value []byte) (bool, error) {
+			var request Request
+			if err := json.Unmarshal(value, &request); err != nil {
+				return false, fmt.Errorf("error unmarshalling query result: %v", err)
+			}
 
-	for queryResultsIterator.HasNext() {
-		queryResponse, err := queryResultsIterator.Next()
-		if err != nil {
-			return nil, fmt.Errorf("error retrieving next query result: %v", err)
-		}
+			if request.Status != 0 || request.ExpirationDate <= now || request.Erased {
+				return false, nil
+			}
 
-		var request Request
-		if err := json.Unmarshal(queryResponse.Value, &request); err != nil {
-			return nil, fmt.Errorf("error unmarshalling query result: %v", err)
-		}
-		requests = append(requests, request)
+			page.Requests = append(page.Requests, request)
+			return true, nil
+		})
+	if err != nil {
+		return nil, err
 	}
 
-	return requests, nil
+	page.FetchedRecordsCount = fetched
+	page.Bookmark = nextBookmark
+
+	return &page, nil
 }
 
-// AnswerRequest allows the patient to accept or deny the request for access to their data.
+// AnswerRequest allows the patient to accept (1) or deny (2) the request for access to their data.
+// Only pending requests that have not expired can be answered.
+// Records with a sensitivity label are only shared if the label is in sensitivityLabels.
 func (c *HealthContract) AnswerRequest(ctx contractapi.TransactionContextInterface,
-	response int, requestID, patientID string) error {
+	response int, requestID, patientID string, sensitivityLabels []string) error {
 
 	// Check parameter validity
 	if requestID == "" {
-		return fmt.Errorf("invalid request ID: %s", requestID)
+		return invalidArgumentError("invalid request ID: %s", requestID)
 	}
 
 	if patientID == "" {
-		return fmt.Errorf("social security number cannot be empty")
+		return invalidArgumentError("social security number cannot be empty")
 	}
 
-	queryString := fmt.Sprintf(`{
-        "selector": {
-            "patientID": "%s",
-			"requestID": "%s",
-			"resourceType": 1
-        }
-    }`, patientID, requestID)
+	if response != 1 && response != 2 {
+		return invalidArgumentError("invalid response: %d", response)
+	}
+
+	if !checkIfCallerIsPatient(ctx, patientID) {
+		return accessDeniedError("only the patient can answer the request")
+	}
 
-	// Execute the query
-	queryResultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	for _, label := range sensitivityLabels {
+		if !knownSensitivityLabels[label] {
+			return invalidArgumentError("invalid sensitivity label: %s", label)
+		}
+	}
+
+	requestKey, err := getRequestKeyByRequestID(ctx, patientID, requestID)
+	if err != nil {
+		return err
+	}
+
+	if requestKey == "" {
+		return notFoundError("request %s", requestID)
+	}
+
+	requestJSON, err := ctx.GetStub().GetState(requestKey)
+	if err != nil {
+		return fmt.Errorf("failed to read request from the ledger: %v", err)
+	}
+
+	if requestJSON == nil {
+		return notFoundError("request %s", requestID)
+	}
+
+	var request Request
+	err = json.Unmarshal(requestJSON, &request)
 	if err != nil {
-		return fmt.Errorf("failed to execute query: %v", err)
+		return fmt.Errorf("error unmarshalling query result: %v", err)
+	}
+
+	if request.Erased {
+		return goneError("request was erased: %s", requestID)
 	}
 
-	defer queryResultsIterator.Close()
+	if request.Status != 0 {
+		return conflictError("request already answered: %s", requestID)
+	}
+
+	now, err := getTxDate(ctx)
+	if err != nil {
+		return err
+	}
 
-	if queryResultsIterator.HasNext() {
-		queryResponse, err := queryResultsIterator.Next()
+	// Um pedido expirado já não aparece ao paciente, nem pode dar um acesso.
+	if request.ExpirationDate <= now {
+		return conflictError("request expired: %s", requestID)
+	}
+
+	request.Status = response
+	request.StatusChangedDate = now
+
+	updatedRequestJSON, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal updated request: %v", err)
+	}
+
+	// Update the request on the ledger
+	err = ctx.GetStub().PutState(requestKey, updatedRequestJSON)
+	if err != nil {
+		return fmt.Errorf("failed to update request: %v", err)
+	}
+
+	err = putRequestIndexKeys(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	err = storeTransactionWriter(ctx)
+	if err != nil {
+		return err
+	}
+
+	events := []HealthEvent{{
+		Type:                     EventRequestAnswered,
+		PatientID:                patientID,
+		HealthcareProfessionalID: request.HealthcareProfessionalID,
+		RequestID:                requestID,
+		Status:                   response,
+	}}
+
+	if response == 1 {
+		err := addAccess(ctx, requestID, patientID, request.PatientName,
+			request.HealthcareProfessionalID, request.HealthcareProfessional, request.ExpirationDate, sensitivityLabels)
 		if err != nil {
-			return fmt.Errorf("error retrieving next query result: %v", err)
+			return fmt.Errorf("failed to add access: %v", err)
 		}
 
-		var request Request
-		err = json.Unmarshal(queryResponse.Value, &request)
+		events = append(events, HealthEvent{
+			Type:                     EventAccessGranted,
+			PatientID:                patientID,
+			HealthcareProfessionalID: request.HealthcareProfessionalID,
+			RequestID:                requestID,
+			ExpirationDate:           request.ExpirationDate,
+		})
+	}
+
+	return setHealthEvents(ctx, events...)
+}
+
+// ErasePatientData responde a um pedido de apagamento (RGPD, art. 17.º).
+// A ledger não permite apagar dados, por isso purgamos a chave do paciente (ver
+// PatientKeys.go), o que torna ilegível o conteúdo clínico de todas as versões dos
+// registos, substituímos o estado atual dos registos, pedidos, acessos, episódios
+// e leituras do paciente por "tombstones" sem conteúdo, apagamos as preferências de
+// notificação, cortamos todos os acessos e guardamos um certificado do que foi feito.
+// Só o paciente ou um administrador o pode pedir.
+func (c *HealthContract) ErasePatientData(ctx contractapi.TransactionContextInterface, patientID, reason string) (*RedactionCertificate, error) {
+
+	if patientID == "" {
+		return nil, invalidArgumentError("social security number cannot be empty")
+	}
+
+	if !checkIfCallerIsPatient(ctx, patientID) && !checkIfCallerIsAdmin(ctx) {
+		return nil, accessDeniedError("only the patient or an admin can erase the patient's data")
+	}
+
+	erasedDate, err := getTxDate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	healthRecordKeys, err := eraseHealthRecords(ctx, patientID, erasedDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase health records: %v", err)
+	}
+
+	requestKeys, err := eraseRequests(ctx, patientID, erasedDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase requests: %v", err)
+	}
+
+	accessKeys, err := eraseAccesses(ctx, patientID, erasedDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase accesses: %v", err)
+	}
+
+	encounterKeys, err := eraseEncounters(ctx, patientID, erasedDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase encounters: %v", err)
+	}
+
+	accessLogKeys, err := eraseAccessLog(ctx, patientID, erasedDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase access log: %v", err)
+	}
+
+	notificationPreferencesKeys, err := eraseNotificationPreferences(ctx, patientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase notification preferences: %v", err)
+	}
+
+	keyDestroyed, err := purgePatientKey(ctx, patientID)
+	if err != nil {
+		return nil, err
+	}
+
+	var redactedKeys = []string{}
+	for _, keys := range [][]string{healthRecordKeys, requestKeys, accessKeys, encounterKeys, accessLogKeys, notificationPreferencesKeys} {
+		redactedKeys = append(redactedKeys, keys...)
+	}
+
+	certificate := RedactionCertificate{
+		ResourceType:                   4,
+		CertificateID:                  ctx.GetStub().GetTxID(),
+		PatientID:                      patientID,
+		Reason:                         reason,
+		RedactedDate:                   erasedDate,
+		HealthRecordsRedacted:          len(healthRecordKeys),
+		RequestsRedacted:               len(requestKeys),
+		AccessesRedacted:               len(accessKeys),
+		EncountersRedacted:             len(encounterKeys),
+		AccessLogEntriesRedacted:       len(accessLogKeys),
+		NotificationPreferencesDeleted: len(notificationPreferencesKeys) > 0,
+		RedactedKeysDigest:             digestKeys(redactedKeys),
+		KeyDestroyed:                   keyDestroyed,
+		HistoryRetained:                true,
+	}
+
+	compositeKey, err := createRedactionCertificateCompositeKey(ctx, patientID, certificate.CertificateID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	certificateJSON, err := json.Marshal(certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize redaction certificate to JSON: %v", err)
+	}
+
+	err = ctx.GetStub().PutState(compositeKey, certificateJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store redaction certificate on the ledger: %v", err)
+	}
+
+	err = setHealthEvents(ctx, HealthEvent{
+		Type:      EventPatientDataErased,
+		PatientID: patientID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &certificate, nil
+}
+
+// AddPatientReportedRecord permite ao paciente registar os seus próprios dados
+// (tensão arterial medida em casa, sintomas, medicação habitual...).
+// O registo fica marcado como escrito pelo paciente.
+func (c *HealthContract) AddPatientReportedRecord(ctx contractapi.TransactionContextInterface,
+	recordID, patientID, description, recordType string, eventDate int64, observations []Observation) (*AddPatientReportedRecordResponse, error) {
+
+	if patientID == "" {
+		return nil, invalidArgumentError("social security number cannot be empty")
+	}
+
+	if !checkIfCallerIsPatient(ctx, patientID) {
+		return nil, accessDeniedError("only the patient can add patient-reported records")
+	}
+
+	resp := AddPatientReportedRecordResponse{}
+	resp.HealthRecordAlreadyExist = checkIfHealthRecordAlreadyExist(ctx, recordID, patientID)
+
+	recordTypeCode := getActiveCode(ctx, CodeKindRecordType, recordType)
+	resp.InvalidRecordType = recordTypeCode == nil
+
+	normalizedObservations, validObservations := validateObservations(ctx, observations)
+	resp.InvalidObservations = !validObservations
+
+	if !resp.HealthRecordAlreadyExist && !resp.InvalidRecordType && !resp.InvalidObservations {
+		createdDate, err := getTxDate(ctx)
 		if err != nil {
-			return fmt.Errorf("error unmarshalling query result: %v", err)
+			return nil, err
 		}
 
-		request.Status = response
-		request.StatusChangedDate = time.Now().Unix()
+		newRecord := HealthRecord{
+			ResourceType:    3,
+			RecordID:        recordID,
+			PatientID:       patientID,
+			Description:     description,
+			CreatedDate:     createdDate,
+			EventDate:       eventDate,
+			RecordType:      recordTypeCode.Display,
+			RecordTypeCode:  recordTypeCode.Code,
+			PatientAuthored: true,
+			Observations:    normalizedObservations,
+			Links:           []HealthRecordLink{},
+			Disputes:        []HealthRecordDispute{},
+		}
 
-		updatedRequestJSON, err := json.Marshal(request)
+		err = storeHealthRecord(ctx, newRecord)
 		if err != nil {
-			return fmt.Errorf("failed to marshal updated request: %v", err)
+			return nil, err
 		}
 
-		// Update the request on the ledger
-		err = ctx.GetStub().PutState(queryResponse.Key, updatedRequestJSON)
+		err = setHealthEvents(ctx, HealthEvent{
+			Type:      EventHealthRecordAdded,
+			PatientID: patientID,
+			RecordID:  recordID,
+		})
 		if err != nil {
-			return fmt.Errorf("failed to update request: %v", err)
+			return nil, err
 		}
 
-		if response == 1 {
-			err := addAccess(ctx, requestID, patientID, request.PatientName,
-				request.HealthcareProfessionalID, request.HealthcareProfessional, request.ExpirationDate)
-			if err != nil {
-				return fmt.Errorf("failed to add access: %v", err)
-			}
-		}
+		resp.HealthRecordAdded = true
+	}
+
+	return &resp, nil
+}
+
+// DisputeHealthRecord junta uma nota de contestação do paciente a um registo de um profissional.
+// A nota fica no registo, por isso é vista por qualquer profissional que o leia depois.
+func (c *HealthContract) DisputeHealthRecord(ctx contractapi.TransactionContextInterface, patientID, recordID, note string) error {
+
+	if note == "" {
+		return invalidArgumentError("dispute note cannot be empty")
+	}
+
+	if !checkIfCallerIsPatient(ctx, patientID) {
+		return accessDeniedError("only the patient can dispute the health record")
+	}
+
+	healthRecord, err := getHealthRecordByID(ctx, patientID, recordID)
+	if err != nil {
+		return fmt.Errorf("erro ao obter o dado de saúde: %v", err)
+	}
+
+	if healthRecord.RecordID == "" {
+		return notFoundError("health record %s", recordID)
+	}
+
+	if healthRecord.PatientAuthored {
+		return invalidArgumentError("patient-authored records cannot be disputed")
+	}
+
+	if healthRecord.Erased {
+		return goneError("health record was erased: %s", recordID)
+	}
+
+	createdDate, err := getTxDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	healthRecord.Disputes = append(healthRecord.Disputes, HealthRecordDispute{
+		DisputeID:   ctx.GetStub().GetTxID(),
+		Note:        note,
+		CreatedDate: createdDate,
+	})
+
+	err = storeHealthRecord(ctx, *healthRecord)
+	if err != nil {
+		return err
+	}
+
+	// A nota pode ter dados clínicos, por isso não vai no evento.
+	return setHealthEvents(ctx, HealthEvent{
+		Type:                     EventHealthRecordDisputed,
+		PatientID:                patientID,
+		HealthcareProfessionalID: healthRecord.HealthCareProfessionalID,
+		RecordID:                 recordID,
+	})
+}
+
+// GetHealthRecordHistory devolve todas as versões do registo, com a transação, a data
+// e quem a submeteu (ex.: quando foi contestado, retirado ou apagado).
+// Só o paciente ou um auditor podem ver o histórico.
+func (c *HealthContract) GetHealthRecordHistory(ctx contractapi.TransactionContextInterface, patientID, recordID string) ([]HealthRecordVersion, error) {
+
+	if !checkIfCallerIsPatient(ctx, patientID) && !checkIfCallerIsAuditor(ctx) {
+		return nil, accessDeniedError("only the patient or an auditor can read the health record history")
+	}
+
+	versions, err := getHealthRecordHistory(ctx, patientID, recordID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get health record history: %v", err)
+	}
+
+	return versions, nil
+}
+
+// GetRequestHistory mostra quando o pedido foi criado e respondido, e por quem.
+func (c *HealthContract) GetRequestHistory(ctx contractapi.TransactionContextInterface, patientID, requestID string) ([]RequestVersion, error) {
+
+	if !checkIfCallerIsPatient(ctx, patientID) && !checkIfCallerIsAuditor(ctx) {
+		return nil, accessDeniedError("only the patient or an auditor can read the request history")
+	}
+
+	versions, err := getRequestHistory(ctx, patientID, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get request history: %v", err)
+	}
+
+	return versions, nil
+}
+
+// GetAccessHistory mostra quando o acesso foi dado e quando foi cortado pelo RemoveAccess.
+func (c *HealthContract) GetAccessHistory(ctx contractapi.TransactionContextInterface, patientID, requestID string) ([]AccessVersion, error) {
+
+	if !checkIfCallerIsPatient(ctx, patientID) && !checkIfCallerIsAuditor(ctx) {
+		return nil, accessDeniedError("only the patient or an auditor can read the access history")
+	}
+
+	versions, err := getAccessHistory(ctx, patientID, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access history: %v", err)
+	}
+
+	return versions, nil
+}
+
+// GetAccessLogByPatientID mostra ao paciente quem leu os seus dados, que registos e ao abrigo
+// de que pedidos, para detetar abusos e cortar o acesso com o RemoveAccess.
+// healthcareProfessionalID e as datas são filtros opcionais (vazio/zero não filtra).
+func (c *HealthContract) GetAccessLogByPatientID(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID string, dateFrom, dateTo int64, pageSize int32, bookmark string) (*AccessLogPage, error) {
+
+	if !checkIfCallerIsPatient(ctx, patientID) && !checkIfCallerIsAuditor(ctx) {
+		return nil, accessDeniedError("only the patient or an auditor can read the access log")
+	}
+
+	page, err := getAccessLog(ctx, "AccessLog", []string{"patientID", patientID}, func(entry AccessLogEntry) bool {
+		return healthcareProfessionalID == "" || entry.HealthcareProfessionalID == healthcareProfessionalID
+	}, dateFrom, dateTo, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access log: %v", err)
+	}
+
+	return page, nil
+}
+
+// SetNotificationPreferences guarda como o paciente quer ser avisado dos pedidos e dos
+// acessos que estão a terminar. Substitui as preferências anteriores.
+func (c *HealthContract) SetNotificationPreferences(ctx contractapi.TransactionContextInterface,
+	patientID string, channels []string, quietHoursStart, quietHoursEnd string, eventTypes []string, language string) error {
+
+	if patientID == "" {
+		return invalidArgumentError("social security number cannot be empty")
+	}
+
+	if !checkIfCallerIsPatient(ctx, patientID) && !checkIfCallerIsAdmin(ctx) {
+		return accessDeniedError("only the patient or an admin can set the patient's notification preferences")
+	}
+
+	if channels == nil {
+		channels = []string{}
+	}
+
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+
+	updatedDate, err := getTxDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	preferences := NotificationPreferences{
+		ResourceType:    10,
+		PatientID:       patientID,
+		Channels:        channels,
+		QuietHoursStart: quietHoursStart,
+		QuietHoursEnd:   quietHoursEnd,
+		EventTypes:      eventTypes,
+		Language:        language,
+		UpdatedDate:     updatedDate,
+	}
+
+	if err := validateNotificationPreferences(preferences); err != nil {
+		return err
+	}
+
+	compositeKey, err := createNotificationPreferencesCompositeKey(ctx, patientID)
+	if err != nil {
+		return err
+	}
+
+	preferencesJSON, err := json.Marshal(preferences)
+	if err != nil {
+		return fmt.Errorf("failed to serialize notification preferences to JSON: %v", err)
+	}
+
+	err = ctx.GetStub().PutState(compositeKey, preferencesJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store notification preferences on the ledger: %v", err)
+	}
+
+	err = storeTransactionWriter(ctx)
+	if err != nil {
+		return err
+	}
+
+	return setHealthEvents(ctx, HealthEvent{
+		Type:      EventNotificationPreferencesChanged,
+		PatientID: patientID,
+	})
+}
+
+// GetNotificationPreferences devolve as preferências do paciente, ou preferências vazias
+// (sem patientID) se nunca as definiu. Só as leem o paciente, um administrador ou o serviço
+// de notificações (atributo role=notifier), porque mostram como e quando o paciente quer ser contactado.
+func (c *HealthContract) GetNotificationPreferences(ctx contractapi.TransactionContextInterface, patientID string) (*NotificationPreferences, error) {
+
+	if !checkIfCallerIsPatient(ctx, patientID) && !checkIfCallerIsAdmin(ctx) && !checkIfCallerIsNotifier(ctx) {
+		return nil, accessDeniedError("only the patient, an admin or the notification service can read the notification preferences")
+	}
+
+	preferences := NotificationPreferences{Channels: []string{}, EventTypes: []string{}}
+
+	compositeKey, err := createNotificationPreferencesCompositeKey(ctx, patientID)
+	if err != nil {
+		return nil, err
+	}
+
+	preferencesJSON, err := ctx.GetStub().GetState(compositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notification preferences from the ledger: %v", err)
+	}
+
+	if preferencesJSON == nil {
+		return &preferences, nil
+	}
+
+	if err := json.Unmarshal(preferencesJSON, &preferences); err != nil {
+		return nil, fmt.Errorf("error unmarshalling notification preferences: %v", err)
 	}
 
-	return nil
+	return &preferences, nil
 }
@@ -0,0 +1,188 @@
+package chaincode
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func isAccessDenied(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), errorPrefixAccessDenied)
+}
+
+func TestPatientDataCallerAuthorization(t *testing.T) {
+	callers := map[string]map[string]string{
+		"patient":       {"patientID": "p1"},
+		"other patient": {"patientID": "p2"},
+		"admin":         {"role": "admin"},
+		"auditor":       {"role": "auditor"},
+		"notifier":      {"role": "notifier"},
+		"professional":  {"healthcareProfessionalID": "hp1"},
+		"no attributes": {},
+	}
+
+	tests := []struct {
+		transaction string
+		invoke      func(ctx *fakeContext) error
+		allowed     []string
+	}{
+		{
+			transaction: "GetNotificationPreferences",
+			invoke: func(ctx *fakeContext) error {
+				_, err := (&HealthContract{}).GetNotificationPreferences(ctx, "p1")
+				return err
+			},
+			allowed: []string{"patient", "admin", "notifier"},
+		},
+		{
+			transaction: "ErasePatientData",
+			invoke: func(ctx *fakeContext) error {
+				_, err := (&HealthContract{}).ErasePatientData(ctx, "p1", "pedido do paciente")
+				return err
+			},
+			allowed: []string{"patient", "admin"},
+		},
+	}
+
+	for _, test := range tests {
+		for caller, attributes := range callers {
+			t.Run(test.transaction+"/"+caller, func(t *testing.T) {
+				ctx := newFakeContext()
+				ctx.callAs(attributes)
+
+				allowed := false
+				for _, allowedCaller := range test.allowed {
+					allowed = allowed || allowedCaller == caller
+				}
+
+				err := test.invoke(ctx)
+				if allowed && err != nil {
+					t.Errorf("expected %s to be allowed, got %v", caller, err)
+				}
+				if !allowed && !isAccessDenied(err) {
+					t.Errorf("expected access denied for %s, got %v", caller, err)
+				}
+			})
+		}
+	}
+}
+
+func TestErasePatientDataDestroysPatientKey(t *testing.T) {
+	ctx := newFakeContext()
+	ctx.stub.transient[patientKeyTransientField] = bytes.Repeat([]byte{7}, patientKeySize)
+
+	healthRecord := HealthRecord{
+		RecordID:     "r1",
+		PatientID:    "p1",
+		Description:  "Fratura do tornozelo",
+		Observations: []Observation{{Code: "8480-6", Value: 120, Unit: "mm[Hg]"}},
+		CreatedDate:  ctx.stub.txDate,
+		EventDate:    ctx.stub.txDate,
+	}
+	if err := storeHealthRecord(ctx, healthRecord); err != nil {
+		t.Fatal(err)
+	}
+
+	preferencesKey, _ := createNotificationPreferencesCompositeKey(ctx, "p1")
+	ctx.stub.state[preferencesKey] = []byte(`{"patientID":"p1","channels":["email"]}`)
+
+	recordKey, _ := createPatientWalletCompositeKey(ctx, "p1", "r1")
+	sealedJSON := ctx.stub.state[recordKey]
+
+	if bytes.Contains(sealedJSON, []byte("tornozelo")) {
+		t.Fatalf("health record content stored in clear: %s", sealedJSON)
+	}
+
+	// Antes do apagamento o conteúdo é decifrado com a chave do paciente.
+	stored, err := unmarshalHealthRecord(ctx, sealedJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Description != healthRecord.Description || len(stored.Observations) != 1 {
+		t.Fatalf("expected the decrypted content, got %+v", stored)
+	}
+
+	ctx.stub.txID = "tx2"
+	ctx.stub.txDate++
+	ctx.callAs(map[string]string{"patientID": "p1"})
+
+	certificate, err := (&HealthContract{}).ErasePatientData(ctx, "p1", "pedido do paciente")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !certificate.KeyDestroyed || certificate.HealthRecordsRedacted != 1 || !certificate.NotificationPreferencesDeleted {
+		t.Errorf("unexpected redaction certificate: %+v", certificate)
+	}
+
+	if patientKey, _ := getPatientKey(ctx, "p1"); patientKey != nil {
+		t.Error("patient key still stored after erasure")
+	}
+
+	if _, found := ctx.stub.state[preferencesKey]; found {
+		t.Error("notification preferences still stored after erasure")
+	}
+
+	var erased HealthRecord
+	if err := json.Unmarshal(ctx.stub.state[recordKey], &erased); err != nil {
+		t.Fatal(err)
+	}
+	if !erased.Erased || erased.Description != "" || erased.EncryptedContent != "" || len(erased.Observations) != 0 {
+		t.Errorf("expected an erased tombstone, got %+v", erased)
+	}
+
+	// A versão anterior continua no histórico da chave, mas já não pode ser decifrada.
+	previous, err := unmarshalHealthRecord(ctx, sealedJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous.Description != "" || len(previous.Observations) != 0 || previous.EncryptedContent == "" {
+		t.Errorf("expected the previous version without content, got %+v", previous)
+	}
+}
+
+func TestRemoveAccessStoredUnderLegacyKey(t *testing.T) {
+	ctx := newFakeContext()
+
+	access := Access{RequestID: "r1", PatientID: "p1", HealthcareProfessionalID: "hp1",
+		CreatedDate: ctx.stub.txDate - 100, ExpirationDate: ctx.stub.txDate + 86400}
+	accessJSON, _ := json.Marshal(access)
+
+	// Um acesso de outro paciente com o mesmo pedido, já na chave nova, não pode ser confundido.
+	otherAccess := access
+	otherAccess.PatientID = "p2"
+	otherAccessJSON, _ := json.Marshal(otherAccess)
+
+	legacyKey, _ := createLegacyAccessCompositeKey(ctx, "r1")
+	otherKey, _ := createAcessesCompositeKey(ctx, "p2", "r1")
+	ctx.stub.state[legacyKey] = accessJSON
+	ctx.stub.state[otherKey] = otherAccessJSON
+
+	ctx.callAs(map[string]string{"patientID": "p1"})
+	if err := (&HealthContract{}).RemoveAccess(ctx, "p1", "r1"); err != nil {
+		t.Fatal(err)
+	}
+
+	var removed Access
+	if err := json.Unmarshal(ctx.stub.state[legacyKey], &removed); err != nil {
+		t.Fatal(err)
+	}
+	if removed.ExpirationDate != ctx.stub.txDate || removed.RevokedDate != ctx.stub.txDate {
+		t.Errorf("expected the legacy access to be revoked, got %+v", removed)
+	}
+
+	if string(ctx.stub.state[otherKey]) != string(otherAccessJSON) {
+		t.Error("the other patient's access was changed")
+	}
+
+	newKey, _ := createAcessesCompositeKey(ctx, "p1", "r1")
+	if _, found := ctx.stub.state[newKey]; found {
+		t.Error("the access was copied to the new key instead of updated in place")
+	}
+
+	byPatient, _ := createAccessByPatientCompositeKey(ctx, "p1", "r1")
+	if string(ctx.stub.state[byPatient]) != legacyKey {
+		t.Errorf("the patient index points to %q, expected the legacy key", ctx.stub.state[byPatient])
+	}
+}
@@ -0,0 +1,120 @@
+package chaincode
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+)
+
+// signedHealthRecordContent é o conteúdo clínico assinado pelo profissional.
+// A ordem dos campos é fixa, por isso json.Marshal dá sempre a mesma serialização.
+type signedHealthRecordContent struct {
+	RecordID                 string                     `json:"recordID"`
+	PatientID                string                     `json:"patientID"`
+	Description              string                     `json:"description"`
+	HealthCareProfessionalID string                     `json:"healthCareProfessionalID"`
+	EventDate                int64                      `json:"eventDate"`
+	SpecialityCode           string                     `json:"specialityCode"`
+	RecordTypeCode           string                     `json:"recordTypeCode"`
+	Organization             string                     `json:"organization"`
+	SensitivityLabel         string                     `json:"sensitivityLabel"`
+	EncounterID              string                     `json:"encounterID"`
+	Links                    []HealthRecordLink         `json:"links"`
+	Observations             []signedObservationContent `json:"observations"`
+}
+
+// As observações são assinadas já normalizadas (na unidade do código e com a flag de anormal
+// calculada quando não vem preenchida), tal como ficam guardadas. O Display vem do registo
+// de terminologia, por isso não faz parte do conteúdo assinado.
+type signedObservationContent struct {
+	Code          string  `json:"code"`
+	Value         float64 `json:"value"`
+	Unit          string  `json:"unit"`
+	ReferenceLow  float64 `json:"referenceLow"`
+	ReferenceHigh float64 `json:"referenceHigh"`
+	AbnormalFlag  string  `json:"abnormalFlag"`
+}
+
+// canonicalHealthRecordJSON devolve a serialização canónica sobre a qual é feita a assinatura.
+func canonicalHealthRecordJSON(healthRecord HealthRecord) ([]byte, error) {
+
+	links := healthRecord.Links
+	if links == nil {
+		links = []HealthRecordLink{}
+	}
+
+	observations := []signedObservationContent{}
+	for _, observation := range healthRecord.Observations {
+		observations = append(observations, signedObservationContent{
+			Code:          observation.Code,
+			Value:         observation.Value,
+			Unit:          observation.Unit,
+			ReferenceLow:  observation.ReferenceLow,
+			ReferenceHigh: observation.ReferenceHigh,
+			AbnormalFlag:  observation.AbnormalFlag,
+		})
+	}
+
+	return json.Marshal(signedHealthRecordContent{
+		RecordID:                 healthRecord.RecordID,
+		PatientID:                healthRecord.PatientID,
+		Description:              healthRecord.Description,
+		HealthCareProfessionalID: healthRecord.HealthCareProfessionalID,
+		EventDate:                healthRecord.EventDate,
+		SpecialityCode:           healthRecord.SpecialityCode,
+		RecordTypeCode:           healthRecord.RecordTypeCode,
+		Organization:             healthRecord.Organization,
+		SensitivityLabel:         healthRecord.SensitivityLabel,
+		EncounterID:              healthRecord.EncounterID,
+		Links:                    links,
+		Observations:             observations,
+	})
+}
+
+// verifyHealthRecordSignature valida a assinatura (base64) do registo com o certificado PEM do profissional.
+// O gateway assina sempre o SHA-256 do conteúdo canónico; com Ed25519 esse digest é a
+// mensagem assinada, por isso é também o digest que se verifica.
+func verifyHealthRecordSignature(healthRecord HealthRecord, signature, certificatePEM string) error {
+
+	block, _ := pem.Decode([]byte(certificatePEM))
+	if block == nil {
+		return fmt.Errorf("invalid certificate PEM")
+	}
+
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+
+	content, err := canonicalHealthRecordJSON(healthRecord)
+	if err != nil {
+		return fmt.Errorf("failed to serialize health record: %v", err)
+	}
+
+	digest := sha256.Sum256(content)
+
+	switch publicKey := certificate.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(publicKey, digest[:], signatureBytes) {
+			return fmt.Errorf("invalid signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(publicKey, digest[:], signatureBytes) {
+			return fmt.Errorf("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type: %T", publicKey)
+	}
+
+	return nil
+}
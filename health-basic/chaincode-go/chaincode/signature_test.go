@@ -0,0 +1,184 @@
+package chaincode
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// signer gera a chave e o certificado autoassinado de um profissional e assina como o gateway:
+// o SHA-256 do conteúdo canónico.
+type signer struct {
+	certificatePEM string
+	sign           func(digest []byte) []byte
+}
+
+func newSigner(t *testing.T, keyType string) signer {
+	t.Helper()
+
+	var key crypto.Signer
+	var sign func(digest []byte) []byte
+
+	switch keyType {
+	case "ecdsa":
+		ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key = ecdsaKey
+		sign = func(digest []byte) []byte {
+			signature, err := ecdsa.SignASN1(rand.Reader, ecdsaKey, digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signature
+		}
+	case "ed25519":
+		_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key = ed25519Key
+		sign = func(digest []byte) []byte {
+			return ed25519.Sign(ed25519Key, digest)
+		}
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "hp1"},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(1<<32, 0),
+	}
+
+	certificateDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer{
+		certificatePEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateDER})),
+		sign:           sign,
+	}
+}
+
+func (s signer) signHealthRecord(t *testing.T, healthRecord HealthRecord) string {
+	t.Helper()
+
+	content, err := canonicalHealthRecordJSON(healthRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256(content)
+	return base64.StdEncoding.EncodeToString(s.sign(digest[:]))
+}
+
+func signedTestRecord() HealthRecord {
+	return HealthRecord{
+		RecordID:                 "r1",
+		PatientID:                "p1",
+		Description:              "Fratura do tornozelo",
+		HealthCareProfessionalID: "hp1",
+		EventDate:                1700000000,
+		Observations: []Observation{
+			{Code: "8480-6", Display: "Systolic blood pressure", Value: 120, Unit: "mm[Hg]", ReferenceLow: 90, ReferenceHigh: 140, AbnormalFlag: "N"},
+		},
+	}
+}
+
+func TestCanonicalHealthRecordJSON(t *testing.T) {
+	healthRecord := HealthRecord{
+		RecordID:                 "r1",
+		PatientID:                "p1",
+		Description:              "d",
+		HealthCareProfessionalID: "hp1",
+		HealthCareProfessional:   "Dr. Apollo",
+		CreatedDate:              5,
+		EventDate:                100,
+		Speciality:               "Ortopedia",
+		Observations:             []Observation{{Code: "c", Display: "Peso", Value: 1.5, Unit: "kg"}},
+	}
+
+	// Os nomes, a data de criação e o Display não são assinados; as listas vazias ficam [].
+	expected := `{"recordID":"r1","patientID":"p1","description":"d","healthCareProfessionalID":"hp1",` +
+		`"eventDate":100,"specialityCode":"","recordTypeCode":"","organization":"","sensitivityLabel":"",` +
+		`"encounterID":"","links":[],"observations":[{"code":"c","value":1.5,"unit":"kg",` +
+		`"referenceLow":0,"referenceHigh":0,"abnormalFlag":""}]}`
+
+	content, err := canonicalHealthRecordJSON(healthRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != expected {
+		t.Errorf("canonical JSON:\n got %s\nwant %s", content, expected)
+	}
+}
+
+func TestVerifyHealthRecordSignature(t *testing.T) {
+	ecdsaSigner := newSigner(t, "ecdsa")
+	ed25519Signer := newSigner(t, "ed25519")
+	otherSigner := newSigner(t, "ecdsa")
+
+	tests := []struct {
+		name string
+		// change altera o registo depois de assinado, antes de ser verificado.
+		change         func(healthRecord *HealthRecord)
+		signer         signer
+		certificatePEM string
+		signature      string
+		valid          bool
+	}{
+		{name: "ecdsa", signer: ecdsaSigner, valid: true},
+		{name: "ed25519", signer: ed25519Signer, valid: true},
+		{name: "display is not signed", signer: ecdsaSigner, valid: true,
+			change: func(healthRecord *HealthRecord) { healthRecord.Observations[0].Display = "PA sistólica" }},
+		{name: "nil and empty links are the same", signer: ecdsaSigner, valid: true,
+			change: func(healthRecord *HealthRecord) { healthRecord.Links = []HealthRecordLink{} }},
+		{name: "changed description", signer: ecdsaSigner,
+			change: func(healthRecord *HealthRecord) { healthRecord.Description = "Entorse do tornozelo" }},
+		{name: "changed observation value", signer: ed25519Signer,
+			change: func(healthRecord *HealthRecord) { healthRecord.Observations[0].Value = 150 }},
+		{name: "changed abnormal flag", signer: ecdsaSigner,
+			change: func(healthRecord *HealthRecord) { healthRecord.Observations[0].AbnormalFlag = "H" }},
+		{name: "other professional's certificate", signer: ecdsaSigner, certificatePEM: otherSigner.certificatePEM},
+		{name: "signature not in base64", signer: ecdsaSigner, signature: "not base64!"},
+		{name: "certificate not in PEM", signer: ecdsaSigner, certificatePEM: "not a certificate"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			healthRecord := signedTestRecord()
+			signature := test.signer.signHealthRecord(t, healthRecord)
+
+			if test.change != nil {
+				test.change(&healthRecord)
+			}
+			if test.signature != "" {
+				signature = test.signature
+			}
+			certificatePEM := test.signer.certificatePEM
+			if test.certificatePEM != "" {
+				certificatePEM = test.certificatePEM
+			}
+
+			err := verifyHealthRecordSignature(healthRecord, signature, certificatePEM)
+			if test.valid && err != nil {
+				t.Errorf("expected a valid signature, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected an invalid signature")
+			}
+		})
+	}
+}
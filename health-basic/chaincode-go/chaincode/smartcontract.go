@@ -1,9 +1,11 @@
 package chaincode
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"time"
+	"sort"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
@@ -12,83 +14,124 @@ type HealthContract struct {
 	contractapi.Contract
 }
 
-func getMedicalHistory(ctx contractapi.TransactionContextInterface, patientID string) ([]HealthRecord, error) {
+// getMedicalHistory devolve uma página dos registos do paciente aceites por visible (nil aceita
+// todos) e pelas options. Só os registos aceites contam para a página e para o FetchedRecordsCount.
+func getMedicalHistory(ctx contractapi.TransactionContextInterface, scan statePageScanner, patientID string,
+	options MedicalHistoryQueryOptions, visible func(healthRecord HealthRecord) bool,
+	pageSize int32, bookmark string) (*HealthRecordsPage, error) {
 
-	var healthRecords = []HealthRecord{}
+	page := HealthRecordsPage{}
+	page.HealthRecords = []HealthRecord{}
 
-	// Injeto o ID da wallet e assim é mais rápido.
-	queryString := fmt.Sprintf(`{
-        "selector": {
-			"resourceType": 3,
-			"patientID": "%s"
-        }
-    }`, patientID)
+	index, err := medicalHistoryIndex(options)
+	if err != nil {
+		return nil, err
+	}
 
-	queryResultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	keyRange, err := medicalHistoryKeyRange(ctx, index, patientID, options)
+	if err != nil {
+		return nil, err
+	}
 
-	// Aqui não posso dar erro, tenho de fazer desta maneira
+	fetched, nextBookmark, err := scan(ctx, index, []string{"patientID", patientID}, keyRange, pageSize, bookmark,
+		func(key string, value []byte) (bool, error) {
+			healthRecord, err := unmarshalHealthRecord(ctx, value)
+			if err != nil {
+				return false, err
+			}
+
+			// Primeiro o que quem lê não pode ver, para os filtros não dizerem nada sobre isso.
+			if visible != nil && !visible(healthRecord) {
+				return false, nil
+			}
+
+			if !matchesMedicalHistoryOptions(healthRecord, options) {
+				return false, nil
+			}
+
+			page.HealthRecords = append(page.HealthRecords, healthRecord)
+			return true, nil
+		})
 	if err != nil {
-		return healthRecords, nil
+		return nil, fmt.Errorf("erro ao obter os dados do paciente: %v", err)
 	}
 
-	defer queryResultsIterator.Close()
+	page.FetchedRecordsCount = fetched
+	page.Bookmark = nextBookmark
 
-	for queryResultsIterator.HasNext() {
-		queryResponse, err := queryResultsIterator.Next()
+	return &page, nil
+}
 
-		var healthRecord HealthRecord
+func getHealthRecordByID(ctx contractapi.TransactionContextInterface, patientID, recordID string) (*HealthRecord, error) {
 
-		if err != nil {
-			return nil, fmt.Errorf("erro ao obter os dados do paciente: %v", err)
-		}
+	compositeKey, err := createPatientWalletCompositeKey(ctx, patientID, recordID)
+	if err != nil {
+		return nil, err
+	}
 
-		if err := json.Unmarshal(queryResponse.Value, &healthRecord); err != nil {
-			return nil, fmt.Errorf("erro ao transformar os dados na wallet: %v", err)
-		}
+	healthRecordJSON, err := ctx.GetStub().GetState(compositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao obter os dados do paciente: %v", err)
+	}
+
+	var healthRecord HealthRecord
 
-		healthRecords = append(healthRecords, healthRecord)
+	if healthRecordJSON != nil {
+		healthRecord, err = unmarshalHealthRecord(ctx, healthRecordJSON)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return healthRecords, nil
+	return &healthRecord, nil
 }
 
-func getHealthRecordByID(ctx contractapi.TransactionContextInterface, patientID, recordID string) (*HealthRecord, error) {
+func storeHealthRecord(ctx contractapi.TransactionContextInterface, healthRecord HealthRecord) error {
 
-	queryString := fmt.Sprintf(`{
-        "selector": {
-            "_id": "\u0000%s\u0000%s\u0000%s\u0000%s\u0000%s\u0000"
-        }
-    }`, "HealthRecords", "patientID", patientID, "recordID", recordID)
+	compositeKey, err := createPatientWalletCompositeKey(ctx, healthRecord.PatientID, healthRecord.RecordID)
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
 
-	queryResultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	sealedRecord, err := sealHealthRecord(ctx, healthRecord)
+	if err != nil {
+		return err
+	}
 
-	// Aqui não posso dar erro, tenho de fazer desta maneira
+	healthRecordJSON, err := json.Marshal(sealedRecord)
 	if err != nil {
-		return &HealthRecord{}, nil
+		return fmt.Errorf("failed to serialize health record to JSON: %v", err)
 	}
 
-	defer queryResultsIterator.Close()
+	err = ctx.GetStub().PutState(compositeKey, healthRecordJSON)
+	if err != nil {
+		return fmt.Errorf("failed to update health records: %v", err)
+	}
 
-	var healthRecord HealthRecord
+	indexKeys, err := healthRecordIndexKeys(ctx, healthRecord)
+	if err != nil {
+		return err
+	}
 
-	if queryResultsIterator.HasNext() {
-		queryResponse, err := queryResultsIterator.Next()
+	err = putIndexKeys(ctx, compositeKey, indexKeys)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			return nil, fmt.Errorf("erro ao obter os dados do paciente: %v", err)
-		}
+	return storeTransactionWriter(ctx)
+}
 
-		if err := json.Unmarshal(queryResponse.Value, &healthRecord); err != nil {
-			return nil, fmt.Errorf("erro ao transformar os dados na wallet: %v", err)
-		}
-	} else {
-		healthRecord = HealthRecord{}
+func addAccess(ctx contractapi.TransactionContextInterface, requestID, patientID, patientName, healthcareProfessionalID, healthcareProfessional string, expirationDate int64, sensitivityLabels []string) error {
+
+	if sensitivityLabels == nil {
+		sensitivityLabels = []string{}
 	}
 
-	return &healthRecord, nil
-}
+	createdDate, err := getTxDate(ctx)
+	if err != nil {
+		return err
+	}
 
-func addAccess(ctx contractapi.TransactionContextInterface, requestID, patientID, patientName, healthcareProfessionalID, healthcareProfessional string, expirationDate int64) error {
 	// Create a new access based on the approved request
 	access := Access{
 		ResourceType:             2,
@@ -97,10 +140,17 @@ func addAccess(ctx contractapi.TransactionContextInterface, requestID, patientID
 		PatientName:              patientName,
 		HealthcareProfessionalID: healthcareProfessionalID,
 		HealthcareProfessional:   healthcareProfessional,
-		CreatedDate:              time.Now().Unix(),
+		CreatedDate:              createdDate,
 		ExpirationDate:           expirationDate, // or set the expiration date as needed
+		SensitivityLabels:        sensitivityLabels,
 	}
 
+	return storeAccess(ctx, access)
+}
+
+// storeAccess guarda um acesso novo. Falha se o paciente já tiver um acesso com o mesmo ID.
+func storeAccess(ctx contractapi.TransactionContextInterface, access Access) error {
+
 	// Serialize the access object to JSON
 	accessJSON, err := json.Marshal(access)
 	if err != nil {
@@ -108,9 +158,13 @@ func addAccess(ctx contractapi.TransactionContextInterface, requestID, patientID
 	}
 
 	// Generate composite key for the access
-	compositeKey, err := createAcessesCompositeKey(ctx, requestID)
+	compositeKey, err := getAccessKey(ctx, access.PatientID, access.RequestID)
 	if err != nil {
-		return fmt.Errorf("failed to create composite key for access: %v", err)
+		return err
+	}
+
+	if checkIfAnyDataAlreadyExist(ctx, compositeKey) {
+		return conflictError("access already exists: %s", access.RequestID)
 	}
 
 	// Store the serialized access on the ledger
@@ -119,7 +173,56 @@ func addAccess(ctx contractapi.TransactionContextInterface, requestID, patientID
 		return fmt.Errorf("failed to store access on the ledger: %v", err)
 	}
 
-	return nil
+	indexKeys, err := accessIndexKeys(ctx, access)
+	if err != nil {
+		return err
+	}
+
+	err = putIndexKeys(ctx, compositeKey, indexKeys)
+	if err != nil {
+		return err
+	}
+
+	return storeTransactionWriter(ctx)
+}
+
+// getAccessKey devolve a chave do acesso do paciente ao abrigo do pedido. Os acessos guardados
+// antes de a chave incluir o paciente ficam na chave antiga, só com o pedido, para não perderem
+// o histórico; o RebuildIndexes aponta-lhes os índices. Usa-se a chave antiga quando a nova não
+// existe e o acesso guardado na antiga é do paciente.
+func getAccessKey(ctx contractapi.TransactionContextInterface, patientID, requestID string) (string, error) {
+
+	compositeKey, err := createAcessesCompositeKey(ctx, patientID, requestID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key for access: %v", err)
+	}
+
+	if checkIfAnyDataAlreadyExist(ctx, compositeKey) {
+		return compositeKey, nil
+	}
+
+	legacyKey, err := createLegacyAccessCompositeKey(ctx, requestID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key for access: %v", err)
+	}
+
+	legacyAccessJSON, err := ctx.GetStub().GetState(legacyKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read access: %v", err)
+	}
+
+	if legacyAccessJSON != nil {
+		var access Access
+		if err := json.Unmarshal(legacyAccessJSON, &access); err != nil {
+			return "", fmt.Errorf("error unmarshalling access: %v", err)
+		}
+
+		if access.PatientID == patientID {
+			return legacyKey, nil
+		}
+	}
+
+	return compositeKey, nil
 }
 
 func storeRequest(ctx contractapi.TransactionContextInterface, request Request) error {
@@ -127,7 +230,7 @@ func storeRequest(ctx contractapi.TransactionContextInterface, request Request)
 	requestAlreadyExist := checkIfRequestAlreadyExist(ctx, request.PatientID, request.HealthcareProfessionalID, request.RequestID)
 
 	if requestAlreadyExist {
-		return fmt.Errorf("request already exist: %v")
+		return conflictError("request already exists: %s", request.RequestID)
 	}
 
 	requestJSON, err := json.Marshal(request)
@@ -146,42 +249,732 @@ func storeRequest(ctx contractapi.TransactionContextInterface, request Request)
 		return fmt.Errorf("failed to store request on the ledger: %v", err)
 	}
 
-	return nil
+	indexKeys, err := requestIndexKeys(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	err = putIndexKeys(ctx, compositeKey, indexKeys)
+	if err != nil {
+		return err
+	}
+
+	return storeTransactionWriter(ctx)
 }
 
+// checkIfRequestAlreadyExist procura o ID do pedido no índice por paciente e pedido, porque o
+// AnswerRequest e o RemoveAccess encontram o pedido só por esses dois: outro profissional não
+// pode reutilizar o ID de um pedido do mesmo paciente. A chave primária cobre os pedidos
+// guardados antes de o RebuildIndexes criar o índice.
 func checkIfRequestAlreadyExist(ctx contractapi.TransactionContextInterface, patientID, healthcareProfessionalID, requestID string) bool {
 
-	queryString := fmt.Sprintf(`{
-        "selector": {
-            "healthcareProfessionalID": "%s",
-			"resourceType": 1,
-			"status" : 0,
-			"patientID" : %s,
-			"requestID" : %s
-        }
-    }`, patientID, healthcareProfessionalID, requestID)
+	indexKey, err := createRequestByRequestIDCompositeKey(ctx, patientID, requestID)
+	if err != nil {
+		return false
+	}
+
+	if checkIfAnyDataAlreadyExist(ctx, indexKey) {
+		return true
+	}
+
+	compositeKey, err := createRequestCompositeKey(ctx, patientID, healthcareProfessionalID, requestID)
+	if err != nil {
+		return false
+	}
 
-	return checkIfAnyDataAlreadyExist(ctx, queryString)
+	return checkIfAnyDataAlreadyExist(ctx, compositeKey)
 }
 
 func checkIfHealthRecordAlreadyExist(ctx contractapi.TransactionContextInterface, recordID, patientID string) bool {
 
-	queryString := fmt.Sprintf(`{
-        "selector": {
-            "_id": "\u0000%s\u0000%s\u0000%s\u0000%s\u0000"
-        }
-    }`, "HealthRecords", "patientID", patientID, recordID)
+	compositeKey, err := createPatientWalletCompositeKey(ctx, patientID, recordID)
+	if err != nil {
+		return false
+	}
+
+	return checkIfAnyDataAlreadyExist(ctx, compositeKey)
+}
+
+func checkIfAnyDataAlreadyExist(ctx contractapi.TransactionContextInterface, key string) bool {
+
+	value, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false
+	}
+
+	return value != nil
+}
+
+func getMedicalHistoryByCode(ctx contractapi.TransactionContextInterface, patientID, kind, code string) ([]HealthRecord, error) {
+
+	switch kind {
+	case CodeKindRecordType:
+		return getHealthRecords(ctx, "HealthRecords", []string{"patientID", patientID}, func(healthRecord HealthRecord) bool {
+			return healthRecord.RecordTypeCode == code
+		})
+	case CodeKindSpeciality:
+		return getHealthRecords(ctx, "HealthRecords", []string{"patientID", patientID}, func(healthRecord HealthRecord) bool {
+			return healthRecord.SpecialityCode == code
+		})
+	default:
+		return nil, invalidArgumentError("invalid code kind: %s", kind)
+	}
+}
+
+// getHealthRecords devolve os registos com o prefixo indicado (na chave primária
+// ou num índice secundário) que são aceites por match.
+func getHealthRecords(ctx contractapi.TransactionContextInterface, objectType string, attributes []string,
+	match func(HealthRecord) bool) ([]HealthRecord, error) {
+
+	var healthRecords = []HealthRecord{}
+
+	err := scanState(ctx, objectType, attributes, func(key string, value []byte) error {
+		healthRecord, err := unmarshalHealthRecord(ctx, value)
+		if err != nil {
+			return err
+		}
+
+		if match(healthRecord) {
+			healthRecords = append(healthRecords, healthRecord)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao obter os dados do paciente: %v", err)
+	}
+
+	return healthRecords, nil
+}
+
+func getMedicalHistoryByObservationCode(ctx contractapi.TransactionContextInterface, patientID, code string) ([]HealthRecord, error) {
+
+	return getHealthRecords(ctx, "HealthRecords", []string{"patientID", patientID}, func(healthRecord HealthRecord) bool {
+		for _, observation := range healthRecord.Observations {
+			if observation.Code == code {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// getObservationSeries junta as observações com o código indicado, ordenadas pela data do evento.
+func getObservationSeries(healthRecords []HealthRecord, code string) []ObservationPoint {
+
+	var series = []ObservationPoint{}
+
+	for _, healthRecord := range healthRecords {
+		for _, observation := range healthRecord.Observations {
+			if observation.Code != code {
+				continue
+			}
+
+			series = append(series, ObservationPoint{
+				RecordID:      healthRecord.RecordID,
+				EventDate:     healthRecord.EventDate,
+				Value:         observation.Value,
+				Unit:          observation.Unit,
+				ReferenceLow:  observation.ReferenceLow,
+				ReferenceHigh: observation.ReferenceHigh,
+				AbnormalFlag:  observation.AbnormalFlag,
+			})
+		}
+	}
+
+	sort.SliceStable(series, func(i, j int) bool {
+		return series[i].EventDate < series[j].EventDate
+	})
+
+	return series
+}
+
+// validateObservations normaliza as observações e confirma que os códigos estão registados e ativos.
+func validateObservations(ctx contractapi.TransactionContextInterface, observations []Observation) ([]Observation, bool) {
+
+	var normalized = []Observation{}
+
+	for _, observation := range observations {
+		observationCode := getActiveCode(ctx, CodeKindObservation, observation.Code)
+		if observationCode == nil {
+			return nil, false
+		}
+
+		normalizedObservation, err := normalizeObservation(observation, observationCode.Unit)
+		if err != nil {
+			return nil, false
+		}
+
+		normalizedObservation.Display = observationCode.Display
+		normalized = append(normalized, normalizedObservation)
+	}
+
+	return normalized, true
+}
+
+func eraseHealthRecords(ctx contractapi.TransactionContextInterface, patientID string, erasedDate int64) ([]string, error) {
+
+	return updateStates(ctx, "HealthRecords", []string{"patientID", patientID}, func(value []byte) ([]byte, error) {
+		var healthRecord HealthRecord
+		if err := json.Unmarshal(value, &healthRecord); err != nil {
+			return nil, fmt.Errorf("error unmarshalling health record: %v", err)
+		}
+
+		indexKeys, err := healthRecordIndexKeys(ctx, healthRecord)
+		if err != nil {
+			return nil, err
+		}
+
+		// Mantemos apenas os identificadores e as datas, o conteúdo clínico é removido.
+		// O episódio e as ligações também saem, com as suas chaves de índice.
+		healthRecord.Description = ""
+		healthRecord.Speciality = ""
+		healthRecord.SpecialityCode = ""
+		healthRecord.RecordType = ""
+		healthRecord.RecordTypeCode = ""
+		healthRecord.Organization = ""
+		healthRecord.Signature = ""
+		healthRecord.SignerCertificate = ""
+		healthRecord.Disputes = []HealthRecordDispute{}
+		healthRecord.Observations = []Observation{}
+		healthRecord.SensitivityLabel = ""
+		healthRecord.RetractedReason = ""
+		healthRecord.EncryptedContent = ""
+		healthRecord.EncounterID = ""
+		healthRecord.Links = []HealthRecordLink{}
+		healthRecord.Erased = true
+		healthRecord.ErasedDate = erasedDate
+
+		erasedIndexKeys, err := healthRecordIndexKeys(ctx, healthRecord)
+		if err != nil {
+			return nil, err
+		}
+
+		err = deleteStaleIndexKeys(ctx, indexKeys, erasedIndexKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(healthRecord)
+	})
+}
+
+func eraseRequests(ctx contractapi.TransactionContextInterface, patientID string, erasedDate int64) ([]string, error) {
+
+	return updateStates(ctx, "Requests", []string{"patientID", patientID}, func(value []byte) ([]byte, error) {
+		var request Request
+		if err := json.Unmarshal(value, &request); err != nil {
+			return nil, fmt.Errorf("error unmarshalling request: %v", err)
+		}
+
+		// Um pedido apagado expira, para não poder ser aceite depois do apagamento.
+		if request.ExpirationDate > erasedDate {
+			request.ExpirationDate = erasedDate
+		}
+		request.Description = ""
+		request.PatientName = ""
+		request.Erased = true
+		request.ErasedDate = erasedDate
+
+		err := putRequestIndexKeys(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(request)
+	})
+}
+
+func eraseAccesses(ctx contractapi.TransactionContextInterface, patientID string, erasedDate int64) ([]string, error) {
+
+	return updateStates(ctx, accessesByPatientIndex, []string{"patientID", patientID}, func(value []byte) ([]byte, error) {
+		var access Access
+		if err := json.Unmarshal(value, &access); err != nil {
+			return nil, fmt.Errorf("error unmarshalling access: %v", err)
+		}
+
+		// Um acesso apagado deixa de dar acesso a qualquer dado.
+		if access.ExpirationDate > erasedDate {
+			access.ExpirationDate = erasedDate
+		}
+		access.PatientName = ""
+		access.SensitivityLabels = []string{}
+		access.EmergencyReason = ""
+		access.Erased = true
+		access.ErasedDate = erasedDate
+
+		err := putAccessIndexKeys(ctx, access)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(access)
+	})
+}
+
+func eraseEncounters(ctx contractapi.TransactionContextInterface, patientID string, erasedDate int64) ([]string, error) {
+
+	return updateStates(ctx, "Encounters", []string{"patientID", patientID}, func(value []byte) ([]byte, error) {
+		var encounter Encounter
+		if err := json.Unmarshal(value, &encounter); err != nil {
+			return nil, fmt.Errorf("error unmarshalling encounter: %v", err)
+		}
+
+		// Um episódio apagado fica fechado, para não receber mais registos.
+		if encounter.Status == 0 {
+			encounter.Status = 1
+			encounter.EndDate = erasedDate
+		}
+		encounter.Type = ""
+		encounter.Organization = ""
+		encounter.AttendingHealthcareProfessional = ""
+		encounter.Erased = true
+		encounter.ErasedDate = erasedDate
+
+		return json.Marshal(encounter)
+	})
+}
+
+func eraseAccessLog(ctx contractapi.TransactionContextInterface, patientID string, erasedDate int64) ([]string, error) {
+
+	return updateStates(ctx, "AccessLog", []string{"patientID", patientID}, func(value []byte) ([]byte, error) {
+		var entry AccessLogEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return nil, fmt.Errorf("error unmarshalling access log entry: %v", err)
+		}
+
+		// Fica quem leu e quando; a finalidade e os registos lidos saem.
+		entry.Purpose = ""
+		entry.RecordIDs = []string{}
+		entry.Erased = true
+		entry.ErasedDate = erasedDate
+
+		return json.Marshal(entry)
+	})
+}
+
+// eraseNotificationPreferences apaga as preferências de notificação do paciente. Não têm
+// interesse para a auditoria, por isso a chave é apagada em vez de ficar uma tombstone.
+func eraseNotificationPreferences(ctx contractapi.TransactionContextInterface, patientID string) ([]string, error) {
+
+	compositeKey, err := createNotificationPreferencesCompositeKey(ctx, patientID)
+	if err != nil {
+		return nil, err
+	}
+
+	preferencesJSON, err := ctx.GetStub().GetState(compositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notification preferences from the ledger: %v", err)
+	}
+
+	if preferencesJSON == nil {
+		return []string{}, nil
+	}
+
+	err = ctx.GetStub().DelState(compositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete %s: %v", compositeKey, err)
+	}
+
+	return []string{compositeKey}, nil
+}
+
+// updateStates aplica update a cada objeto com o prefixo indicado e volta a guardá-lo
+// na sua chave primária, devolvendo as chaves alteradas.
+func updateStates(ctx contractapi.TransactionContextInterface, objectType string, attributes []string,
+	update func([]byte) ([]byte, error)) ([]string, error) {
+
+	var keys = []string{}
+
+	err := storeTransactionWriter(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanState(ctx, objectType, attributes, func(key string, value []byte) error {
+		updatedJSON, err := update(value)
+		if err != nil {
+			return err
+		}
+
+		err = ctx.GetStub().PutState(key, updatedJSON)
+		if err != nil {
+			return fmt.Errorf("failed to update %s: %v", key, err)
+		}
+
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// digestKeys devolve um hash SHA-256 das chaves, independente da ordem, para o
+// certificado de ErasePatientData poder ser comparado com a ledger mais tarde.
+func digestKeys(keys []string) string {
+
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+
+	hash := sha256.New()
+	for _, key := range sorted {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func getCode(ctx contractapi.TransactionContextInterface, kind, code string) (*Code, error) {
+
+	compositeKey, err := createCodeCompositeKey(ctx, kind, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codeJSON, err := ctx.GetStub().GetState(compositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read code from the ledger: %v", err)
+	}
+
+	if codeJSON == nil {
+		return nil, nil
+	}
+
+	var existingCode Code
+	if err := json.Unmarshal(codeJSON, &existingCode); err != nil {
+		return nil, fmt.Errorf("error unmarshalling code: %v", err)
+	}
+
+	return &existingCode, nil
+}
+
+// getActiveCode devolve o código apenas se estiver registado e ativo.
+func getActiveCode(ctx contractapi.TransactionContextInterface, kind, code string) *Code {
+
+	existingCode, err := getCode(ctx, kind, code)
+	if err != nil || existingCode == nil || !existingCode.Active {
+		return nil
+	}
+
+	return existingCode
+}
+
+func storeCode(ctx contractapi.TransactionContextInterface, code Code) error {
+
+	compositeKey, err := createCodeCompositeKey(ctx, code.Kind, code.Code)
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for code: %v", err)
+	}
+
+	codeJSON, err := json.Marshal(code)
+	if err != nil {
+		return fmt.Errorf("failed to serialize code to JSON: %v", err)
+	}
+
+	err = ctx.GetStub().PutState(compositeKey, codeJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store code on the ledger: %v", err)
+	}
+
+	return nil
+}
+
+// checkIfCallerIsAdmin verifica o atributo "role" do certificado de quem invoca.
+func checkIfCallerIsAdmin(ctx contractapi.TransactionContextInterface) bool {
+
+	role, found, err := ctx.GetClientIdentity().GetAttributeValue("role")
+	if err != nil || !found {
+		return false
+	}
 
-	return checkIfAnyDataAlreadyExist(ctx, queryString)
+	return role == "admin"
 }
 
-func checkIfAnyDataAlreadyExist(ctx contractapi.TransactionContextInterface, query string) bool {
+// getTxDate devolve a data da transação em segundos. É a mesma em todos os peers que a
+// endossam, ao contrário do time.Now, por isso é a que se guarda na ledger.
+func getTxDate(ctx contractapi.TransactionContextInterface) (int64, error) {
 
-	queryResultsIterator, err := ctx.GetStub().GetQueryResult(query)
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
 	if err != nil {
+		return 0, fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+
+	return txTimestamp.GetSeconds(), nil
+}
+
+// checkIfCallerIsHealthcareProfessional verifica se quem invoca é o profissional indicado, pelo
+// atributo "healthcareProfessionalID" do certificado.
+func checkIfCallerIsHealthcareProfessional(ctx contractapi.TransactionContextInterface, healthcareProfessionalID string) bool {
+
+	callerID, found, err := ctx.GetClientIdentity().GetAttributeValue("healthcareProfessionalID")
+	if err != nil || !found {
+		return false
+	}
+
+	return healthcareProfessionalID != "" && callerID == healthcareProfessionalID
+}
+
+// checkIfCallerIsPatient verifica se quem invoca é o próprio paciente, pelo atributo
+// "patientID" do certificado.
+func checkIfCallerIsPatient(ctx contractapi.TransactionContextInterface, patientID string) bool {
+
+	callerPatientID, found, err := ctx.GetClientIdentity().GetAttributeValue("patientID")
+	if err != nil || !found {
+		return false
+	}
+
+	return patientID != "" && callerPatientID == patientID
+}
+
+func getHealthcareProfessionalCertificate(ctx contractapi.TransactionContextInterface, healthcareProfessionalID string) (*HealthcareProfessionalCertificate, error) {
+
+	compositeKey, err := createHealthcareProfessionalCertificateCompositeKey(ctx, healthcareProfessionalID)
+	if err != nil {
+		return nil, err
+	}
+
+	certificateJSON, err := ctx.GetStub().GetState(compositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate from the ledger: %v", err)
+	}
+
+	if certificateJSON == nil {
+		return nil, nil
+	}
+
+	var certificate HealthcareProfessionalCertificate
+	if err := json.Unmarshal(certificateJSON, &certificate); err != nil {
+		return nil, fmt.Errorf("error unmarshalling certificate: %v", err)
+	}
+
+	return &certificate, nil
+}
+
+// checkIfCallerIsAuditor aceita auditores e administradores.
+func checkIfCallerIsAuditor(ctx contractapi.TransactionContextInterface) bool {
+
+	role, found, err := ctx.GetClientIdentity().GetAttributeValue("role")
+	if err != nil || !found {
+		return false
+	}
+
+	return role == "auditor" || role == "admin"
+}
+
+// checkIfCallerIsNotifier verifica se quem invoca é o serviço de notificações do gateway,
+// que só precisa de ler as preferências de notificação dos pacientes.
+func checkIfCallerIsNotifier(ctx contractapi.TransactionContextInterface) bool {
+
+	role, found, err := ctx.GetClientIdentity().GetAttributeValue("role")
+	if err != nil || !found {
 		return false
 	}
-	defer queryResultsIterator.Close()
 
-	return queryResultsIterator.HasNext()
+	return role == "notifier"
+}
+
+func getEncounter(ctx contractapi.TransactionContextInterface, patientID, encounterID string) (*Encounter, error) {
+
+	compositeKey, err := createEncounterCompositeKey(ctx, patientID, encounterID)
+	if err != nil {
+		return nil, err
+	}
+
+	encounterJSON, err := ctx.GetStub().GetState(compositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encounter from the ledger: %v", err)
+	}
+
+	if encounterJSON == nil {
+		return nil, nil
+	}
+
+	var encounter Encounter
+	if err := json.Unmarshal(encounterJSON, &encounter); err != nil {
+		return nil, fmt.Errorf("error unmarshalling encounter: %v", err)
+	}
+
+	return &encounter, nil
+}
+
+func storeEncounter(ctx contractapi.TransactionContextInterface, encounter Encounter) error {
+
+	compositeKey, err := createEncounterCompositeKey(ctx, encounter.PatientID, encounter.EncounterID)
+	if err != nil {
+		return fmt.Errorf("failed to create composite key for encounter: %v", err)
+	}
+
+	encounterJSON, err := json.Marshal(encounter)
+	if err != nil {
+		return fmt.Errorf("failed to serialize encounter to JSON: %v", err)
+	}
+
+	err = ctx.GetStub().PutState(compositeKey, encounterJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store encounter on the ledger: %v", err)
+	}
+
+	return nil
+}
+
+func getEncounters(ctx contractapi.TransactionContextInterface, patientID string) ([]Encounter, error) {
+
+	var encounters = []Encounter{}
+
+	err := scanState(ctx, "Encounters", []string{"patientID", patientID}, func(key string, value []byte) error {
+		var encounter Encounter
+		if err := json.Unmarshal(value, &encounter); err != nil {
+			return fmt.Errorf("error unmarshalling encounter: %v", err)
+		}
+		encounters = append(encounters, encounter)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return encounters, nil
+}
+
+func getEncounterRecords(ctx contractapi.TransactionContextInterface, patientID, encounterID string) ([]HealthRecord, error) {
+
+	return getHealthRecords(ctx, healthRecordsByEncounterIndex, []string{"patientID", patientID, "encounterID", encounterID},
+		func(healthRecord HealthRecord) bool { return true })
+}
+
+// getRequestKeyByRequestID devolve a chave primária do pedido, que inclui o profissional,
+// a partir do índice por paciente e pedido. Devolve "" se o pedido não existir.
+func getRequestKeyByRequestID(ctx contractapi.TransactionContextInterface, patientID, requestID string) (string, error) {
+
+	indexKey, err := createRequestByRequestIDCompositeKey(ctx, patientID, requestID)
+	if err != nil {
+		return "", err
+	}
+
+	requestKey, err := ctx.GetStub().GetState(indexKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read request from the ledger: %v", err)
+	}
+
+	return string(requestKey), nil
+}
+
+// storeAccessLogEntry regista a leitura dos registos pelo profissional, com os pedidos
+// dos acessos válidos ao abrigo dos quais foram lidos. A data é a da transação, igual
+// em todos os peers que a endossam.
+func storeAccessLogEntry(ctx contractapi.TransactionContextInterface,
+	patientID, healthcareProfessionalID, transaction, purpose string, recordIDs []string) error {
+
+	accesses, err := getValidAccesses(ctx, patientID, healthcareProfessionalID)
+	if err != nil {
+		return err
+	}
+
+	var requestIDs = []string{}
+	for _, access := range accesses {
+		requestIDs = append(requestIDs, access.RequestID)
+	}
+
+	createdDate, err := getTxDate(ctx)
+	if err != nil {
+		return err
+	}
+
+	entry := AccessLogEntry{
+		ResourceType:             9,
+		TxID:                     ctx.GetStub().GetTxID(),
+		PatientID:                patientID,
+		HealthcareProfessionalID: healthcareProfessionalID,
+		Transaction:              transaction,
+		Purpose:                  purpose,
+		RecordIDs:                recordIDs,
+		RequestIDs:               requestIDs,
+		CreatedDate:              createdDate,
+	}
+
+	compositeKey, err := createAccessLogCompositeKey(ctx, patientID, sortableDate(entry.CreatedDate), entry.TxID)
+	if err != nil {
+		return err
+	}
+
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to serialize access log entry to JSON: %v", err)
+	}
+
+	err = ctx.GetStub().PutState(compositeKey, entryJSON)
+	if err != nil {
+		return fmt.Errorf("failed to store access log entry on the ledger: %v", err)
+	}
+
+	indexKeys, err := accessLogEntryIndexKeys(ctx, entry)
+	if err != nil {
+		return err
+	}
+
+	err = putIndexKeys(ctx, compositeKey, indexKeys)
+	if err != nil {
+		return err
+	}
+
+	err = storeTransactionWriter(ctx)
+	if err != nil {
+		return err
+	}
+
+	// As leituras auditadas só guardam uma entrada, por isso o evento pode ser emitido aqui.
+	return setHealthEvents(ctx, HealthEvent{
+		Type:                     EventHealthRecordsRead,
+		PatientID:                patientID,
+		HealthcareProfessionalID: healthcareProfessionalID,
+		RecordIDs:                recordIDs,
+	})
+}
+
+// getAccessLog devolve uma página do registo de leituras (do paciente ou do profissional,
+// conforme o índice), por ordem de data. Só lê as chaves dentro do intervalo de datas; as
+// datas a zero não o limitam, as leituras são sempre feitas depois de 1970.
+func getAccessLog(ctx contractapi.TransactionContextInterface, objectType string, attributes []string,
+	match func(AccessLogEntry) bool, dateFrom, dateTo int64, pageSize int32, bookmark string) (*AccessLogPage, error) {
+
+	page := AccessLogPage{}
+	page.AccessLogEntries = []AccessLogEntry{}
+
+	if dateFrom == 0 {
+		dateFrom = minEventDate
+	}
+	if dateTo == 0 {
+		dateTo = maxEventDate
+	}
+
+	keyRange, err := dateKeyRange(ctx, objectType, attributes, sortableDate(dateFrom), sortableDate(dateTo))
+	if err != nil {
+		return nil, err
+	}
+
+	fetched, nextBookmark, err := scanStatePage(ctx, objectType, attributes, keyRange, pageSize, bookmark,
+		func(key string, value []byte) (bool, error) {
+			var entry AccessLogEntry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return false, fmt.Errorf("error unmarshalling access log entry: %v", err)
+			}
+
+			if !match(entry) {
+				return false, nil
+			}
+
+			page.AccessLogEntries = append(page.AccessLogEntries, entry)
+			return true, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	page.FetchedRecordsCount = fetched
+	page.Bookmark = nextBookmark
+
+	return &page, nil
 }
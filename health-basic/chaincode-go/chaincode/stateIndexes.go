@@ -0,0 +1,562 @@
+package chaincode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// As leituras usam só GetState e pesquisas por prefixo de chaves compostas, para o
+// chaincode funcionar (com os mesmos resultados) em LevelDB e em CouchDB.
+// As pesquisas que não são pela chave primária usam índices secundários: chaves
+// compostas cujo valor é a chave primária do objeto.
+const (
+	healthRecordsByEventDateIndex          = "HealthRecordsByEventDate"
+	healthRecordsByEventDateDescIndex      = "HealthRecordsByEventDateDesc"
+	healthRecordsByCreatedDateIndex        = "HealthRecordsByCreatedDate"
+	healthRecordsByCreatedDateDescIndex    = "HealthRecordsByCreatedDateDesc"
+	healthRecordsByEncounterIndex          = "HealthRecordsByEncounter"
+	healthRecordLinksIndex                 = "HealthRecordLinks"
+	requestsByRequestIDIndex               = "RequestsByRequestID"
+	requestsByHealthcareProfessionalIndex  = "RequestsByHealthcareProfessional"
+	accessesByPatientIndex                 = "AccessesByPatient"
+	accessesByHealthcareProfessionalIndex  = "AccessesByHealthcareProfessional"
+	accessLogByHealthcareProfessionalIndex = "AccessLogByHealthcareProfessional"
+	accessLogByDateIndex                   = "AccessLogByDate"
+)
+
+// Índices das alterações de pedidos e acessos por data, para o relatório de auditoria
+// de uma organização ou de um período sem ler a ledger toda. Cada objeto tem uma chave
+// por cada data em que mudou (criação, resposta ou corte, apagamento). Não estão em
+// secondaryIndexes porque quem os lê precisa da data da chave, não só do objeto.
+const (
+	requestsByChangeDateIndex = "RequestsByChangeDate"
+	accessesByChangeDateIndex = "AccessesByChangeDate"
+)
+
+var secondaryIndexes = map[string]bool{
+	healthRecordsByEventDateIndex:          true,
+	healthRecordsByEventDateDescIndex:      true,
+	healthRecordsByCreatedDateIndex:        true,
+	healthRecordsByCreatedDateDescIndex:    true,
+	healthRecordsByEncounterIndex:          true,
+	healthRecordLinksIndex:                 true,
+	requestsByRequestIDIndex:               true,
+	requestsByHealthcareProfessionalIndex:  true,
+	accessesByPatientIndex:                 true,
+	accessesByHealthcareProfessionalIndex:  true,
+	accessLogByHealthcareProfessionalIndex: true,
+	accessLogByDateIndex:                   true,
+}
+
+// sortableDate escreve a data com largura fixa e o bit de sinal trocado, para a
+// ordem das chaves ser a ordem das datas (também para datas antes de 1970).
+func sortableDate(date int64) string {
+	return fmt.Sprintf("%020d", uint64(date)^(1<<63))
+}
+
+// reverseSortableDate faz o mesmo por ordem inversa, para as listagens mais recentes primeiro.
+func reverseSortableDate(date int64) string {
+	return fmt.Sprintf("%020d", ^(uint64(date) ^ (1 << 63)))
+}
+
+// parseSortableDate lê uma data escrita com sortableDate.
+func parseSortableDate(date string) (int64, error) {
+
+	value, err := strconv.ParseUint(date, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sortable date %q: %v", date, err)
+	}
+
+	return int64(value ^ (1 << 63)), nil
+}
+
+func healthRecordIndexKeys(ctx contractapi.TransactionContextInterface, healthRecord HealthRecord) ([]string, error) {
+
+	var keys = []string{}
+
+	dates := []struct {
+		index string
+		date  string
+	}{
+		{healthRecordsByEventDateIndex, sortableDate(healthRecord.EventDate)},
+		{healthRecordsByEventDateDescIndex, reverseSortableDate(healthRecord.EventDate)},
+		{healthRecordsByCreatedDateIndex, sortableDate(healthRecord.CreatedDate)},
+		{healthRecordsByCreatedDateDescIndex, reverseSortableDate(healthRecord.CreatedDate)},
+	}
+
+	for _, date := range dates {
+		key, err := createHealthRecordByDateCompositeKey(ctx, date.index, healthRecord.PatientID, date.date, healthRecord.RecordID)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if healthRecord.EncounterID != "" {
+		key, err := createHealthRecordByEncounterCompositeKey(ctx, healthRecord.PatientID, healthRecord.EncounterID, healthRecord.RecordID)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	for _, link := range healthRecord.Links {
+		key, err := createHealthRecordLinkCompositeKey(ctx, healthRecord.PatientID, link.RecordID, healthRecord.RecordID)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func requestIndexKeys(ctx contractapi.TransactionContextInterface, request Request) ([]string, error) {
+
+	byRequestID, err := createRequestByRequestIDCompositeKey(ctx, request.PatientID, request.RequestID)
+	if err != nil {
+		return nil, err
+	}
+
+	byHealthcareProfessional, err := createRequestByHealthcareProfessionalCompositeKey(ctx, request.HealthcareProfessionalID, request.PatientID, request.RequestID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{byRequestID, byHealthcareProfessional}
+
+	for _, date := range changeDates(request.CreatedDate, request.StatusChangedDate, request.ErasedDate) {
+		key, err := createRequestByChangeDateCompositeKey(ctx, sortableDate(date), request.PatientID, request.HealthcareProfessionalID, request.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func accessIndexKeys(ctx contractapi.TransactionContextInterface, access Access) ([]string, error) {
+
+	byPatient, err := createAccessByPatientCompositeKey(ctx, access.PatientID, access.RequestID)
+	if err != nil {
+		return nil, err
+	}
+
+	byHealthcareProfessional, err := createAccessByHealthcareProfessionalCompositeKey(ctx, access.HealthcareProfessionalID, access.PatientID, access.RequestID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{byPatient, byHealthcareProfessional}
+
+	for _, date := range changeDates(access.CreatedDate, access.RevokedDate, access.ErasedDate) {
+		key, err := createAccessByChangeDateCompositeKey(ctx, sortableDate(date), access.PatientID, access.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func accessLogEntryIndexKeys(ctx contractapi.TransactionContextInterface, entry AccessLogEntry) ([]string, error) {
+
+	byHealthcareProfessional, err := createAccessLogByHealthcareProfessionalCompositeKey(ctx, entry.HealthcareProfessionalID, sortableDate(entry.CreatedDate), entry.TxID)
+	if err != nil {
+		return nil, err
+	}
+
+	byDate, err := createAccessLogByDateCompositeKey(ctx, sortableDate(entry.CreatedDate), entry.TxID)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{byHealthcareProfessional, byDate}, nil
+}
+
+// putRequestIndexKeys guarda as chaves de índice de um pedido alterado.
+func putRequestIndexKeys(ctx contractapi.TransactionContextInterface, request Request) error {
+
+	requestKey, err := createRequestCompositeKey(ctx, request.PatientID, request.HealthcareProfessionalID, request.RequestID)
+	if err != nil {
+		return err
+	}
+
+	indexKeys, err := requestIndexKeys(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	return putIndexKeys(ctx, requestKey, indexKeys)
+}
+
+// putAccessIndexKeys guarda as chaves de índice de um acesso alterado.
+func putAccessIndexKeys(ctx contractapi.TransactionContextInterface, access Access) error {
+
+	accessKey, err := getAccessKey(ctx, access.PatientID, access.RequestID)
+	if err != nil {
+		return err
+	}
+
+	indexKeys, err := accessIndexKeys(ctx, access)
+	if err != nil {
+		return err
+	}
+
+	return putIndexKeys(ctx, accessKey, indexKeys)
+}
+
+// changeDates devolve a data de criação e as datas de alteração já preenchidas, sem
+// repetir a anterior (um pedido novo tem a data de resposta igual à de criação).
+func changeDates(createdDate int64, dates ...int64) []int64 {
+
+	var changes = []int64{createdDate}
+
+	for _, date := range dates {
+		if date != 0 && date != changes[len(changes)-1] {
+			changes = append(changes, date)
+		}
+	}
+
+	return changes
+}
+
+// putIndexKeys guarda as chaves de índice a apontar para a chave primária.
+// Os campos indexados não mudam depois de o objeto ser criado, por isso voltar
+// a guardá-las numa atualização não deixa entradas antigas para trás. Os índices
+// de alterações por data só ganham chaves novas. A exceção é o apagamento dos
+// registos, que usa deleteStaleIndexKeys.
+func putIndexKeys(ctx contractapi.TransactionContextInterface, primaryKey string, indexKeys []string) error {
+
+	for _, indexKey := range indexKeys {
+		err := ctx.GetStub().PutState(indexKey, []byte(primaryKey))
+		if err != nil {
+			return fmt.Errorf("failed to store index key: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// deleteStaleIndexKeys apaga as chaves de índice antigas que já não estão nas novas.
+func deleteStaleIndexKeys(ctx contractapi.TransactionContextInterface, oldIndexKeys, newIndexKeys []string) error {
+
+	keep := map[string]bool{}
+	for _, indexKey := range newIndexKeys {
+		keep[indexKey] = true
+	}
+
+	for _, indexKey := range oldIndexKeys {
+		if keep[indexKey] {
+			continue
+		}
+
+		err := ctx.GetStub().DelState(indexKey)
+		if err != nil {
+			return fmt.Errorf("failed to delete index key: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// resolveIndexEntry devolve a chave primária e o valor do objeto. Para as chaves dos
+// índices secundários lê o objeto apontado, as restantes já são o próprio objeto.
+func resolveIndexEntry(ctx contractapi.TransactionContextInterface, objectType, key string, value []byte) (string, []byte, error) {
+
+	if !secondaryIndexes[objectType] {
+		return key, value, nil
+	}
+
+	primaryKey := string(value)
+
+	primaryValue, err := ctx.GetStub().GetState(primaryKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %s from the ledger: %v", primaryKey, err)
+	}
+
+	return primaryKey, primaryValue, nil
+}
+
+// scanState chama visit para cada objeto com o prefixo indicado. Pode ser usado em
+// transações de escrita, ao contrário de scanStatePage.
+func scanState(ctx contractapi.TransactionContextInterface, objectType string, attributes []string,
+	visit func(key string, value []byte) error) error {
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", objectType, err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return fmt.Errorf("error retrieving next query result: %v", err)
+		}
+
+		key, value, err := resolveIndexEntry(ctx, objectType, queryResponse.Key, queryResponse.Value)
+		if err != nil {
+			return err
+		}
+
+		// Entrada de índice de um objeto que já não existe.
+		if value == nil {
+			continue
+		}
+
+		if err := visit(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stateKeyRange limita uma pesquisa por prefixo às chaves de start (inclusive) a end (exclusive).
+type stateKeyRange struct {
+	start string
+	end   string
+}
+
+// dateKeyRange devolve as chaves do prefixo cuja data (o atributo "date" a seguir ao prefixo,
+// já escrita com sortableDate ou reverseSortableDate) está entre from e to, inclusive.
+func dateKeyRange(ctx contractapi.TransactionContextInterface, objectType string, attributes []string,
+	from, to string) (*stateKeyRange, error) {
+
+	start, err := ctx.GetStub().CreateCompositeKey(objectType, append(attributes[:len(attributes):len(attributes)], "date", from))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	end, err := ctx.GetStub().CreateCompositeKey(objectType, append(attributes[:len(attributes):len(attributes)], "date", to))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	// As chaves com a data to continuam com mais atributos, por isso o fim é a seguir a todas elas.
+	return &stateKeyRange{start: start, end: end + string(utf8.MaxRune)}, nil
+}
+
+// statePageScanner é scanStatePage ou scanStatePageForUpdate, conforme a transação escreve ou não.
+type statePageScanner func(ctx contractapi.TransactionContextInterface, objectType string, attributes []string,
+	keyRange *stateKeyRange, pageSize int32, bookmark string, visit func(key string, value []byte) (bool, error)) (int32, string, error)
+
+// scanStatePage devolve até pageSize objetos aceites por visit, a partir do bookmark.
+// Como os filtros são aplicados aqui, continua a ler páginas até encher a página pedida;
+// o bookmark devolvido é a chave seguinte ainda não lida, ou vazio se não houver mais.
+// Com keyRange só lê as chaves dentro do intervalo, nil lê o prefixo todo.
+func scanStatePage(ctx contractapi.TransactionContextInterface, objectType string, attributes []string,
+	keyRange *stateKeyRange, pageSize int32, bookmark string, visit func(key string, value []byte) (bool, error)) (int32, string, error) {
+
+	// O bookmark é a chave onde a pesquisa recomeça, tem de estar dentro do prefixo
+	// para não devolver objetos de outro paciente ou profissional.
+	prefix, err := ctx.GetStub().CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	if bookmark != "" && !strings.HasPrefix(bookmark, prefix) {
+		return 0, "", invalidArgumentError("invalid bookmark")
+	}
+
+	// O bookmark é a chave onde a pesquisa paginada começa, por isso serve para saltar
+	// diretamente para o início do intervalo.
+	if keyRange != nil && bookmark < keyRange.start {
+		bookmark = keyRange.start
+	}
+
+	pageSize = normalizePageSize(pageSize)
+	var fetched int32
+
+	for {
+		resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(objectType, attributes, pageSize, bookmark)
+		if err != nil {
+			return 0, "", fmt.Errorf("failed to read %s: %v", objectType, err)
+		}
+
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return 0, "", fmt.Errorf("error retrieving next query result: %v", err)
+			}
+
+			if keyRange != nil && queryResponse.Key >= keyRange.end {
+				resultsIterator.Close()
+				return fetched, "", nil
+			}
+
+			if fetched == pageSize {
+				resultsIterator.Close()
+				return fetched, queryResponse.Key, nil
+			}
+
+			key, value, err := resolveIndexEntry(ctx, objectType, queryResponse.Key, queryResponse.Value)
+			if err != nil {
+				resultsIterator.Close()
+				return 0, "", err
+			}
+
+			if value == nil {
+				continue
+			}
+
+			accepted, err := visit(key, value)
+			if err != nil {
+				resultsIterator.Close()
+				return 0, "", err
+			}
+
+			if accepted {
+				fetched++
+			}
+		}
+
+		resultsIterator.Close()
+
+		if metadata.FetchedRecordsCount < pageSize || metadata.Bookmark == "" {
+			return fetched, "", nil
+		}
+
+		bookmark = metadata.Bookmark
+	}
+}
+
+// stateScan é uma das pesquisas de scanStatesPage.
+type stateScan struct {
+	objectType string
+	attributes []string
+	keyRange   *stateKeyRange
+	visit      func(key string, value []byte) (bool, error)
+}
+
+// scanStatesPage pagina várias pesquisas, uma a seguir à outra, como se fossem uma só.
+// O bookmark é uma chave da pesquisa onde se ficou, que se reconhece pelo tipo de objeto
+// (por isso cada pesquisa tem de ter um tipo diferente); as anteriores já estão feitas.
+func scanStatesPage(ctx contractapi.TransactionContextInterface, scan statePageScanner, scans []stateScan,
+	pageSize int32, bookmark string) (int32, string, error) {
+
+	first := 0
+	if bookmark != "" {
+		objectType, _, err := ctx.GetStub().SplitCompositeKey(bookmark)
+		if err != nil {
+			return 0, "", invalidArgumentError("invalid bookmark")
+		}
+
+		first = -1
+		for i, stateScan := range scans {
+			if stateScan.objectType == objectType {
+				first = i
+			}
+		}
+		if first == -1 {
+			return 0, "", invalidArgumentError("invalid bookmark")
+		}
+	}
+
+	pageSize = normalizePageSize(pageSize)
+	var fetched int32
+
+	for _, stateScan := range scans[first:] {
+		// Com a página cheia, a seguinte começa no início desta pesquisa.
+		if fetched == pageSize {
+			nextBookmark, err := ctx.GetStub().CreateCompositeKey(stateScan.objectType, stateScan.attributes)
+			if err != nil {
+				return 0, "", fmt.Errorf("failed to create composite key: %v", err)
+			}
+
+			return fetched, nextBookmark, nil
+		}
+
+		scanFetched, nextBookmark, err := scan(ctx, stateScan.objectType, stateScan.attributes, stateScan.keyRange,
+			pageSize-fetched, bookmark, stateScan.visit)
+		if err != nil {
+			return 0, "", err
+		}
+
+		fetched += scanFetched
+		if nextBookmark != "" {
+			return fetched, nextBookmark, nil
+		}
+
+		bookmark = ""
+	}
+
+	return fetched, "", nil
+}
+
+// scanStatePageForUpdate faz o mesmo que scanStatePage sem pesquisas paginadas, que o Fabric
+// não permite em transações que escrevem. Lê o prefixo desde o início e salta as chaves antes
+// do bookmark (ou do início do keyRange), por isso os bookmarks servem para as duas; pára no
+// fim do keyRange.
+func scanStatePageForUpdate(ctx contractapi.TransactionContextInterface, objectType string, attributes []string,
+	keyRange *stateKeyRange, pageSize int32, bookmark string, visit func(key string, value []byte) (bool, error)) (int32, string, error) {
+
+	prefix, err := ctx.GetStub().CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	if bookmark != "" && !strings.HasPrefix(bookmark, prefix) {
+		return 0, "", invalidArgumentError("invalid bookmark")
+	}
+
+	if keyRange != nil && bookmark < keyRange.start {
+		bookmark = keyRange.start
+	}
+
+	pageSize = normalizePageSize(pageSize)
+	var fetched int32
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read %s: %v", objectType, err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, "", fmt.Errorf("error retrieving next query result: %v", err)
+		}
+
+		if queryResponse.Key < bookmark {
+			continue
+		}
+
+		if keyRange != nil && queryResponse.Key >= keyRange.end {
+			return fetched, "", nil
+		}
+
+		if fetched == pageSize {
+			return fetched, queryResponse.Key, nil
+		}
+
+		key, value, err := resolveIndexEntry(ctx, objectType, queryResponse.Key, queryResponse.Value)
+		if err != nil {
+			return 0, "", err
+		}
+
+		if value == nil {
+			continue
+		}
+
+		accepted, err := visit(key, value)
+		if err != nil {
+			return 0, "", err
+		}
+
+		if accepted {
+			fetched++
+		}
+	}
+
+	return fetched, "", nil
+}
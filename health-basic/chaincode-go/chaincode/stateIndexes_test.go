@@ -0,0 +1,300 @@
+package chaincode
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeStub guarda o estado em memória e implementa só o que as transações testadas usam,
+// com as pesquisas paginadas a usar o bookmark como a chave onde começam, como no peer.
+// As escritas são logo visíveis, ao contrário do peer, que só as aplica no commit.
+type fakeStub struct {
+	shim.ChaincodeStubInterface
+	state     map[string][]byte
+	private   map[string][]byte
+	transient map[string][]byte
+	txID      string
+	txDate    int64
+	event     []byte
+}
+
+type fakeIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (it *fakeIterator) HasNext() bool { return len(it.kvs) > 0 }
+func (it *fakeIterator) Close() error  { return nil }
+
+func (it *fakeIterator) Next() (*queryresult.KV, error) {
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv, nil
+}
+
+// fakeIdentity é quem invoca, com os atributos do certificado que o chaincode lê.
+type fakeIdentity struct {
+	cid.ClientIdentity
+	attributes map[string]string
+}
+
+func (id *fakeIdentity) GetID() (string, error)    { return "x509::CN=test", nil }
+func (id *fakeIdentity) GetMSPID() (string, error) { return "Org1MSP", nil }
+
+func (id *fakeIdentity) GetAttributeValue(name string) (string, bool, error) {
+	value, found := id.attributes[name]
+	return value, found, nil
+}
+
+type fakeContext struct {
+	stub     *fakeStub
+	identity *fakeIdentity
+}
+
+func (ctx *fakeContext) GetStub() shim.ChaincodeStubInterface  { return ctx.stub }
+func (ctx *fakeContext) GetClientIdentity() cid.ClientIdentity { return ctx.identity }
+
+func newFakeContext() *fakeContext {
+	return &fakeContext{
+		stub: &fakeStub{
+			state:     map[string][]byte{},
+			private:   map[string][]byte{},
+			transient: map[string][]byte{},
+			txID:      "tx1",
+			txDate:    1700000000,
+		},
+		identity: &fakeIdentity{attributes: map[string]string{}},
+	}
+}
+
+// callAs muda os atributos de quem invoca as transações seguintes.
+func (ctx *fakeContext) callAs(attributes map[string]string) {
+	ctx.identity = &fakeIdentity{attributes: attributes}
+}
+
+func (s *fakeStub) PutState(key string, value []byte) error {
+	s.state[key] = value
+	return nil
+}
+
+func (s *fakeStub) DelState(key string) error {
+	delete(s.state, key)
+	return nil
+}
+
+func (s *fakeStub) GetTxID() string { return s.txID }
+
+func (s *fakeStub) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	return &timestamppb.Timestamp{Seconds: s.txDate}, nil
+}
+
+func (s *fakeStub) SetEvent(name string, payload []byte) error {
+	s.event = payload
+	return nil
+}
+
+func (s *fakeStub) GetTransient() (map[string][]byte, error) { return s.transient, nil }
+
+func (s *fakeStub) GetPrivateData(collection, key string) ([]byte, error) {
+	return s.private[collection+"\x00"+key], nil
+}
+
+func (s *fakeStub) PutPrivateData(collection, key string, value []byte) error {
+	s.private[collection+"\x00"+key] = value
+	return nil
+}
+
+func (s *fakeStub) PurgePrivateData(collection, key string) error {
+	delete(s.private, collection+"\x00"+key)
+	return nil
+}
+
+func (s *fakeStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return "\x00" + objectType + "\x00" + strings.Join(append(attributes, ""), "\x00"), nil
+}
+
+func (s *fakeStub) GetState(key string) ([]byte, error) {
+	return s.state[key], nil
+}
+
+func (s *fakeStub) keysFrom(objectType string, attributes []string, start string) []string {
+	prefix, _ := s.CreateCompositeKey(objectType, attributes)
+
+	var keys []string
+	for key := range s.state {
+		if strings.HasPrefix(key, prefix) && key >= start {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (s *fakeStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	it := &fakeIterator{}
+	for _, key := range s.keysFrom(objectType, attributes, "") {
+		it.kvs = append(it.kvs, &queryresult.KV{Key: key, Value: s.state[key]})
+	}
+	return it, nil
+}
+
+func (s *fakeStub) GetStateByPartialCompositeKeyWithPagination(objectType string, attributes []string,
+	pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+
+	it := &fakeIterator{}
+	metadata := &pb.QueryResponseMetadata{}
+
+	for _, key := range s.keysFrom(objectType, attributes, bookmark) {
+		if int32(len(it.kvs)) == pageSize {
+			metadata.Bookmark = key
+			break
+		}
+		it.kvs = append(it.kvs, &queryresult.KV{Key: key, Value: s.state[key]})
+	}
+	metadata.FetchedRecordsCount = int32(len(it.kvs))
+
+	return it, metadata, nil
+}
+
+// putObjects guarda um objeto por data com a chave (objectType, patientID, date, id).
+func putObjects(ctx *fakeContext, patientID string, dates ...int64) {
+	for i, date := range dates {
+		key, _ := ctx.stub.CreateCompositeKey("Objects", []string{"patientID", patientID, "date", sortableDate(date), "id", string(rune('a' + i))})
+		ctx.stub.state[key] = []byte(patientID)
+	}
+}
+
+// scanAll lê todas as páginas e devolve os valores aceites e o número de páginas.
+func scanAll(t *testing.T, ctx *fakeContext, scan statePageScanner, keyRange *stateKeyRange, pageSize int32,
+	accept func(key string) bool) ([]string, int) {
+
+	var keys []string
+	pages := 0
+	bookmark := ""
+
+	for {
+		fetched, next, err := scan(ctx, "Objects", []string{"patientID", "p1"}, keyRange, pageSize, bookmark,
+			func(key string, value []byte) (bool, error) {
+				if !accept(key) {
+					return false, nil
+				}
+				keys = append(keys, key)
+				return true, nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+
+		if fetched > pageSize {
+			t.Fatalf("page has %d objects, more than %d", fetched, pageSize)
+		}
+		if next == "" {
+			return keys, pages
+		}
+		if fetched != pageSize {
+			t.Fatalf("page with a bookmark has %d objects, expected %d", fetched, pageSize)
+		}
+		bookmark = next
+	}
+}
+
+var statePageScanners = map[string]statePageScanner{
+	"scanStatePage":          scanStatePage,
+	"scanStatePageForUpdate": scanStatePageForUpdate,
+}
+
+func TestSortableDateOrder(t *testing.T) {
+	dates := []int64{minEventDate, -1 << 40, -86400, -1, 0, 1, 86400, 1 << 40, maxEventDate}
+
+	for i := 1; i < len(dates); i++ {
+		if sortableDate(dates[i-1]) >= sortableDate(dates[i]) {
+			t.Errorf("sortableDate(%d) >= sortableDate(%d)", dates[i-1], dates[i])
+		}
+		if reverseSortableDate(dates[i-1]) <= reverseSortableDate(dates[i]) {
+			t.Errorf("reverseSortableDate(%d) <= reverseSortableDate(%d)", dates[i-1], dates[i])
+		}
+		if len(sortableDate(dates[i])) != len(sortableDate(dates[0])) {
+			t.Errorf("sortableDate(%d) has a different width", dates[i])
+		}
+	}
+}
+
+func TestScanStatePageRejectsBookmarkOutsidePrefix(t *testing.T) {
+	ctx := newFakeContext()
+	putObjects(ctx, "p1", 1, 2)
+	putObjects(ctx, "p2", 1, 2)
+
+	otherPatientKey := ctx.stub.keysFrom("Objects", []string{"patientID", "p2"}, "")[0]
+
+	for name, scan := range statePageScanners {
+		_, _, err := scan(ctx, "Objects", []string{"patientID", "p1"}, nil, 10, otherPatientKey,
+			func(key string, value []byte) (bool, error) { return true, nil })
+		if err == nil || !strings.Contains(err.Error(), "invalid bookmark") {
+			t.Errorf("%s: expected invalid bookmark, got %v", name, err)
+		}
+	}
+}
+
+func TestScanStatePageEndsOnPageBoundary(t *testing.T) {
+	ctx := newFakeContext()
+	putObjects(ctx, "p1", 1, 2, 3, 4)
+	putObjects(ctx, "p2", 5)
+
+	for name, scan := range statePageScanners {
+		keys, pages := scanAll(t, ctx, scan, nil, 2, func(key string) bool { return true })
+		if len(keys) != 4 {
+			t.Errorf("%s: expected 4 objects, got %d", name, len(keys))
+		}
+		// A segunda página acaba na última chave do prefixo e já não devolve bookmark.
+		if pages != 2 {
+			t.Errorf("%s: expected 2 pages, got %d", name, pages)
+		}
+	}
+}
+
+func TestScanStatePageFillsFilteredPages(t *testing.T) {
+	ctx := newFakeContext()
+	putObjects(ctx, "p1", 1, 2, 3, 4, 5, 6, 7)
+
+	// Só os objetos b, d e f são aceites, por isso cada página precisa de
+	// mais do que uma leitura paginada para encher.
+	accept := func(key string) bool {
+		id := strings.TrimSuffix(key, "\x00")
+		return (id[len(id)-1]-'a')%2 == 1
+	}
+
+	for name, scan := range statePageScanners {
+		keys, pages := scanAll(t, ctx, scan, nil, 2, accept)
+		if len(keys) != 3 || pages != 2 {
+			t.Errorf("%s: expected 3 objects in 2 pages, got %d in %d", name, len(keys), pages)
+		}
+		if !sort.StringsAreSorted(keys) {
+			t.Errorf("%s: objects out of order", name)
+		}
+	}
+}
+
+func TestScanStatePageKeyRange(t *testing.T) {
+	ctx := newFakeContext()
+	putObjects(ctx, "p1", -86400, -1, 0, 0, 1, 86400)
+
+	keyRange, err := dateKeyRange(ctx, "Objects", []string{"patientID", "p1"}, sortableDate(-1), sortableDate(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, scan := range statePageScanners {
+		keys, _ := scanAll(t, ctx, scan, keyRange, 2, func(key string) bool { return true })
+		if len(keys) != 3 {
+			t.Errorf("%s: expected the objects dated -1, 0 and 0, got %d", name, len(keys))
+		}
+	}
+}